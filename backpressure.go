@@ -0,0 +1,60 @@
+package gremtune
+
+import "sync"
+
+// backpressure tracks the fill level of the request queue and signals when it crosses its watermarks
+type backpressure struct {
+	mu        sync.Mutex
+	high      int
+	low       int
+	saturated bool
+	closed    bool
+	signal    chan bool
+}
+
+func newBackpressure(high, low int) *backpressure {
+	return &backpressure{high: high, low: low, signal: make(chan bool, 1)}
+}
+
+// observe records the number of queued requests, signalling true when it reaches the high watermark and false
+// once it drops back to the low watermark. A nil backpressure ignores all observations.
+func (b *backpressure) observe(queued int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	switch {
+	case !b.saturated && queued >= b.high:
+		b.saturated = true
+		b.notify(true)
+	case b.saturated && queued <= b.low:
+		b.saturated = false
+		b.notify(false)
+	}
+}
+
+// notify replaces any signal the caller has not received yet, so the channel always holds the latest state
+func (b *backpressure) notify(saturated bool) {
+	select {
+	case <-b.signal:
+	default:
+	}
+	b.signal <- saturated
+}
+
+// close closes the signal channel once no more signals are sent. A nil backpressure is left alone.
+func (b *backpressure) close() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.closed = true
+		close(b.signal)
+	}
+}
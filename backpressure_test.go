@@ -0,0 +1,34 @@
+package gremtune
+
+import "testing"
+
+func TestBackpressureWatermarks(t *testing.T) {
+	c := newClient()
+	SetBackpressure(2, 0)(&c)
+
+	c.dispatchRequest([]byte("1"))
+	select {
+	case <-c.Backpressure():
+		t.Error("Expected no signal below the high watermark")
+	default:
+	}
+
+	c.dispatchRequest([]byte("2"))
+	if saturated := <-c.Backpressure(); !saturated {
+		t.Error("Expected a saturation signal at the high watermark")
+	}
+
+	<-c.requests
+	c.backpressure.observe(len(c.requests))
+	select {
+	case <-c.Backpressure():
+		t.Error("Expected no signal above the low watermark")
+	default:
+	}
+
+	<-c.requests
+	c.backpressure.observe(len(c.requests))
+	if saturated := <-c.Backpressure(); saturated {
+		t.Error("Expected a relief signal at the low watermark")
+	}
+}
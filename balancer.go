@@ -0,0 +1,44 @@
+package gremtune
+
+// Balancer selects which idle connection a Pool hands out next. Select receives the idle connections,
+// most recently used first, and returns the index of the one to use.
+type Balancer interface {
+	Select(idle []*PooledConnection) int
+}
+
+// LeastLatency is a Balancer which prefers the idle connection with the lowest average request latency.
+// Connections which have not served a request yet are preferred so that their latency gets measured.
+type LeastLatency struct{}
+
+// Select returns the index of the idle connection with the lowest average latency
+func (LeastLatency) Select(idle []*PooledConnection) int {
+	best := -1
+	for i, pc := range idle {
+		if pc.Client == nil {
+			continue
+		}
+		if best < 0 || pc.Client.Latency() < idle[best].Client.Latency() {
+			best = i
+		}
+	}
+	return best
+}
+
+// LeastConnections is a Balancer which prefers the idle connection with the fewest requests in flight, so that
+// connections still streaming the response of an asynchronous request are avoided. Ties go to the most recently
+// used connection.
+type LeastConnections struct{}
+
+// Select returns the index of the idle connection with the fewest requests in flight
+func (LeastConnections) Select(idle []*PooledConnection) int {
+	best := -1
+	for i, pc := range idle {
+		if pc.Client == nil {
+			continue
+		}
+		if best < 0 || pc.Client.InFlight() < idle[best].Client.InFlight() {
+			best = i
+		}
+	}
+	return best
+}
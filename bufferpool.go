@@ -0,0 +1,61 @@
+package gremtune
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+// maxPooledBuffer is the capacity from which buffers are not returned to the pool, so that a single huge response
+// does not stay allocated for the life of the client
+const maxPooledBuffer = 1 << 20
+
+// bufferPool recycles the buffers response frames are read into. New buffers are allocated with the moving
+// average size of the frames read so far, so that most frames fit without growing the buffer. Responses themselves
+// are not pooled: they are returned to callers, and kept by them, as values of the []Response of a request.
+type bufferPool struct {
+	pool    sync.Pool
+	average int64
+}
+
+func newBufferPool(sizeHint int) *bufferPool {
+	p := &bufferPool{average: int64(sizeHint)}
+	p.pool.New = func() interface{} {
+		return bytes.NewBuffer(make([]byte, 0, atomic.LoadInt64(&p.average)))
+	}
+	return p
+}
+
+// get returns an empty buffer, nil when there is no pool
+func (p *bufferPool) get() *bytes.Buffer {
+	if p == nil {
+		return nil
+	}
+	return p.pool.Get().(*bytes.Buffer)
+}
+
+// put returns a buffer once nothing refers to its bytes anymore
+func (p *bufferPool) put(buf *bytes.Buffer) {
+	if p == nil || buf == nil {
+		return
+	}
+	for old := atomic.LoadInt64(&p.average); !atomic.CompareAndSwapInt64(&p.average, old, old+(int64(buf.Len())-old)/8); {
+		old = atomic.LoadInt64(&p.average)
+	}
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	buf.Reset()
+	p.pool.Put(buf)
+}
+
+// bufferedReader is implemented by connections which can read a frame into a buffer of the caller
+type bufferedReader interface {
+	readInto(buf *bytes.Buffer) (msgType int, err error)
+}
+
+// limitedReader is implemented by connections which can read a frame into a buffer of the caller up to a size,
+// skipping the rest of a larger frame. The size of the whole frame is returned.
+type limitedReader interface {
+	readLimited(buf *bytes.Buffer, max int64) (msgType int, size int64, err error)
+}
@@ -0,0 +1,113 @@
+package gremtune
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+)
+
+func TestBufferPool(t *testing.T) {
+	p := newBufferPool(64)
+	buf := p.get()
+	if buf.Cap() != 64 {
+		t.Errorf("Expected a buffer of the size hint, got capacity %d", buf.Cap())
+	}
+	buf.Write(bytes.Repeat([]byte("x"), 128))
+	p.put(buf)
+	if buf.Len() != 0 {
+		t.Error("Expected the buffer to be reset when put back")
+	}
+	if p.average != 72 {
+		t.Errorf("Expected the average frame size to follow the frames, got %d", p.average)
+	}
+
+	var none *bufferPool
+	if none.get() != nil {
+		t.Error("Expected no buffer without a pool")
+	}
+	none.put(buf)
+}
+
+func TestBufferPoolConcurrentPut(t *testing.T) {
+	p := newBufferPool(0)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				buf := p.get()
+				buf.Write(bytes.Repeat([]byte("x"), 800))
+				p.put(buf)
+			}
+		}()
+	}
+	wg.Wait()
+	if p.average < 790 || p.average > 800 {
+		t.Errorf("Expected the average to converge to the frame size, got %d", p.average)
+	}
+}
+
+func TestResponseBufferPool(t *testing.T) {
+	s := newTestServer(t, func(conn *websocket.Conn, msg []byte) {
+		var req Request
+		json.Unmarshal(msg[msg[0]+1:], &req)
+		data := fmt.Sprintf(`{"result":{"data":[%q],"meta":{}},"requestId":"%s","status":{"code":200}}`, req.Args["gremlin"], req.RequestID)
+		conn.WriteMessage(websocket.BinaryMessage, []byte(data))
+	})
+	defer s.Close()
+
+	c := startTestClient(t, NewDialer(testServerHost(s)), make(chan error, 1), SetResponseBufferPool(16), SetResponseHandlerWorkers(4))
+	defer c.Shutdown(context.Background())
+
+	// Responses decoded from recycled buffers must not see the bytes of later frames
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			query := fmt.Sprintf("g.V(%d)", i)
+			resp, err := c.Execute(query)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if want := fmt.Sprintf("[%q]", query); len(resp) != 1 || string(resp[0].Result.Data) != want {
+				t.Errorf("Expected %s, got %v", want, resp)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// partialFrameDialer is a fakeDialer failing to read a frame to its end
+type partialFrameDialer struct {
+	fakeDialer
+}
+
+func (d *partialFrameDialer) readInto(buf *bytes.Buffer) (int, error) {
+	buf.WriteString(`{"requestId":`)
+	return websocket.TextMessage, io.ErrUnexpectedEOF
+}
+
+func TestReadWorkerReturnsBufferOnFailedRead(t *testing.T) {
+	c := newClient()
+	c.conn = &partialFrameDialer{}
+	c.buffers = newBufferPool(64)
+	errs := make(chan error, 1)
+
+	c.readWorker(errs, make(chan struct{}))
+	if err := <-errs; errors.Cause(err.(*WorkerError).Err) != io.ErrUnexpectedEOF {
+		t.Errorf("Expected the failed read to end the connection, got %v", err)
+	}
+	if c.buffers.average == 64 {
+		t.Error("Expected the buffer of the failed read to be put back")
+	}
+}
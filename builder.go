@@ -0,0 +1,171 @@
+package gremtune
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// validProcessors lists the processors each op may be sent to
+var validProcessors = map[string][]string{
+	"eval":           {"", "session"},
+	"close":          {"session"},
+	"bytecode":       {"traversal"},
+	"authentication": {"", "session", "traversal"},
+}
+
+// RequestBuilder builds a Request for SubmitAsync step by step. It starts out as an eval request in the
+// gremlin-groovy language, like the requests built by Execute.
+type RequestBuilder struct {
+	op        string
+	processor string
+	args      map[string]interface{}
+	ids       RequestIDGenerator // ids generates the id of built requests, UUIDv4 when nil
+}
+
+// NewRequestBuilder returns a builder for an eval request
+func NewRequestBuilder() *RequestBuilder {
+	return &RequestBuilder{op: "eval", args: map[string]interface{}{"language": "gremlin-groovy"}}
+}
+
+// NewRequestBuilder returns a builder for an eval request whose ids come from the generator of the client, see
+// SetRequestIDGenerator
+func (c *Client) NewRequestBuilder() *RequestBuilder {
+	b := NewRequestBuilder()
+	b.ids = RequestIDGeneratorFunc(c.nextRequestID)
+	return b
+}
+
+// Op sets the operation of the request, such as eval, close or bytecode
+func (b *RequestBuilder) Op(op string) *RequestBuilder {
+	b.op = op
+	return b
+}
+
+// Processor sets the processor handling the request on the server, such as session or traversal
+func (b *RequestBuilder) Processor(processor string) *RequestBuilder {
+	b.processor = processor
+	return b
+}
+
+// WithGremlin sets the script to evaluate
+func (b *RequestBuilder) WithGremlin(query string) *RequestBuilder {
+	b.args["gremlin"] = query
+	return b
+}
+
+// WithBindings sets the bindings of the script, values are encoded like ExecuteWithTypedBindings does
+func (b *RequestBuilder) WithBindings(bindings map[string]interface{}) *RequestBuilder {
+	b.args["bindings"] = bindings
+	return b
+}
+
+// WithSession sends the request within the session with the given id. The processor defaults to session.
+func (b *RequestBuilder) WithSession(session string) *RequestBuilder {
+	b.args["session"] = session
+	if b.processor == "" {
+		b.processor = "session"
+	}
+	return b
+}
+
+// WithAlias makes the graph or traversal source named target available to the script as alias
+func (b *RequestBuilder) WithAlias(alias, target string) *RequestBuilder {
+	aliases, _ := b.args["aliases"].(map[string]string)
+	if aliases == nil {
+		aliases = make(map[string]string)
+		b.args["aliases"] = aliases
+	}
+	aliases[alias] = target
+	return b
+}
+
+// graphNameArg is the request arg some servers route a request to a named graph by, as opposed to aliases
+const graphNameArg = "graphName"
+
+// WithGraphName sends the request to the named graph with the graphName arg, for servers which do not route by
+// aliases
+func (b *RequestBuilder) WithGraphName(name string) *RequestBuilder {
+	b.args[graphNameArg] = name
+	return b
+}
+
+// WithScriptEvaluationTimeout overrides the time the server allows the script to run for
+func (b *RequestBuilder) WithScriptEvaluationTimeout(timeout time.Duration) *RequestBuilder {
+	b.args["scriptEvaluationTimeout"] = int64(timeout / time.Millisecond)
+	return b
+}
+
+// WithLanguage sets the language of the script
+func (b *RequestBuilder) WithLanguage(language string) *RequestBuilder {
+	b.args["language"] = language
+	return b
+}
+
+// Build validates the request and returns it under a new request id. The request gets its own copy of the args,
+// so that the builder can be changed and built again without changing requests built before. It fails when a field required by the op is
+// missing, or when the op cannot be sent to the processor.
+func (b *RequestBuilder) Build() (req Request, err error) {
+	if b.op == "" {
+		return req, errors.New("building request: op is required")
+	}
+	processors, ok := validProcessors[b.op]
+	if !ok {
+		return req, fmt.Errorf("building request: unknown op %q", b.op)
+	}
+	if !containsString(processors, b.processor) {
+		return req, fmt.Errorf("building request: op %q cannot be sent to processor %q", b.op, b.processor)
+	}
+
+	if _, ok := b.args["gremlin"]; !ok && (b.op == "eval" || b.op == "bytecode") {
+		return req, fmt.Errorf("building request: op %q requires a gremlin script", b.op)
+	}
+	if session, _ := b.args["session"].(string); session == "" && b.processor == "session" {
+		return req, errors.New("building request: the session processor requires a session")
+	} else if session != "" && b.processor != "session" {
+		return req, fmt.Errorf("building request: a session cannot be sent to processor %q", b.processor)
+	}
+	if timeout, ok := b.args["scriptEvaluationTimeout"].(int64); ok && timeout < 0 {
+		return req, errors.New("building request: script evaluation timeout cannot be negative")
+	}
+
+	if b.ids != nil {
+		req.RequestID = b.ids.NewID()
+	} else {
+		req.RequestID = newRequestID()
+	}
+	req.Op = b.op
+	req.Processor = b.processor
+	req.Args = make(map[string]interface{}, len(b.args))
+	for k, v := range b.args {
+		switch arg := v.(type) {
+		case map[string]string:
+			copied := make(map[string]string, len(arg))
+			for alias, target := range arg {
+				copied[alias] = target
+			}
+			v = copied
+		case map[string]interface{}:
+			copied := make(map[string]interface{}, len(arg))
+			for name, value := range arg {
+				copied[name] = value
+			}
+			v = copied
+		}
+		req.Args[k] = v
+	}
+	if b.op == "close" {
+		delete(req.Args, "language")
+	}
+	return
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
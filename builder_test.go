@@ -0,0 +1,96 @@
+package gremtune
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRequestBuilder(t *testing.T) {
+	req, err := NewRequestBuilder().
+		WithGremlin("g.V(x)").
+		WithBindings(map[string]interface{}{"x": 1}).
+		WithSession("s1").
+		WithAlias("g", "social").
+		WithGraphName("analytics").
+		WithScriptEvaluationTimeout(2 * time.Second).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req.RequestID == "" || req.Op != "eval" || req.Processor != "session" {
+		t.Errorf("Expected an eval request within the session, got %+v", req)
+	}
+	if req.Args["gremlin"] != "g.V(x)" || req.Args["session"] != "s1" || req.Args["language"] != "gremlin-groovy" {
+		t.Errorf("Unexpected args: %+v", req.Args)
+	}
+	if aliases := req.Args["aliases"].(map[string]string); aliases["g"] != "social" {
+		t.Errorf("Expected alias g for social, got %v", aliases)
+	}
+	if req.Args["graphName"] != "analytics" {
+		t.Errorf("Expected graph name analytics, got %v", req.Args["graphName"])
+	}
+	if req.Args["scriptEvaluationTimeout"] != int64(2000) {
+		t.Errorf("Expected timeout of 2000 ms, got %v", req.Args["scriptEvaluationTimeout"])
+	}
+}
+
+func TestRequestBuilderClose(t *testing.T) {
+	req, err := NewRequestBuilder().Op("close").WithSession("s1").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := req.Args["language"]; ok {
+		t.Error("Expected close requests to carry no language")
+	}
+}
+
+func TestRequestBuilderClientIDs(t *testing.T) {
+	c := newClient()
+	SetRequestIDGenerator(RequestIDGeneratorFunc(func() string { return "id-1" }))(&c)
+
+	req, err := c.NewRequestBuilder().WithGremlin("g.V()").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.RequestID != "id-1" {
+		t.Errorf("Expected the id of the client generator, got %q", req.RequestID)
+	}
+}
+
+func TestRequestBuilderCopiesArgs(t *testing.T) {
+	b := NewRequestBuilder().
+		WithGremlin("g.V(x)").
+		WithBindings(map[string]interface{}{"x": 1}).
+		WithAlias("g", "social")
+	req, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.WithAlias("g", "other")
+	b.args["bindings"].(map[string]interface{})["x"] = 2
+	if aliases := req.Args["aliases"].(map[string]string); aliases["g"] != "social" {
+		t.Errorf("Expected the aliases of the built request to be left as they are, got %v", aliases)
+	}
+	if bindings := req.Args["bindings"].(map[string]interface{}); bindings["x"] != 1 {
+		t.Errorf("Expected the bindings of the built request to be left as they are, got %v", bindings)
+	}
+}
+
+func TestRequestBuilderValidation(t *testing.T) {
+	invalid := map[string]*RequestBuilder{
+		"missing op":         NewRequestBuilder().Op("").WithGremlin("g.V()"),
+		"unknown op":         NewRequestBuilder().Op("nope").WithGremlin("g.V()"),
+		"missing gremlin":    NewRequestBuilder(),
+		"close no session":   NewRequestBuilder().Op("close").Processor("session"),
+		"close no processor": NewRequestBuilder().Op("close"),
+		"session traversal":  NewRequestBuilder().Op("bytecode").Processor("traversal").WithGremlin("g.V()").WithSession("s1"),
+		"negative timeout":   NewRequestBuilder().WithGremlin("g.V()").WithScriptEvaluationTimeout(-time.Second),
+	}
+	for name, b := range invalid {
+		if _, err := b.Build(); err == nil {
+			t.Errorf("%s: expected the request to be invalid", name)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package gremtune
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Bytecode is a traversal in the form Gremlin Language Variants send it, a list of source instructions, such as
+// withStrategies, and a list of step instructions, such as V and out. It is sent with the bytecode op.
+type Bytecode struct {
+	Source [][]interface{}
+	Step   [][]interface{}
+}
+
+// NewBytecode returns an empty traversal, add its steps with AddStep
+func NewBytecode() *Bytecode {
+	return &Bytecode{}
+}
+
+// AddSource appends a source instruction with its arguments
+func (b *Bytecode) AddSource(name string, args ...interface{}) *Bytecode {
+	b.Source = append(b.Source, append([]interface{}{name}, args...))
+	return b
+}
+
+// AddStep appends a step instruction with its arguments, such as AddStep("has", "name", "marko")
+func (b *Bytecode) AddStep(name string, args ...interface{}) *Bytecode {
+	b.Step = append(b.Step, append([]interface{}{name}, args...))
+	return b
+}
+
+// MarshalJSON encodes the traversal as a GraphSON g:Bytecode
+func (b *Bytecode) MarshalJSON() ([]byte, error) {
+	value := map[string]interface{}{}
+	if len(b.Source) > 0 {
+		value["source"] = encodeInstructions(b.Source)
+	}
+	if len(b.Step) > 0 {
+		value["step"] = encodeInstructions(b.Step)
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(typedValue{Type: graphSONBytecode, Value: raw})
+}
+
+const (
+	graphSONBytecode              = "g:Bytecode"
+	graphSONVertexProgramStrategy = "g:VertexProgramStrategy"
+)
+
+func encodeInstructions(instructions [][]interface{}) [][]interface{} {
+	encoded := make([][]interface{}, len(instructions))
+	for i, instruction := range instructions {
+		encoded[i] = make([]interface{}, len(instruction))
+		for j, arg := range instruction {
+			encoded[i][j] = encodeValue(arg)
+		}
+	}
+	return encoded
+}
+
+// GraphComputers maps the short names BulkClient accepts to the graph computer classes they stand for
+var GraphComputers = map[string]string{
+	"spark":       "org.apache.tinkerpop.gremlin.spark.process.computer.SparkGraphComputer",
+	"giraph":      "org.apache.tinkerpop.gremlin.giraph.process.computer.GiraphGraphComputer",
+	"tinkergraph": "org.apache.tinkerpop.gremlin.tinkergraph.process.computer.TinkerGraphComputer",
+	"fulgora":     "org.janusgraph.graphdb.olap.computer.FulgoraGraphComputer",
+}
+
+// ErrUnknownGraphComputer is returned for a graph computer which is neither a short name of GraphComputers nor a
+// fully qualified class name
+var ErrUnknownGraphComputer = errors.New("unknown graph computer")
+
+// graphComputerClass resolves the short names of GraphComputers, fully qualified class names are passed through
+func graphComputerClass(computer string) (string, error) {
+	if class, ok := GraphComputers[strings.ToLower(computer)]; ok {
+		return class, nil
+	}
+	if computer == "" || strings.Contains(computer, ".") {
+		return computer, nil
+	}
+	return "", errors.Wrapf(ErrUnknownGraphComputer, "%q", computer)
+}
+
+// BulkClient runs traversals on a graph computer, for OLAP traversal sources such as SparkGraphComputer on
+// HadoopGraph or JanusGraph. Traversals are sent as bytecode to the traversal processor.
+type BulkClient struct {
+	*Client
+	Source string // Source is the traversal source the traversals are run on, g when empty
+}
+
+// NewBulkClient returns a bulk client sending its traversals over the client
+func NewBulkClient(c *Client) *BulkClient {
+	return &BulkClient{Client: c}
+}
+
+// Execute runs the traversal on the graph computer and returns the result, giving up when ctx is done. The computer
+// is either a short name of GraphComputers, such as spark, or a graph computer class passed through verbatim. An
+// empty computer runs the traversal on the default graph computer of the graph.
+func (b *BulkClient) Execute(ctx context.Context, traversal *Bytecode, computer string) (resp []Response, err error) {
+	if b.conn.IsDisposed() {
+		return resp, ErrDisposed
+	}
+	class, err := graphComputerClass(computer)
+	if err != nil {
+		return
+	}
+
+	strategy := map[string]interface{}{}
+	if class != "" {
+		strategy["graphComputer"] = class
+	}
+	raw, err := json.Marshal(strategy)
+	if err != nil {
+		return
+	}
+	olap := &Bytecode{
+		Source: append([][]interface{}{{"withStrategies", typedValue{Type: graphSONVertexProgramStrategy, Value: raw}}}, traversal.Source...),
+		Step:   traversal.Step,
+	}
+
+	source := b.Source
+	if source == "" {
+		source = "g"
+	}
+	req := Request{
+		Op:        "bytecode",
+		Processor: "traversal",
+		Args: map[string]interface{}{
+			"gremlin": olap,
+			"aliases": map[string]string{"g": source},
+		},
+	}
+	return b.roundTripContext(ctx, req)
+}
@@ -0,0 +1,72 @@
+package gremtune
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestBulkClientExecute(t *testing.T) {
+	c, fake := startFakeClient(t)
+	bulk := NewBulkClient(c)
+
+	traversal := NewBytecode().AddStep("V").AddStep("pageRank").AddStep("limit", 10)
+	if _, err := bulk.Execute(context.Background(), traversal, "spark"); err != nil {
+		t.Fatal(err)
+	}
+
+	req := writtenRequests(t, fake)[0]
+	if req.Op != "bytecode" || req.Processor != "traversal" {
+		t.Errorf("Expected a bytecode request to the traversal processor, got %s to %s", req.Op, req.Processor)
+	}
+	raw, _ := json.Marshal(req.Args["gremlin"])
+	var bytecode struct {
+		Type  string `json:"@type"`
+		Value struct {
+			Source [][]json.RawMessage `json:"source"`
+			Step   [][]interface{}     `json:"step"`
+		} `json:"@value"`
+	}
+	if err := json.Unmarshal(raw, &bytecode); err != nil {
+		t.Fatal(err)
+	}
+	if bytecode.Type != "g:Bytecode" || len(bytecode.Value.Step) != 3 || bytecode.Value.Step[2][1] != 10.0 {
+		t.Errorf("Unexpected bytecode: %s", raw)
+	}
+	var strategy typedValue
+	json.Unmarshal(bytecode.Value.Source[0][1], &strategy)
+	if strategy.Type != "g:VertexProgramStrategy" || string(strategy.Value) != `{"graphComputer":"`+GraphComputers["spark"]+`"}` {
+		t.Errorf("Expected the traversal to run on SparkGraphComputer, got %s", raw)
+	}
+}
+
+func TestBulkClientReadOnly(t *testing.T) {
+	c, fake := startFakeClient(t)
+	SetReadOnly()(c)
+	bulk := NewBulkClient(c)
+
+	traversal := NewBytecode().AddStep("V").AddStep("property", "rank", 1)
+	if _, err := bulk.Execute(context.Background(), traversal, "spark"); errors.Cause(err) != ErrReadOnly {
+		t.Errorf("Expected the property step to be rejected, got %v", err)
+	}
+	if len(fake.written) != 0 {
+		t.Error("Expected nothing to be sent")
+	}
+}
+
+func TestGraphComputerClass(t *testing.T) {
+	for computer, want := range map[string]string{
+		"":                           "",
+		"Spark":                      GraphComputers["spark"],
+		"com.example.CustomComputer": "com.example.CustomComputer",
+	} {
+		if class, err := graphComputerClass(computer); err != nil || class != want {
+			t.Errorf("Expected %q for computer %q, got %q: %v", want, computer, class, err)
+		}
+	}
+	if _, err := graphComputerClass("hadoop"); errors.Cause(err) != ErrUnknownGraphComputer {
+		t.Errorf("Expected ErrUnknownGraphComputer, got %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package gremtune
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Instruction is a single step of a traversal, such as {Operator: "has", Arguments: []interface{}{"name", "marko"}}.
+// Arguments of a dedicated GraphSON type, such as int64 or uuid.UUID, are sent typed.
+type Instruction struct {
+	Operator  string
+	Arguments []interface{}
+}
+
+// NewBytecodeFromSteps returns the traversal made of steps, in order, for traversals built programmatically
+func NewBytecodeFromSteps(steps []Instruction) *Bytecode {
+	b := NewBytecode()
+	for _, step := range steps {
+		b.AddStep(step.Operator, step.Arguments...)
+	}
+	return b
+}
+
+// ExecuteBytecode sends a traversal as bytecode to the traversal processor and returns the result, giving up when ctx
+// is done. The traversal runs on the traversal source named source of the server, g when empty. Instructions
+// without an operator are rejected, like the checks of the client reject scripts, see SetReadOnly.
+func (c *Client) ExecuteBytecode(ctx context.Context, source string, traversal *Bytecode) (resp []Response, err error) {
+	if c.conn.IsDisposed() {
+		return resp, ErrDisposed
+	}
+	if len(traversal.Step) == 0 {
+		return nil, errors.New("the traversal has no steps")
+	}
+	if source == "" {
+		source = "g"
+	}
+	req := Request{
+		Op:        "bytecode",
+		Processor: "traversal",
+		Args: map[string]interface{}{
+			"gremlin": traversal,
+			"aliases": map[string]string{"g": source},
+		},
+	}
+	return c.roundTripContext(ctx, req)
+}
+
+// ExecuteSteps sends the traversal made of steps as bytecode to the traversal source named source, see
+// ExecuteBytecode
+func (c *Client) ExecuteSteps(ctx context.Context, source string, steps []Instruction) (resp []Response, err error) {
+	return c.ExecuteBytecode(ctx, source, NewBytecodeFromSteps(steps))
+}
+
+// bytecodeInstructions returns the source and step instructions of the bytecode of a request, either as built or
+// as decoded from a prebuilt request
+func bytecodeInstructions(v interface{}) ([][]interface{}, bool) {
+	switch b := v.(type) {
+	case *Bytecode:
+		return append(append([][]interface{}{}, b.Source...), b.Step...), true
+	case map[string]interface{}:
+		value, isBytecode := b["@value"].(map[string]interface{})
+		if b["@type"] != graphSONBytecode || !isBytecode {
+			return nil, false
+		}
+		var instructions [][]interface{}
+		for _, key := range []string{"source", "step"} {
+			list, _ := value[key].([]interface{})
+			for _, item := range list {
+				instruction, _ := item.([]interface{})
+				instructions = append(instructions, instruction)
+			}
+		}
+		return instructions, true
+	}
+	return nil, false
+}
+
+// bytecodeScript spells out instructions as a script, such as g.V().has("name", "marko"), with JSON arguments
+func bytecodeScript(instructions [][]interface{}) string {
+	var script strings.Builder
+	script.WriteString("g")
+	for _, instruction := range instructions {
+		if len(instruction) == 0 {
+			continue
+		}
+		fmt.Fprintf(&script, ".%v(", instruction[0])
+		for i, arg := range instruction[1:] {
+			if i > 0 {
+				script.WriteString(", ")
+			}
+			encoded, _ := json.Marshal(arg)
+			script.Write(encoded)
+		}
+		script.WriteString(")")
+	}
+	return script.String()
+}
@@ -0,0 +1,112 @@
+package gremtune
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// knownBytecode is g.V().hasLabel('person').out('knows').limit(10L) as serialized by gremlin-java in GraphSON 3.0
+const knownBytecode = `{"@type":"g:Bytecode","@value":{"step":[["V"],["hasLabel","person"],["out","knows"],["limit",{"@type":"g:Int64","@value":10}]]}}`
+
+var knownSteps = []Instruction{
+	{Operator: "V"},
+	{Operator: "hasLabel", Arguments: []interface{}{"person"}},
+	{Operator: "out", Arguments: []interface{}{"knows"}},
+	{Operator: "limit", Arguments: []interface{}{int64(10)}},
+}
+
+func TestBytecodeFromSteps(t *testing.T) {
+	raw, err := json.Marshal(NewBytecodeFromSteps(knownSteps))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, want interface{}
+	json.Unmarshal(raw, &got)
+	json.Unmarshal([]byte(knownBytecode), &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %s, got %s", knownBytecode, raw)
+	}
+}
+
+func TestExecuteSteps(t *testing.T) {
+	c, fake := startFakeClient(t)
+
+	if _, err := c.ExecuteSteps(context.Background(), "", knownSteps); err != nil {
+		t.Fatal(err)
+	}
+	req := writtenRequests(t, fake)[0]
+	if req.Op != "bytecode" || req.Processor != "traversal" {
+		t.Errorf("Expected a bytecode request to the traversal processor, got %s to %s", req.Op, req.Processor)
+	}
+	raw, _ := json.Marshal(req.Args["gremlin"])
+	var got, want interface{}
+	json.Unmarshal(raw, &got)
+	json.Unmarshal([]byte(knownBytecode), &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %s to be sent, got %s", knownBytecode, raw)
+	}
+}
+
+func TestExecuteStepsWithoutOperator(t *testing.T) {
+	c, fake := startFakeClient(t)
+
+	if _, err := c.ExecuteSteps(context.Background(), "", []Instruction{{Operator: "V"}, {}}); err == nil {
+		t.Error("Expected a step without an operator to be rejected")
+	}
+	if _, err := c.ExecuteSteps(context.Background(), "", nil); err == nil {
+		t.Error("Expected a traversal without steps to be rejected")
+	}
+	if _, err := c.ExecuteBytecode(context.Background(), "", &Bytecode{Step: [][]interface{}{{}}}); err == nil {
+		t.Error("Expected an empty instruction to be rejected")
+	}
+	if len(fake.written) != 0 {
+		t.Errorf("Expected nothing to be sent, got %d requests", len(fake.written))
+	}
+}
+
+func TestExecuteStepsSource(t *testing.T) {
+	c, fake := startFakeClient(t)
+
+	if _, err := c.ExecuteSteps(context.Background(), "modern", knownSteps); err != nil {
+		t.Fatal(err)
+	}
+	aliases, _ := writtenRequests(t, fake)[0].Args["aliases"].(map[string]interface{})
+	if aliases["g"] != "modern" {
+		t.Errorf("Expected the traversal to run on the modern traversal source, got %v", aliases)
+	}
+}
+
+func TestExecuteStepsReadOnly(t *testing.T) {
+	c, fake := startFakeClient(t)
+	SetReadOnly()(c)
+
+	if _, err := c.ExecuteSteps(context.Background(), "", []Instruction{{Operator: "addV", Arguments: []interface{}{"person"}}}); errors.Cause(err) != ErrReadOnly {
+		t.Errorf("Expected the addV step to be rejected, got %v", err)
+	}
+	if _, err := c.ExecuteSteps(context.Background(), "", knownSteps); err != nil {
+		t.Error(err)
+	}
+	if len(fake.written) != 1 {
+		t.Errorf("Expected only the read to be sent, got %d requests", len(fake.written))
+	}
+}
+
+func TestExecuteStepsValidator(t *testing.T) {
+	c, _ := startFakeClient(t)
+	var validated string
+	SetQueryValidator(func(query string) error {
+		validated = query
+		return nil
+	})(c)
+
+	if _, err := c.ExecuteSteps(context.Background(), "", knownSteps); err != nil {
+		t.Fatal(err)
+	}
+	if expected := `g.V().hasLabel("person").out("knows").limit(10)`; validated != expected {
+		t.Errorf("Expected the validator to check %s, got %s", expected, validated)
+	}
+}
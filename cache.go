@@ -0,0 +1,121 @@
+package gremtune
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mutatingOperators are the steps which write to the graph
+var mutatingOperators = []string{"addV", "addE", "mergeV", "mergeE", "property", "drop"}
+
+// mutatingSteps matches the steps which mark a query as a write, results of such queries are never cached
+var mutatingSteps = regexp.MustCompile(`\b(` + strings.Join(mutatingOperators, "|") + `)\s*\(`)
+
+// resultCache is an LRU cache of the responses of read queries, entries expire after ttl
+type resultCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List // order holds the entries from most to least recently used
+	clock      clock      // clock expires the entries, the real clock when nil
+}
+
+type cacheEntry struct {
+	key     string
+	resp    []Response
+	expires time.Time
+}
+
+func newResultCache(ttl time.Duration, maxEntries int) *resultCache {
+	return &resultCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// isMutating reports whether the query contains a step which writes to the graph
+func isMutating(query string) bool {
+	return mutatingStep(query) != ""
+}
+
+// mutatingStep returns the name of the first step of the query which writes to the graph, if it has one
+func mutatingStep(query string) string {
+	if match := mutatingSteps.FindStringSubmatch(query); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// cacheKey hashes the request arguments, which hold the query and its bindings. Maps are encoded with sorted
+// keys, so the key does not depend on the order bindings were added in.
+func cacheKey(args map[string]interface{}) string {
+	if bindings, ok := args["bindings"].(map[string]interface{}); ok { // Keep typed bindings apart from strings
+		encoded := make(map[string]interface{}, len(args))
+		for k, v := range args {
+			encoded[k] = v
+		}
+		encoded["bindings"] = encodeBindings(bindings)
+		args = encoded
+	}
+	j, _ := json.Marshal(args)
+	sum := sha256.Sum256(j)
+	return hex.EncodeToString(sum[:])
+}
+
+// get returns a copy of the cached responses marked as coming from the cache
+func (rc *resultCache) get(key string) ([]Response, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	el, ok := rc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if orRealClock(rc.clock).Now().After(entry.expires) {
+		rc.order.Remove(el)
+		delete(rc.entries, key)
+		return nil, false
+	}
+	rc.order.MoveToFront(el)
+	resp := make([]Response, len(entry.resp))
+	for i, r := range entry.resp {
+		r.FromCache = true
+		resp[i] = r
+	}
+	return resp, true
+}
+
+// put stores the responses, evicting the least recently used entry when the cache is full
+func (rc *resultCache) put(key string, resp []Response) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	entry := &cacheEntry{key: key, resp: append([]Response(nil), resp...), expires: orRealClock(rc.clock).Now().Add(rc.ttl)}
+	if el, ok := rc.entries[key]; ok {
+		el.Value = entry
+		rc.order.MoveToFront(el)
+		return
+	}
+	rc.entries[key] = rc.order.PushFront(entry)
+	if rc.maxEntries > 0 && rc.order.Len() > rc.maxEntries {
+		oldest := rc.order.Back()
+		rc.order.Remove(oldest)
+		delete(rc.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// flush removes all entries from the cache
+func (rc *resultCache) flush() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.entries = make(map[string]*list.Element)
+	rc.order.Init()
+}
@@ -0,0 +1,54 @@
+package gremtune
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResultCache(t *testing.T) {
+	rc := newResultCache(time.Minute, 2)
+	a := cacheKey(map[string]interface{}{"gremlin": "g.V(x)", "bindings": map[string]string{"x": "1"}})
+	b := cacheKey(map[string]interface{}{"gremlin": "g.V(x)", "bindings": map[string]string{"x": "2"}})
+	c := cacheKey(map[string]interface{}{"gremlin": "g.V()"})
+
+	rc.put(a, []Response{dummySuccessfulResponseMarshalled})
+	rc.put(b, []Response{dummySuccessfulResponseMarshalled})
+
+	resp, ok := rc.get(a)
+	if !ok || len(resp) != 1 || !resp[0].FromCache {
+		t.Error("Expected cached response to be returned and marked as coming from the cache")
+	}
+
+	rc.put(c, []Response{dummySuccessfulResponseMarshalled}) // Evicts b, the least recently used entry
+
+	if _, ok := rc.get(b); ok {
+		t.Error("Expected least recently used entry to be evicted")
+	}
+	if _, ok := rc.get(a); !ok {
+		t.Error("Expected recently used entry to remain cached")
+	}
+
+	rc.flush()
+	if _, ok := rc.get(a); ok {
+		t.Error("Expected flush to empty the cache")
+	}
+}
+
+func TestResultCacheExpiry(t *testing.T) {
+	rc := newResultCache(time.Millisecond, 0)
+	rc.put("key", []Response{dummySuccessfulResponseMarshalled})
+	time.Sleep(5 * time.Millisecond)
+
+	if _, ok := rc.get("key"); ok {
+		t.Error("Expected expired entry not to be returned")
+	}
+}
+
+func TestIsMutating(t *testing.T) {
+	if !isMutating("g.V('1').property('name', 'x')") || !isMutating("g.V().drop()") {
+		t.Error("Expected writes to be detected as mutating")
+	}
+	if isMutating("g.V('1').properties('name')") {
+		t.Error("Expected reads not to be detected as mutating")
+	}
+}
@@ -0,0 +1,72 @@
+package gremtune
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// HashAlgo is the hash algorithm a certificate fingerprint is computed with
+type HashAlgo int
+
+const (
+	// SHA256 fingerprints certificates with SHA-256
+	SHA256 HashAlgo = iota
+	// SHA512 fingerprints certificates with SHA-512
+	SHA512
+)
+
+// ErrCertificatePinMismatch is the cause of a CertificatePinError
+var ErrCertificatePinMismatch = errors.New("the server certificate does not match the pinned fingerprint")
+
+// CertificatePinError is returned by the dial when the certificate of the server does not have the fingerprint
+// pinned with SetCertificatePin
+type CertificatePinError struct {
+	Expected string
+	Actual   string
+}
+
+func (e *CertificatePinError) Error() string {
+	return fmt.Sprintf("%s: expected %s, got %s", ErrCertificatePinMismatch, e.Expected, e.Actual)
+}
+
+// Cause returns ErrCertificatePinMismatch, for use with errors.Cause
+func (e *CertificatePinError) Cause() error {
+	return ErrCertificatePinMismatch
+}
+
+// fingerprint returns the hex encoded hash of a DER encoded certificate
+func (a HashAlgo) fingerprint(raw []byte) string {
+	if a == SHA512 {
+		sum := sha512.Sum512(raw)
+		return hex.EncodeToString(sum[:])
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeFingerprint accepts fingerprints in either case and with the colons openssl separates bytes with
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.Replace(fingerprint, ":", "", -1))
+}
+
+// pinCertificate makes the TLS config accept only servers whose leaf certificate has the fingerprint. The chain
+// of the certificate is not verified, the pin replaces the PKI.
+func pinCertificate(config *tls.Config, fingerprint string, algo HashAlgo) {
+	expected := normalizeFingerprint(fingerprint)
+	config.InsecureSkipVerify = true
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return &CertificatePinError{Expected: expected}
+		}
+		if actual := algo.fingerprint(state.PeerCertificates[0].Raw); actual != expected {
+			return &CertificatePinError{Expected: expected, Actual: actual}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,47 @@
+package gremtune
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestCertificatePin(t *testing.T) {
+	s := httptest.NewTLSServer(testServerHandler(t))
+	defer s.Close()
+	host := "wss" + strings.TrimPrefix(s.URL, "https")
+	sum := sha256.Sum256(s.Certificate().Raw)
+	fingerprint := hex.EncodeToString(sum[:])
+
+	ws := NewDialer(host, SetCertificatePin(strings.ToUpper(fingerprint), SHA256))
+	if err := ws.connect(); err != nil {
+		t.Fatalf("Expected the pinned self-signed certificate to be accepted, got: %v", err)
+	}
+	ws.close()
+
+	ws = NewDialer(host, SetCertificatePin(strings.Repeat("00", 64), SHA512))
+	err := ws.connect()
+	if errors.Cause(err) != ErrCertificatePinMismatch {
+		t.Fatalf("Expected ErrCertificatePinMismatch, got: %v", err)
+	}
+	if pinErr := err.(*CertificatePinError); len(pinErr.Actual) != 128 {
+		t.Errorf("Expected the SHA-512 fingerprint of the certificate, got %q", pinErr.Actual)
+	}
+}
+
+func TestCertificatePinKeepsTLSConfig(t *testing.T) {
+	ws := &Ws{tlsConfig: &tls.Config{ServerName: "graph.internal"}}
+	SetCertificatePin("00", SHA256)(ws)
+
+	if ws.tlsConfig.ServerName != "graph.internal" {
+		t.Errorf("Expected the existing TLS configuration to be kept, got server name %q", ws.tlsConfig.ServerName)
+	}
+	if ws.tlsConfig.VerifyConnection == nil {
+		t.Error("Expected the pin to be added to the existing TLS configuration")
+	}
+}
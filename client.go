@@ -1,106 +1,949 @@
 package gremtune
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/pkg/errors"
 )
 
-// Client is a container for the gremtune client.
+const (
+	pingQuery   = "g.inject(0).count()"
+	pingTimeout = 5 * time.Second
+)
+
+const (
+	maxResetRetries  = 3 // maxResetRetries bounds the retries of requests interrupted by a reset or throttled by the server
+	retryWaitTimeout = 15 * time.Second
+	closeWaitTimeout = 10 * time.Second // closeWaitTimeout bounds the wait of Close for the workers to exit
+)
+
+// Defaults of the dialers returned by NewDialer and NewSecureDialer
+const (
+	defaultTimeout      = 5 * time.Second
+	defaultPingInterval = 60 * time.Second
+	defaultWritingWait  = 15 * time.Second
+	defaultReadingWait  = 15 * time.Second
+	defaultAuthTimeout  = 10 * time.Second
+)
+
+// ErrReset is returned to requests that were still awaiting a response when the client was reset.
+var ErrReset = errors.New("client has been reset")
+
+// ErrFirstFrameTimeout is returned when no frame of a response arrived within the first frame timeout of the client
+var ErrFirstFrameTimeout = errors.New("no response frame arrived within the first frame timeout")
+
+// ErrEvaluationTimeoutExceeded is returned for a request whose response did not complete within the evaluation
+// timeout it asked the server for, plus the evaluation timeout margin of the client
+var ErrEvaluationTimeoutExceeded = errors.New("the server did not answer within the evaluation timeout of the request")
+
+// ErrMutationNotRetried is returned instead of ErrReset when retries after a reset are enabled, but the interrupted
+// request may have mutated the graph already. The caller has to decide whether it is safe to send it again.
+var ErrMutationNotRetried = errors.New("request was interrupted by a reset and is not retried as it may mutate the graph")
+
+// ErrTooManyGoroutines is returned by SubmitAsync when the client already runs the goroutines set by SetMaxGoroutines
+var ErrTooManyGoroutines = errors.New("the client runs the maximum number of goroutines")
+
+// ReconnectHook is run on a freshly reconnected connection before it accepts regular requests again.
+// Queries passed to execute are written directly to the new connection, regular requests stay queued
+// until the hook returns.
+type ReconnectHook func(execute func(query string) ([]Response, error)) error
+
+// FrameHandler receives each response frame as it arrives when aggregation of responses is disabled.
+type FrameHandler func(frame Response)
+
+// Client is a container for the gremtune client. A Client is safe for concurrent use: any number of goroutines may
+// execute requests on it at the same time, requests are multiplexed over its single connection and every response
+// is routed back to the request it answers by the request id. Its configuration must not change while it is in use.
 type Client struct {
-	conn             dialer
-	requests         chan []byte
-	responses        chan []byte
-	results          *sync.Map
-	responseNotifier *sync.Map // responseNotifier notifies the requester that a response has arrived for the request
+	conn              dialer
+	errs              chan error
+	requests          chan []byte
+	responses         chan []byte
+	results           *sync.Map
+	frameOrder        *sync.Map // frameOrder holds the arrival sequence of the frames aggregated in results
+	resultSizes       *sync.Map // resultSizes holds the bytes of result data aggregated per request, see MaxResultSize
+	frameSeq          uint64
+	firstFrames       *sync.Map // firstFrames holds a channel per request closed by its first frame, with a first frame timeout
+	responseNotifier  *sync.Map // responseNotifier notifies the requester that a response has arrived for the request
+	onReconnect       ReconnectHook
+	initQuery         string // initQuery runs once on every new connection before it accepts regular requests
+	serializer        Serializer
+	frameHandler      FrameHandler // frameHandler receives every response frame instead of them being aggregated
+	frameHandlers     *sync.Map    // frameHandlers hold the handlers of single requests, whose frames are not aggregated
+	cache             *resultCache
+	flushOnMutation   bool           // flushOnMutation flushes the result cache whenever a mutating query is executed
+	configs           []ClientConfig // configs are kept so that Clone can configure a new client the same way
+	latency           int64          // latency is the moving average of request round trips in nanoseconds
+	inFlight          int64          // inFlight is the number of requests dispatched and awaiting their response
+	stats             *clientStats
+	backpressure      *backpressure
+	requestIDs        RequestIDGenerator
+	requestTimeout    time.Duration // requestTimeout bounds every request, 0 lets requests wait for their response
+	firstFrameTimeout time.Duration
+	metrics           MetricsCollector
+	maxScriptSize     int // maxScriptSize is the length in bytes from which scripts are rejected, 0 allows any length
+	validator         QueryValidator
+	readOnly          bool            // readOnly rejects scripts with mutating steps, see SetReadOnly
+	resultShape       string          // resultShape is the step appended to scripts without a projection, see SetResultShape
+	reservedBindings  map[string]bool // reservedBindings are the names of the scripts bindings must not use
+	retryDecision     RetryDecision
+	errorQuery        *errorQuery     // errorQuery attaches the query to the errors of failed requests when set
+	workers           *sync.WaitGroup // workers tracks every goroutine started by the client, so Shutdown can wait for them
+	goroutines        int64           // goroutines counts the goroutines of workers which are running, accessed atomically
+	maxGoroutines     int64           // maxGoroutines caps goroutines for SubmitAsync, see SetMaxGoroutines, 0 is unlimited
+	shutdown          chan struct{}   // shutdown is closed once the client is shut down
+	retryReadOnly     bool            // retryReadOnly retries read only queries interrupted by a reset
+	unsent            *sync.Map       // unsent holds the ids of requests not written yet, when they outlive a reset
+	held              chan []byte     // held keeps the request whose write failed, to be written first on the next connection
+	writeCoalescing   time.Duration   // writeCoalescing is the window requests are gathered in before being written
+	clock             clock           // clock times requests, retries and write coalescing, the real clock when nil
+	responseWorkers   int             // responseWorkers is the number of goroutines handling response frames, 0 handles them in the read worker
+	scriptFiles       *scriptFiles    // scriptFiles caches the scripts read by ExecuteFileQuery
+	buffers           *bufferPool     // buffers recycles the buffers response frames are read into, nil allocates every frame
+	graphName         string          // graphName routes every script to the named graph when set, see SetGraphName
+	resetMu           sync.Mutex      // resetMu serializes resets, which dial without holding the lock of the client
+	rawResponses      bool            // rawResponses keeps the frame of every response, see Response.Raw
+	outbound          *outbound       // outbound counts the requests queued for writing, see Flush
+	evaluationMargin  time.Duration   // evaluationMargin is how long past their evaluation timeout requests are failed
 	sync.RWMutex
-	Errored bool
+	Errored bool // Errored is set once the connection failed, use IsErrored while the client is in use
+	// MaxResponseSize is the size in bytes from which response frames fail their request with ErrResponseTooLarge
+	// instead of being decoded, 0 allows any size. It must be set before the client is used, see SetMaxResponseSize.
+	MaxResponseSize int64
+	// MaxResultSize is the size in bytes of the result data of all the frames of a request from which the request
+	// fails with ErrResultTooLarge, 0 allows any size. It must be set before the client is used, see SetMaxResultSize.
+	MaxResultSize int64
+}
+
+// IsErrored reports whether the connection of the client failed, safe to call while the workers are running
+func (c *Client) IsErrored() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.Errored
 }
 
-// NewDialer returns a WebSocket dialer to use when connecting to Gremlin Server
+// String describes the client for logs and debugging, such as
+// Client{host:"wss://localhost:8182", connected:true, inFlight:3, errored:false, uptime:2m34s}
+func (c *Client) String() string {
+	host, connected := "", false
+	if c.conn != nil {
+		connected = c.conn.IsConnected()
+		if ws, ok := c.conn.(*Ws); ok {
+			host = ws.ActualHost()
+		}
+	}
+	return fmt.Sprintf("Client{host:%q, connected:%t, inFlight:%d, errored:%t, uptime:%s}",
+		host, connected, c.InFlight(), c.IsErrored(), c.Stats().Uptime.Round(time.Second))
+}
+
+// setErrored sets the Errored state under the lock of the client
+func (c *Client) setErrored(errored bool) {
+	c.Lock()
+	c.Errored = errored
+	c.Unlock()
+}
+
+// NewDialer returns a WebSocket dialer to use when connecting to Gremlin Server. The dialer does not authenticate,
+// use NewSecureDialer or SetCredentials for servers requiring authentication.
 func NewDialer(host string, configs ...DialerConfig) (dialer *Ws) {
 	dialer = &Ws{
-		timeout:      5 * time.Second,
-		pingInterval: 60 * time.Second,
-		writingWait:  15 * time.Second,
-		readingWait:  15 * time.Second,
+		timeout:      defaultTimeout,
+		pingInterval: defaultPingInterval,
+		writingWait:  defaultWritingWait,
+		readingWait:  defaultReadingWait,
+		authTimeout:  defaultAuthTimeout,
+		closeTimeout: 1 * time.Second,
 		connected:    false,
 		quit:         make(chan struct{}),
+		logger:       stdLogger{},
 	}
 
 	for _, conf := range configs {
 		conf(dialer)
 	}
 
-	dialer.host = host
+	dialer.configs = configs
+	if h, ok := withDefaultScheme(host); ok {
+		dialer.getLogger().Info("Hosts without a scheme are deprecated, assuming ws://", "host", host)
+		host = h
+	}
+	dialer.host = normalizeHost(host)
 	return dialer
 }
 
+// ParseDialer is like NewDialer, but fails with an *InvalidSchemeError right away when the scheme of the host is
+// neither ws nor wss, rather than when the dialer connects.
+func ParseDialer(host string, configs ...DialerConfig) (*Ws, error) {
+	dialer := NewDialer(host, configs...)
+	if err := checkScheme(dialer.host); err != nil {
+		return nil, err
+	}
+	return dialer, nil
+}
+
+// NewSecureDialer returns a WebSocket dialer which authenticates with Gremlin Server using the credentials
+func NewSecureDialer(host, username, password string, configs ...DialerConfig) (dialer *Ws) {
+	return NewDialer(host, append([]DialerConfig{SetCredentials(username, password)}, configs...)...)
+}
+
 func newClient() (c Client) {
 	c.requests = make(chan []byte, 3)  // c.requests takes any request and delivers it to the WriteWorker for dispatch to Gremlin Server
 	c.responses = make(chan []byte, 3) // c.responses takes raw responses from ReadWorker and delivers it for sorting to handelResponse
 	c.results = &sync.Map{}
+	c.frameOrder = &sync.Map{}
+	c.resultSizes = &sync.Map{}
+	c.frameHandlers = &sync.Map{}
+	c.firstFrames = &sync.Map{}
 	c.responseNotifier = &sync.Map{}
+	c.serializer = GraphSONSerializer{}
+	c.stats = newClientStats()
+	c.workers = &sync.WaitGroup{}
+	c.shutdown = make(chan struct{})
+	c.held = make(chan []byte, 1)
+	c.scriptFiles = newScriptFiles()
+	c.outbound = newOutbound()
 	return
 }
 
-// Dial returns a gremtune client for interaction with the Gremlin Server specified in the host IP.
-func Dial(conn dialer, errs chan error) (c Client, err error) {
-	c = newClient()
+// Dial returns a gremtune client for interaction with the Gremlin Server specified in the host IP. The workers of
+// the client share it with the caller, so a connection failure they notice is reported by IsErrored.
+func Dial(conn dialer, errs chan error, configs ...ClientConfig) (c *Client, err error) {
+	return DialContext(context.Background(), conn, errs, configs...)
+}
+
+// DialContext is like Dial, but gives up connecting when ctx is done, returning ctx.Err(). The handshake timeout of
+// the dialer still applies, whichever ends first bounds the handshake. ctx only bounds connecting, not the client.
+func DialContext(ctx context.Context, conn dialer, errs chan error, configs ...ClientConfig) (c *Client, err error) {
+	client := newClient()
+	c = &client
 	c.conn = conn
+	c.errs = errs
+	c.configs = configs
+
+	for _, conf := range configs {
+		conf(c)
+	}
 
 	// Connects to Gremlin Server
-	err = conn.connect()
+	if ws, ok := conn.(*Ws); ok {
+		ws.serializer = c.serializer // The authentication exchange speaks the serialization of the client
+		err = ws.connectContext(ctx)
+	} else {
+		err = conn.connect()
+	}
 	if err != nil {
 		return
 	}
+	c.stats.connected(false)
 
-	quit := conn.(*Ws).quit
+	quit := conn.(*Ws).quitChan()
 
-	go c.writeWorker(errs, quit)
-	go c.readWorker(errs, quit)
-	go conn.ping(errs)
+	c.goWorker(func() { c.readWorker(errs, quit) })
+	if err = c.runInitQuery(); err != nil {
+		conn.close()
+		return
+	}
+	c.goWorker(func() { c.writeWorker(errs, quit) })
+	c.goWorker(func() { conn.ping(errs) })
 
 	return
 }
 
+// runInitQuery runs the init query of the client, if it has one, on a new connection. The write worker is not
+// running yet, so regular requests queue until it is done.
+func (c *Client) runInitQuery() error {
+	if c.initQuery == "" {
+		return nil
+	}
+	if _, err := c.executeDirect(c.initQuery); err != nil {
+		return errors.Wrap(err, "init query")
+	}
+	return nil
+}
+
+// goWorker runs f in a goroutine which Shutdown waits for. Every goroutine of the client is started with it, so
+// that it is counted by Goroutines.
+func (c *Client) goWorker(f func()) {
+	atomic.AddInt64(&c.goroutines, 1)
+	c.runWorker(f)
+}
+
+// reserveWorker counts a goroutine about to be started with runWorker, unless the client already runs the maximum
+// number of goroutines. A reserved goroutine which is not started is given back with releaseWorker.
+func (c *Client) reserveWorker() bool {
+	for {
+		n := atomic.LoadInt64(&c.goroutines)
+		if c.maxGoroutines > 0 && n >= c.maxGoroutines {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&c.goroutines, n, n+1) {
+			return true
+		}
+	}
+}
+
+func (c *Client) releaseWorker() {
+	atomic.AddInt64(&c.goroutines, -1)
+}
+
+// runWorker runs f in a goroutine already counted by goWorker or reserveWorker
+func (c *Client) runWorker(f func()) {
+	if c.workers == nil {
+		go func() {
+			defer atomic.AddInt64(&c.goroutines, -1)
+			f()
+		}()
+		return
+	}
+	c.workers.Add(1)
+	go func() {
+		defer c.workers.Done()
+		defer atomic.AddInt64(&c.goroutines, -1)
+		f()
+	}()
+}
+
+// Goroutines returns the number of goroutines the client is running, such as its read, write and ping workers,
+// response handlers and reconnects. It drops to 0 once Close or Shutdown returned without error, a debug aid to
+// spot leaked clients.
+func (c *Client) Goroutines() int {
+	return int(atomic.LoadInt64(&c.goroutines))
+}
+
+// isShutdown reports whether the client has been shut down
+func (c *Client) isShutdown() bool {
+	select {
+	case <-c.shutdown:
+		return true
+	default:
+		return false
+	}
+}
+
 func (c *Client) executeRequest(query string, bindings, rebindings *map[string]string) (resp []Response, err error) {
-	var req request
-	var id string
+	var req Request
 	if bindings != nil && rebindings != nil {
-		req, id, err = prepareRequestWithBindings(query, *bindings, *rebindings)
+		req, _, err = prepareRequestWithBindings(query, *bindings, *rebindings)
 	} else {
-		req, id, err = prepareRequest(query)
+		req, _, err = prepareRequest(query)
 	}
 	if err != nil {
 		return
 	}
+	return c.execute(query, req)
+}
 
-	msg, err := packageRequest(req)
+func (c *Client) executeTypedRequest(query string, bindings map[string]interface{}, rebindings map[string]string) (resp []Response, err error) {
+	req, _, err := prepareRequestWithTypedBindings(query, bindings, rebindings)
+	if err != nil {
+		return
+	}
+	return c.execute(query, req)
+}
+
+// execute sends a prepared request, serving read queries from the result cache when it is enabled
+func (c *Client) execute(query string, req Request) (resp []Response, err error) {
+	return c.executeContext(context.Background(), query, req)
+}
+
+// executeContext is execute giving up, also on waits before retries, when ctx is done
+func (c *Client) executeContext(ctx context.Context, query string, req Request) (resp []Response, err error) {
+	cache, key := c.cacheFor(query, req.Args)
+	if cache != nil {
+		if cached, ok := cache.get(key); ok {
+			return cached, nil
+		}
+	}
+
+	clk := orRealClock(c.clock)
+	start := clk.Now()
+	resp, err = c.roundTripContext(ctx, req)
+	for attempt := 1; err != nil; attempt++ {
+		reset := errors.Cause(err) == ErrReset
+		if reset && !c.isIdempotent(req, query) {
+			if c.retryReadOnly {
+				err = ErrMutationNotRetried
+			}
+			break
+		}
+
+		retryAfter, throttled := RetryAfter(err)
+		retry, delay := (reset || throttled) && attempt <= maxResetRetries, time.Duration(0)
+		if c.retryDecision != nil {
+			retry, delay = c.retryDecision(attempt, err, clk.Now().Sub(start))
+		}
+		if throttled { // The server knows best how long it needs to recover
+			delay = retryAfter
+		}
+		if !retry {
+			break
+		}
+		if err = sleepContext(ctx, clk, delay); err != nil {
+			break
+		}
+		if reset {
+			resp, err = c.retryAfterReset(ctx, req)
+		} else {
+			resp, err = c.roundTripContext(ctx, req)
+		}
+	}
+	c.recordLatency(clk.Now().Sub(start))
+	if err != nil {
+		err = c.queryError(err, query)
+		return
+	}
+
+	if cache != nil {
+		cache.put(key, resp)
+	}
+	return
+}
+
+// QueryRedactor rewrites a query before it is attached to an error, such as to mask literals
+type QueryRedactor func(query string) string
+
+type errorQuery struct {
+	maxLength int
+	redact    QueryRedactor
+}
+
+// queryError attaches the query to the error of a failed request, when enabled with SetQueryInErrors
+func (c *Client) queryError(err error, query string) error {
+	if c.errorQuery == nil {
+		return err
+	}
+	if c.errorQuery.redact != nil {
+		query = c.errorQuery.redact(query)
+	}
+	if max := c.errorQuery.maxLength; max > 0 && len(query) > max {
+		for max > 0 && !utf8.RuneStart(query[max]) { // Does not cut a character in half
+			max--
+		}
+		query = query[:max] + "..."
+	}
+	return errors.Wrapf(err, "query: %s", query)
+}
+
+// RetryDecision decides whether a failed request is sent again, and after which delay. It is called before every
+// retry with the number of the retry, starting at 1, the error of the last attempt and the time spent on the
+// request so far. Error responses of the server are *StatusError, carrying the status attributes such as retry
+// hints. Requests interrupted by a reset which are not idempotent are never retried. When the server sent a
+// retry hint, see RetryAfter, the request is retried after the hinted delay rather than the decided one.
+type RetryDecision func(attempt int, err error, elapsed time.Duration) (retry bool, delay time.Duration)
+
+// isIdempotent reports whether a request interrupted by a reset may be sent again. Requests are idempotent when
+// marked so explicitly, or when read only queries are retried and the query has no mutating step.
+func (c *Client) isIdempotent(req Request, query string) bool {
+	if idempotent, _ := req.Args[idempotentArg].(bool); idempotent {
+		return true
+	}
+	return c.retryReadOnly && !isMutating(query)
+}
+
+// sleepContext waits for d on the clock, or until ctx is done
+func sleepContext(ctx context.Context, clk clock, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := clk.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryAfterReset waits for the reset connection and sends the request again under a new request id
+func (c *Client) retryAfterReset(ctx context.Context, req Request) ([]Response, error) {
+	waitCtx, cancel := context.WithTimeout(ctx, retryWaitTimeout)
+	defer cancel()
+	if err := c.WaitForConnection(waitCtx); err != nil {
+		return nil, errors.Wrap(err, "waiting to retry after reset")
+	}
+	args := make(map[string]interface{}, len(req.Args))
+	for k, v := range req.Args {
+		args[k] = v
+	}
+	req.Args = args
+	return c.roundTripContext(ctx, req) // Sent under a new request id
+}
+
+// cacheFor returns the result cache and key to use for a query, or a nil cache when the query must not be cached.
+// Mutating queries flush the cache when invalidation is enabled.
+func (c *Client) cacheFor(query string, args map[string]interface{}) (*resultCache, string) {
+	if c.cache == nil || c.frameHandler != nil {
+		return nil, ""
+	}
+	if isMutating(query) {
+		if c.flushOnMutation {
+			c.cache.flush()
+		}
+		return nil, ""
+	}
+	return c.cache, cacheKey(args)
+}
+
+// roundTrip dispatches a prepared request under a new request id and waits for its response
+func (c *Client) roundTrip(req Request) (resp []Response, err error) {
+	return c.roundTripContext(context.Background(), req)
+}
+
+// roundTripContext is roundTrip giving up when ctx is done
+func (c *Client) roundTripContext(ctx context.Context, req Request) (resp []Response, err error) {
+	req.RequestID = c.nextRequestID()
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	ctx, stop := c.evaluationContext(ctx, req)
+	defer stop()
+	if err = c.submit(ctx, req); err != nil {
+		return
+	}
+	defer c.requestFinished()
+	resp, err = c.retrieveResponseContext(ctx, req.RequestID)
+	if err != nil {
+		c.abandon(req.RequestID) // Frames still arriving for the failed request, such as past a size limit, are dropped
+		if context.Cause(ctx) == ErrEvaluationTimeoutExceeded {
+			err = ErrEvaluationTimeoutExceeded
+		}
+	}
+	return
+}
+
+// roundTripFrames sends a request like roundTripContext, handing its frames to handler as they arrive instead of
+// aggregating them. Error frames fail the request instead of being handled.
+func (c *Client) roundTripFrames(ctx context.Context, req Request, handler FrameHandler) error {
+	req.RequestID = c.nextRequestID()
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	c.frameHandlers.Store(req.RequestID, handler) // Registered before any frame of the request can arrive
+	defer c.frameHandlers.Delete(req.RequestID)
+	if err := c.submit(ctx, req); err != nil {
+		return err
+	}
+	defer c.requestFinished()
+	_, err := c.retrieveResponseContext(ctx, req.RequestID)
+	if err != nil {
+		c.abandon(req.RequestID)
+	}
+	return err
+}
+
+// requestContext bounds a request by the request timeout of the client. An earlier deadline of ctx is kept.
+func (c *Client) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.requestTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.requestTimeout)
+}
+
+// evaluationTimeoutArgs are the args a request sets the time the server allows its script to run for with, in
+// milliseconds. Gremlin Server renamed scriptEvaluationTimeout to evaluationTimeout in 3.4.
+var evaluationTimeoutArgs = []string{"scriptEvaluationTimeout", "evaluationTimeout"}
+
+// evaluationContext bounds a request setting an evaluation timeout by that timeout plus the evaluation timeout
+// margin of the client, see SetEvaluationTimeoutMargin. The context ends with ErrEvaluationTimeoutExceeded as its
+// cause.
+func (c *Client) evaluationContext(ctx context.Context, req Request) (context.Context, context.CancelFunc) {
+	if c.evaluationMargin <= 0 {
+		return ctx, func() {}
+	}
+	for _, arg := range evaluationTimeoutArgs {
+		var ms int64
+		switch v := req.Args[arg].(type) {
+		case int64:
+			ms = v
+		case int:
+			ms = int64(v)
+		case int32:
+			ms = int64(v)
+		case float64:
+			ms = int64(v)
+		}
+		if ms > 0 {
+			return context.WithTimeoutCause(ctx, time.Duration(ms)*time.Millisecond+c.evaluationMargin, ErrEvaluationTimeoutExceeded)
+		}
+	}
+	return ctx, func() {}
+}
+
+// nextRequestID generates the id of a request with the configured generator
+func (c *Client) nextRequestID() string {
+	if c.requestIDs == nil {
+		return newRequestID()
+	}
+	return c.requestIDs.NewID()
+}
+
+// submit serializes a request and queues it for writing, so that its response can be retrieved under its id.
+// Every request sent by the client goes through submit.
+func (c *Client) submit(ctx context.Context, req Request) (err error) {
+	if c.isShutdown() {
+		return ErrClientShutdown
+	}
+	req = c.withGraphName(req)
+	req = withoutInternalArgs(c.withResultShape(req))
+	if err = c.validate(req); err != nil {
+		return
+	}
+	msg, err := c.serializer.Serialize(req)
 	if err != nil {
 		log.Println(err)
 		return
 	}
-	c.responseNotifier.Store(id, make(chan error, 1))
-	c.dispatchRequest(msg)
-	resp, err = c.retrieveResponse(id)
+	return c.submitMessage(ctx, req.RequestID, msg)
+}
+
+// projectionSteps matches the steps which give a script a result shape of its own, scripts with one are not shaped
+var projectionSteps = regexp.MustCompile(`\b(project|valueMap|elementMap|propertyMap|select|values|id|label|count|path)\s*\(`)
+
+// withResultShape appends the result shape step of the client to an eval script without a projection step, unless
+// the request is exempted from it. The args are copied, so the request of the caller is left as it is.
+func (c *Client) withResultShape(req Request) Request {
+	_, unshaped := req.Args[unshapedArg]
+	query, isScript := req.Args["gremlin"].(string)
+	if c.resultShape == "" || req.Op != "eval" || !isScript || unshaped || projectionSteps.MatchString(query) {
+		return req
+	}
+	args := make(map[string]interface{}, len(req.Args))
+	for k, v := range req.Args {
+		args[k] = v
+	}
+	args["gremlin"] = strings.TrimRight(query, " \t\r\n;") + "." + c.resultShape
+	req.Args = args
+	return req
+}
+
+// internalArgs are the args marking requests for the client itself, which are never sent
+var internalArgs = []string{idempotentArg, unshapedArg}
+
+// withoutInternalArgs removes the internal args from a request before it is serialized, whatever the serializer.
+// The args are copied, so the request of the caller is left as it is.
+func withoutInternalArgs(req Request) Request {
+	marked := false
+	for _, arg := range internalArgs {
+		if _, ok := req.Args[arg]; ok {
+			marked = true
+		}
+	}
+	if !marked {
+		return req
+	}
+	args := make(map[string]interface{}, len(req.Args))
+	for k, v := range req.Args {
+		args[k] = v
+	}
+	for _, arg := range internalArgs {
+		delete(args, arg)
+	}
+	req.Args = args
+	return req
+}
+
+// withGraphName adds the graph name of the client to an eval request without one. The args are copied, so the
+// request of the caller is left as it is.
+func (c *Client) withGraphName(req Request) Request {
+	if c.graphName == "" || req.Op != "eval" {
+		return req
+	}
+	if _, ok := req.Args[graphNameArg]; ok {
+		return req
+	}
+	args := make(map[string]interface{}, len(req.Args)+1)
+	for k, v := range req.Args {
+		args[k] = v
+	}
+	args[graphNameArg] = c.graphName
+	req.Args = args
+	return req
+}
+
+// errRequestIDInFlight is returned by submitMessage for a request id already awaiting its response
+var errRequestIDInFlight = errors.New("a request with the same id is in flight")
+
+// submitMessage dispatches a serialized request, registering it to await its response under the request id
+func (c *Client) submitMessage(ctx context.Context, id string, msg []byte) (err error) {
+	if _, loaded := c.responseNotifier.LoadOrStore(id, make(chan error, 1)); loaded {
+		return errRequestIDInFlight
+	}
+	if c.firstFrameTimeout > 0 {
+		c.firstFrames.Store(id, make(chan struct{}))
+	}
+	if c.unsent != nil {
+		c.unsent.Store(id, struct{}{})
+	}
+	if err = c.dispatchRequestContext(ctx, msg); err != nil {
+		c.responseNotifier.Delete(id)
+		c.firstFrames.Delete(id)
+		if c.unsent != nil {
+			c.unsent.Delete(id)
+		}
+		return
+	}
+	c.requestStarted()
+	return
+}
+
+// SerializeRequest serializes a request the way the client sends it, for use with ExecutePrebuilt
+func (c *Client) SerializeRequest(req Request) ([]byte, error) {
+	if req.RequestID == "" {
+		req.RequestID = c.nextRequestID()
+	}
+	return c.serializer.Serialize(withoutInternalArgs(req))
+}
+
+// ExecutePrebuilt sends a request serialized before, such as by SerializeRequest, and returns the result. Callers
+// repeating a request often can serialize it once and send the same bytes again. The response is correlated by
+// the request id embedded in the request. While a request with the same id is in flight, the request is sent
+// under a fresh id instead, so the same bytes may be sent concurrently. The request is decoded to run the checks of
+// the client on it, such as SetReadOnly and SetQueryValidator, so it must be a GraphSON request frame.
+func (c *Client) ExecutePrebuilt(raw []byte) (resp []Response, err error) {
+	if c.isShutdown() {
+		return nil, ErrClientShutdown
+	}
+	prefix, body := splitFrame(raw)
+	var req Request
+	if err = json.Unmarshal(body, &req); err != nil {
+		return nil, errors.Wrap(err, "decoding prebuilt request")
+	}
+	if req.RequestID == "" {
+		return nil, errors.New("prebuilt request without a request id")
+	}
+	if err = c.validate(req); err != nil {
+		return
+	}
+
+	id := req.RequestID
+	ctx, cancel := c.requestContext(context.Background())
+	defer cancel()
+	err = c.submitMessage(ctx, id, raw)
+	if err == errRequestIDInFlight {
+		id = c.nextRequestID()
+		var msg []byte
+		if msg, err = withRequestID(prefix, body, id); err != nil {
+			return
+		}
+		err = c.submitMessage(ctx, id, msg)
+	}
 	if err != nil {
-		err = errors.Wrapf(err, "query: %s", query)
+		return
+	}
+	defer c.requestFinished()
+	resp, err = c.retrieveResponseContext(ctx, id)
+	if err != nil {
+		c.abandon(id)
 	}
 	return
 }
 
+// withRequestID rewrites the requestId field of a request frame, leaving the encoding of its other fields as it is
+func withRequestID(prefix, body []byte, id string) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, errors.Wrap(err, "decoding prebuilt request")
+	}
+	encodedID, err := json.Marshal(id)
+	if err != nil {
+		return nil, err
+	}
+	fields["requestId"] = encodedID
+	rewritten, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte(nil), prefix...), rewritten...), nil
+}
+
+// QueryValidator checks a script before it is sent, rejecting it with an error
+type QueryValidator func(query string) error
+
+// ErrScriptTooLarge is returned for scripts longer than the maximum script size of the client
+var ErrScriptTooLarge = errors.New("script exceeds the maximum script size")
+
+// ErrReadOnly is returned for scripts with a mutating step sent by a read only client, see SetReadOnly
+var ErrReadOnly = errors.New("the client is read only and does not send mutating scripts")
+
+// validate runs the checks configured for scripts on the script of a request, requests without one pass. Bytecode
+// is checked by its instructions, see validateBytecode.
+func (c *Client) validate(req Request) error {
+	if instructions, isBytecode := bytecodeInstructions(req.Args["gremlin"]); isBytecode {
+		return c.validateBytecode(instructions)
+	}
+	query, ok := req.Args["gremlin"].(string)
+	if !ok {
+		return nil
+	}
+	if c.maxScriptSize > 0 && len(query) > c.maxScriptSize {
+		return errors.Wrapf(ErrScriptTooLarge, "%d bytes, at most %d allowed", len(query), c.maxScriptSize)
+	}
+	if step := mutatingStep(query); c.readOnly && step != "" {
+		return errors.Wrapf(ErrReadOnly, "script has the %s step", step)
+	}
+	if err := c.validateBindings(req.Args["bindings"]); err != nil {
+		return err
+	}
+	if c.validator != nil {
+		return errors.Wrap(c.validator(query), "invalid script")
+	}
+	return nil
+}
+
+// validateBytecode checks that every instruction of a traversal sent as bytecode has an operator, that a read only
+// client sends no mutating step, and runs the validator of the client on the script the instructions spell out
+func (c *Client) validateBytecode(instructions [][]interface{}) error {
+	for i, instruction := range instructions {
+		var operator string
+		if len(instruction) > 0 {
+			operator, _ = instruction[0].(string)
+		}
+		if operator == "" {
+			return errors.Errorf("instruction %d of the bytecode has no operator", i)
+		}
+		if c.readOnly && containsString(mutatingOperators, operator) {
+			return errors.Wrapf(ErrReadOnly, "bytecode has the %s step", operator)
+		}
+	}
+	if c.validator != nil {
+		return errors.Wrap(c.validator(bytecodeScript(instructions)), "invalid bytecode")
+	}
+	return nil
+}
+
+// validateBindings checks that the keys of the bindings of a script are identifiers the script can refer to
+func (c *Client) validateBindings(bindings interface{}) error {
+	var keys []string
+	switch b := bindings.(type) {
+	case map[string]string:
+		for key := range b {
+			keys = append(keys, key)
+		}
+	case map[string]interface{}:
+		for key := range b {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys) // The first invalid key in order is reported, whatever the map iteration order
+	for _, key := range keys {
+		if !bindingKeyPattern.MatchString(key) {
+			return &InvalidBindingError{Key: key}
+		}
+		if groovyKeywords[key] || c.reservedBindings[key] {
+			return &InvalidBindingError{Key: key, Reserved: true}
+		}
+	}
+	return nil
+}
+
+// SubmitAsync sends a request as it is, without building it from a query, for custom ops, processors or arguments.
+// A request id is generated when the request has none. The frames of the response are delivered on the returned
+// channel, which is closed after the last frame, including the frame carrying an error status. The channel is
+// closed without a terminal frame when the connection is reset or ctx is done before the response completed.
+func (c *Client) SubmitAsync(ctx context.Context, req Request) (<-chan Response, error) {
+	if c.conn.IsDisposed() {
+		return nil, ErrDisposed
+	}
+	if req.RequestID == "" {
+		req.RequestID = c.nextRequestID()
+	}
+	ctx, cancelRequest := c.requestContext(ctx)
+	ctx, stop := c.evaluationContext(ctx, req)
+	cancel := func() {
+		stop()
+		cancelRequest()
+	}
+	if !c.reserveWorker() {
+		cancel()
+		return nil, ErrTooManyGoroutines
+	}
+	if err := c.submit(ctx, req); err != nil {
+		c.releaseWorker()
+		cancel()
+		return nil, errors.Wrap(err, "submit")
+	}
+
+	frames := make(chan Response)
+	c.runWorker(func() {
+		defer cancel()
+		defer close(frames)
+		defer c.requestFinished()
+		resp, err := c.retrieveResponseContext(ctx, req.RequestID)
+		if err != nil { // Deliver the frames received up to the error, the last one carries the error status
+			if data, ok := c.results.Load(req.RequestID); ok {
+				for _, r := range data.([]interface{}) {
+					resp = append(resp, r.(Response))
+				}
+			}
+			c.responseNotifier.Delete(req.RequestID)
+			c.deleteResponse(req.RequestID)
+		}
+		for _, r := range resp {
+			select {
+			case frames <- r:
+			case <-ctx.Done():
+				return
+			case <-c.shutdown:
+				return
+			}
+		}
+	})
+	return frames, nil
+}
+
+// WaitForConnection blocks until the underlying connection is connected or the context is done.
+// It returns immediately when the connection is already connected.
+func (c *Client) WaitForConnection(ctx context.Context) error {
+	if c.conn == nil {
+		return errors.New("cannot wait on a client without a connection")
+	}
+	return c.conn.waitForConnection(ctx)
+}
+
+// Ping executes a trivial traversal to verify that Gremlin Server is able to serve queries, not only that the
+// WebSocket is connected. It waits until the context is done, or pingTimeout when the context has no deadline.
+// Pings are not counted in the latency of the client.
+func (c *Client) Ping(ctx context.Context) (err error) {
+	if c.conn.IsDisposed() {
+		return ErrDisposed
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, pingTimeout)
+		defer cancel()
+	}
+
+	req, _, err := prepareRequest(pingQuery)
+	if err != nil {
+		return
+	}
+
+	// Bound by ctx, so that a ping given up on leaves no request pending
+	_, err = c.roundTripContext(ctx, req)
+	return errors.Wrap(err, "ping")
+}
+
 func (c *Client) authenticate(requestID string) (err error) {
-	auth := c.conn.getAuth()
+	auth, err := c.conn.getAuth()
+	if err != nil {
+		return
+	}
 	req, err := prepareAuthRequest(requestID, auth.username, auth.password)
 	if err != nil {
 		return
 	}
 
-	msg, err := packageRequest(req)
+	msg, err := c.serializer.Serialize(req)
 	if err != nil {
 		log.Println(err)
 		return
@@ -110,28 +953,115 @@ func (c *Client) authenticate(requestID string) (err error) {
 	return
 }
 
+// recordLatency folds a request round trip into the moving average latency of the client
+func (c *Client) recordLatency(d time.Duration) {
+	old := atomic.LoadInt64(&c.latency)
+	if old == 0 {
+		atomic.StoreInt64(&c.latency, int64(d))
+		return
+	}
+	atomic.StoreInt64(&c.latency, old+(int64(d)-old)/8)
+}
+
+// Latency returns the moving average round trip time of the requests executed by the client.
+func (c *Client) Latency() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.latency))
+}
+
+// InFlight returns the number of requests the client dispatched which have not completed or failed yet.
+func (c *Client) InFlight() int {
+	return int(atomic.LoadInt64(&c.inFlight))
+}
+
+// requestStarted counts a dispatched request until requestFinished is called for it
+func (c *Client) requestStarted() {
+	atomic.AddInt64(&c.inFlight, 1)
+	c.stats.requestStarted()
+}
+
+func (c *Client) requestFinished() {
+	atomic.AddInt64(&c.inFlight, -1)
+}
+
 // ExecuteWithBindings formats a raw Gremlin query, sends it to Gremlin Server, and returns the result.
 func (c *Client) ExecuteWithBindings(query string, bindings, rebindings map[string]string) (resp []Response, err error) {
 	if c.conn.IsDisposed() {
-		return resp, errors.New("you cannot write on disposed connection")
+		return resp, ErrDisposed
 	}
 	resp, err = c.executeRequest(query, &bindings, &rebindings)
 	return
 }
 
+// ExecuteIdempotent formats a raw Gremlin query, sends it to Gremlin Server, and returns the result. The query is
+// marked as idempotent, so it is sent again when a reset interrupts it, even if it contains mutating steps.
+func (c *Client) ExecuteIdempotent(query string) (resp []Response, err error) {
+	if c.conn.IsDisposed() {
+		return resp, ErrDisposed
+	}
+	req, _, err := prepareRequest(query)
+	if err != nil {
+		return
+	}
+	req.Args[idempotentArg] = true
+	return c.execute(query, req)
+}
+
+// ExecuteUnshaped formats a raw Gremlin query, sends it to Gremlin Server, and returns the result. The query is sent
+// as it is, without the result shape step of the client, see SetResultShape.
+func (c *Client) ExecuteUnshaped(query string) (resp []Response, err error) {
+	if c.conn.IsDisposed() {
+		return resp, ErrDisposed
+	}
+	req, _, err := prepareRequest(query)
+	if err != nil {
+		return
+	}
+	req.Args[unshapedArg] = true
+	return c.execute(query, req)
+}
+
+// ExecuteWithTypedBindings formats a raw Gremlin query, sends it to Gremlin Server with bindings of any type, and returns the result.
+// Bindings with a dedicated GraphSON type, such as uuid.UUID, are sent typed.
+func (c *Client) ExecuteWithTypedBindings(query string, bindings map[string]interface{}, rebindings map[string]string) (resp []Response, err error) {
+	if c.conn.IsDisposed() {
+		return resp, ErrDisposed
+	}
+	resp, err = c.executeTypedRequest(query, bindings, rebindings)
+	return
+}
+
+// ExecuteContext sends a query with bindings of any type to Gremlin Server and returns the result, giving up when
+// ctx is done.
+func (c *Client) ExecuteContext(ctx context.Context, query string, bindings map[string]interface{}) (resp []Response, err error) {
+	if c.conn.IsDisposed() {
+		return resp, ErrDisposed
+	}
+	req, _, err := prepareRequestWithTypedBindings(query, bindings, map[string]string{})
+	if err != nil {
+		return
+	}
+	return c.executeContext(ctx, query, req)
+}
+
 // Execute formats a raw Gremlin query, sends it to Gremlin Server, and returns the result.
 func (c *Client) Execute(query string) (resp []Response, err error) {
 	if c.conn.IsDisposed() {
-		return resp, errors.New("you cannot write on disposed connection")
+		return resp, ErrDisposed
 	}
 	resp, err = c.executeRequest(query, nil, nil)
 	return
 }
 
+// ExecuteTraversal builds a Traversal into a parameterized Gremlin query, sends it to Gremlin Server with its typed bindings, and returns the result.
+func (c *Client) ExecuteTraversal(t *Traversal) (resp []Response, err error) {
+	query, bindings := t.Build()
+	return c.ExecuteWithTypedBindings(query, bindings, map[string]string{})
+}
+
 // ExecuteFileWithBindings takes a file path to a Gremlin script, sends it to Gremlin Server with bindings, and returns the result.
 func (c *Client) ExecuteFileWithBindings(path string, bindings, rebindings map[string]string) (resp []Response, err error) {
 	if c.conn.IsDisposed() {
-		return resp, errors.New("you cannot write on disposed connection")
+		return resp, ErrDisposed
 	}
 	d, err := ioutil.ReadFile(path) // Read script from file
 	if err != nil {
@@ -146,7 +1076,7 @@ func (c *Client) ExecuteFileWithBindings(path string, bindings, rebindings map[s
 // ExecuteFile takes a file path to a Gremlin script, sends it to Gremlin Server, and returns the result.
 func (c *Client) ExecuteFile(path string) (resp []Response, err error) {
 	if c.conn.IsDisposed() {
-		return resp, errors.New("you cannot write on disposed connection")
+		return resp, ErrDisposed
 	}
 	d, err := ioutil.ReadFile(path) // Read script from file
 	if err != nil {
@@ -158,9 +1088,264 @@ func (c *Client) ExecuteFile(path string) (resp []Response, err error) {
 	return
 }
 
-// Close closes the underlying connection and marks the client as closed.
-func (c *Client) Close() {
-	if c.conn != nil {
+// ExecuteFileQuery sends the Gremlin script in the file at path to Gremlin Server with bindings of any type and
+// returns the result, giving up when ctx is done. The script is cached and only read again once the file is modified.
+func (c *Client) ExecuteFileQuery(ctx context.Context, path string, bindings map[string]interface{}) (resp []Response, err error) {
+	if c.conn.IsDisposed() {
+		return resp, ErrDisposed
+	}
+	query, err := c.scriptFiles.load(path)
+	if err != nil {
+		return resp, errors.Wrapf(err, "reading script %s", path)
+	}
+	return c.ExecuteContext(ctx, query, bindings)
+}
+
+// RequestQueueDepth returns the number of outbound requests waiting to be written.
+func (c *Client) RequestQueueDepth() int {
+	return len(c.requests)
+}
+
+// RequestQueueCapacity returns the number of outbound requests which can be queued before Execute blocks.
+func (c *Client) RequestQueueCapacity() int {
+	return cap(c.requests)
+}
+
+// RequestQueueUtilization returns the fill level of the outbound request queue, between 0 and 1.
+func (c *Client) RequestQueueUtilization() float64 {
+	if cap(c.requests) == 0 {
+		return 0
+	}
+	return float64(len(c.requests)) / float64(cap(c.requests))
+}
+
+// Backpressure returns a channel signalling true when the queue of outbound requests reaches its high watermark
+// and false once it has drained to its low watermark. It is nil unless configured with SetBackpressure, and closed
+// by Shutdown.
+func (c *Client) Backpressure() <-chan bool {
+	if c.backpressure == nil {
+		return nil
+	}
+	return c.backpressure.signal
+}
+
+// logger returns the logger configured on the connection of the client
+func (c *Client) logger() Logger {
+	if ws, ok := c.conn.(*Ws); ok {
+		return ws.getLogger()
+	}
+	return stdLogger{}
+}
+
+// Stats returns a snapshot of the lifetime counters of the client.
+func (c *Client) Stats() Stats {
+	stats := c.stats.snapshot()
+	stats.InFlight = int64(c.InFlight())
+	if ws, ok := c.conn.(*Ws); ok {
+		stats.PingFailures = ws.pingFailureCount()
+	}
+	return stats
+}
+
+// Clone dials a new connection to the same host and returns a client configured like this one, with the options
+// of its dialer and of the client as well as the credentials currently in use. The original client keeps running.
+func (c *Client) Clone() (*Client, error) {
+	ws, ok := c.conn.(*Ws)
+	if !ok {
+		return nil, errors.New("cannot clone a client without a WebSocket connection")
+	}
+	host := ws.host
+	if ws.primary != "" { // Connecting to the primary again, the clone fails over on its own
+		host = ws.primary
+	}
+	dialer := NewDialer(host, ws.configs...)
+	dialer.auth = ws.auth // Credentials may have been changed since the dialer was created
+	clone, err := Dial(dialer, c.errs, c.configs...)
+	if err != nil {
+		return nil, err
+	}
+	clone.onReconnect = c.onReconnect
+	return clone, nil
+}
+
+// Close closes the underlying connection and marks the client as closed. It shuts the client down like Shutdown,
+// waiting up to closeWaitTimeout for its workers to exit, use Shutdown to wait for a different time.
+func (c *Client) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), closeWaitTimeout)
+	defer cancel()
+	return c.Shutdown(ctx)
+}
+
+// SetReconnectHook registers a hook which re-runs initialization queries whenever the client is reset.
+func (c *Client) SetReconnectHook(hook ReconnectHook) {
+	c.Lock()
+	c.onReconnect = hook
+	c.Unlock()
+}
+
+// Reset fails any pending requests with ErrReset, clears the Errored state and reconnects the
+// underlying connection. It allows an errored client to be reused in place. Requests made while the connection is
+// dialed are queued and written once it is connected.
+func (c *Client) Reset() (err error) {
+	c.resetMu.Lock() // A single reset at a time, the client itself is only locked to swap its state
+	defer c.resetMu.Unlock()
+	c.Lock()
+
+	if c.conn == nil {
+		c.Unlock()
+		return errors.New("cannot reset a client without a connection")
+	}
+	if c.isShutdown() {
+		c.Unlock()
+		return ErrClientShutdown
+	}
+
+	c.failPending(ErrReset, c.unsent != nil)
+	c.Errored = false
+	hook := c.onReconnect
+	c.Unlock()
+
+	if err = c.conn.reconnect(); err != nil {
+		return
+	}
+	if c.isShutdown() { // Shut down while dialing, nothing would ever stop workers of the new connection
 		c.conn.close()
+		return ErrClientShutdown
 	}
+	c.stats.connected(true)
+
+	quit := c.conn.(*Ws).quitChan()
+
+	c.goWorker(func() { c.readWorker(c.errs, quit) })
+	if err = c.runInitQuery(); err != nil { // The connection is not used without it, the requests queued for it fail
+		c.Lock()
+		c.failPending(err, false)
+		c.Errored = true
+		c.Unlock()
+		c.conn.close()
+		return
+	}
+	if hook != nil {
+		// The write worker is not running yet, so regular requests queue until the hook is done
+		if err = hook(c.executeDirect); err != nil {
+			c.setErrored(true)
+			return errors.Wrap(err, "reconnect hook")
+		}
+	}
+	c.goWorker(func() { c.writeWorker(c.errs, quit) })
+	c.goWorker(func() { c.conn.ping(c.errs) })
+	return
+}
+
+// failPending drops the requests which were never written and fails the requests waiting for a response with err.
+// With keepUnsent, requests which were never written stay queued instead. The client must be locked.
+func (c *Client) failPending(err error, keepUnsent bool) {
+	if c.responseNotifier == nil {
+		return
+	}
+	dropped := 0
+	for drained := keepUnsent; !drained; { // Drop requests which were never written to the old connection
+		select {
+		case <-c.requests:
+			dropped++
+		case <-c.held:
+			dropped++
+		default:
+			drained = true
+		}
+	}
+	c.outbound.take(dropped)
+
+	c.responseNotifier.Range(func(id, notifier interface{}) bool {
+		if keepUnsent {
+			if _, unsent := c.unsent.Load(id); unsent {
+				return true
+			}
+		}
+		if notify(notifier.(chan error), err) {
+			c.responseNotifier.Delete(id)
+			c.deleteResponse(id.(string))
+		} // Otherwise the response is complete and already waiting for its requester
+		return true
+	})
+}
+
+// Shutdown closes the connection, fails pending requests with ErrClientShutdown and waits until every goroutine
+// started by the client has exited, or ctx is done. Everything that failed on the way is returned together as a
+// ShutdownError. Shutting down a client which is already shut down only waits for its goroutines.
+func (c *Client) Shutdown(ctx context.Context) error {
+	var failed []error
+
+	c.Lock()
+	if c.shutdown == nil {
+		c.shutdown = make(chan struct{})
+	}
+	first := !c.isShutdown()
+	if first {
+		close(c.shutdown)
+		c.failPending(ErrClientShutdown, false)
+		c.backpressure.close()
+	}
+	c.Unlock()
+
+	if first && c.conn != nil && !c.conn.IsDisposed() { // Closed unlocked, the read worker may need the lock to finish
+		if err := c.conn.close(); err != nil {
+			failed = append(failed, errors.Wrap(err, "closing connection"))
+		}
+	}
+
+	if c.workers != nil {
+		done := make(chan struct{})
+		go func() {
+			c.workers.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			failed = append(failed, errors.Wrap(ctx.Err(), "waiting for workers"))
+		}
+	}
+
+	if len(failed) > 0 {
+		return &ShutdownError{Errors: failed}
+	}
+	return nil
+}
+
+// executeDirect sends a query straight to the connection, bypassing the request queue
+func (c *Client) executeDirect(query string) (resp []Response, err error) {
+	req, _, err := prepareRequest(query)
+	if err != nil {
+		return
+	}
+	req = c.withGraphName(req)
+	req.RequestID = c.nextRequestID()
+	id := req.RequestID
+	if err = c.validate(req); err != nil {
+		return
+	}
+
+	msg, err := c.serializer.Serialize(req)
+	if err != nil {
+		return
+	}
+	c.responseNotifier.Store(id, make(chan error, 1))
+	c.requestStarted()
+	defer c.requestFinished()
+	c.Lock()
+	err = c.conn.write(msg)
+	c.Unlock()
+	if err != nil {
+		c.stats.writeFailed()
+		c.responseNotifier.Delete(id)
+		return
+	}
+	c.stats.written(len(msg))
+	c.observe(MetricRequestBytes, float64(len(msg)))
+	resp, err = c.retrieveResponse(id)
+	if err != nil {
+		err = c.queryError(err, query)
+	}
+	return
 }
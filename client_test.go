@@ -1 +1,1269 @@
 package gremtune
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+)
+
+func TestResetFailsPendingRequests(t *testing.T) {
+	c := newClient()
+	c.conn = &Ws{host: "ws://127.0.0.1:1", disposed: true, quit: make(chan struct{})}
+	c.Errored = true
+
+	c.responseNotifier.Store("pending", make(chan error, 1))
+	c.dispatchRequest([]byte("unsent"))
+
+	if err := c.Reset(); err == nil {
+		t.Error("Expected reset to fail when the server cannot be reached")
+	}
+
+	if c.Errored {
+		t.Error("Expected Errored to be cleared by reset")
+	}
+
+	if len(c.requests) != 0 {
+		t.Errorf("Expected unsent requests to be drained, got %d", len(c.requests))
+	}
+
+	if _, ok := c.responseNotifier.Load("pending"); ok {
+		t.Error("Expected pending request to be removed by reset")
+	}
+}
+
+// TestResetDialsWithoutLock tests that the client is not locked while a reset dials the server
+func TestResetDialsWithoutLock(t *testing.T) {
+	dialing, release := make(chan struct{}), make(chan struct{})
+	handler := testServerHandler(t)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(dialing)
+		<-release
+		handler.ServeHTTP(w, r)
+	}))
+	defer s.Close()
+
+	c := newClient()
+	c.conn = &Ws{host: testServerHost(s), disposed: true, quit: make(chan struct{})}
+	c.Errored = true
+
+	done := make(chan error, 1)
+	go func() { done <- c.Reset() }()
+	<-dialing
+
+	locked := make(chan bool, 1)
+	go func() { locked <- c.IsErrored() }()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Error("Expected the client not to be locked while the reset dials")
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
+
+// TestRetrieveAfterFailPending tests that a request failed before its wait started is not waited on
+func TestRetrieveAfterFailPending(t *testing.T) {
+	c := newClient()
+	c.conn = &fakeDialer{}
+	c.responseNotifier.Store("pending", make(chan error, 1))
+	c.failPending(ErrReset, false)
+
+	if _, err := c.retrieveResponse("pending"); err != ErrReset {
+		t.Errorf("Expected ErrReset, got %v", err)
+	}
+
+	close(c.shutdown)
+	if _, err := c.retrieveResponse("pending"); err != ErrClientShutdown {
+		t.Errorf("Expected ErrClientShutdown, got %v", err)
+	}
+}
+
+// fakeDialer is an in-memory dialer that answers every written request through respond
+type fakeDialer struct {
+	written [][]byte
+	respond func(requestID string) []byte
+	client  *Client
+}
+
+func (f *fakeDialer) connect() error          { return nil }
+func (f *fakeDialer) reconnect() error        { return nil }
+func (f *fakeDialer) IsConnected() bool       { return true }
+func (f *fakeDialer) IsDisposed() bool        { return false }
+func (f *fakeDialer) close() error            { return nil }
+func (f *fakeDialer) getAuth() (*auth, error) { return &auth{}, nil }
+func (f *fakeDialer) ping(errs chan error)    {}
+func (f *fakeDialer) waitForConnection(ctx context.Context) error {
+	return nil
+}
+func (f *fakeDialer) read() (int, []byte, error) {
+	return -1, nil, nil
+}
+func (f *fakeDialer) write(msg []byte) error {
+	f.written = append(f.written, msg)
+	var req Request
+	if err := json.Unmarshal(msg[msg[0]+1:], &req); err != nil {
+		return err
+	}
+	if f.respond != nil {
+		if resp := f.respond(req.RequestID); resp != nil {
+			go f.client.handleResponse(resp)
+		}
+	}
+	return nil
+}
+
+func fakeSuccess(requestID string) []byte {
+	return []byte(`{"result":{"data":[],"meta":{}},"requestId":"` + requestID + `","status":{"code":200,"attributes":{},"message":""}}`)
+}
+
+func TestGraphName(t *testing.T) {
+	c, fake := startFakeClient(t)
+	SetGraphName("analytics")(c)
+
+	if _, err := c.Execute("g.V()"); err != nil {
+		t.Fatal(err)
+	}
+	session := c.NewSession(ExplicitTransactions)
+	if err := session.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := writtenRequests(t, fake)
+	if requests[0].Args["graphName"] != "analytics" {
+		t.Errorf("Expected the script to be sent to the analytics graph, got %v", requests[0].Args)
+	}
+	if _, ok := requests[1].Args["graphName"]; ok {
+		t.Errorf("Expected the session close to carry no graph name, got %v", requests[1].Args)
+	}
+}
+
+func TestGraphNameSubmitAsync(t *testing.T) {
+	c, fake := startFakeClient(t)
+	SetGraphName("analytics")(c)
+
+	args := map[string]interface{}{"gremlin": "g.V()", "language": "gremlin-groovy"}
+	frames, err := c.SubmitAsync(context.Background(), Request{Op: "eval", Args: args})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range frames {
+	}
+
+	if requests := writtenRequests(t, fake); requests[0].Args["graphName"] != "analytics" {
+		t.Errorf("Expected the request to be sent to the analytics graph, got %v", requests[0].Args)
+	}
+	if _, ok := args["graphName"]; ok {
+		t.Errorf("Expected the args of the caller to be left as they are, got %v", args)
+	}
+}
+
+func TestGraphNameExecuteDirect(t *testing.T) {
+	c := newClient()
+	fake := &fakeDialer{respond: fakeSuccess, client: &c}
+	c.conn = fake
+	SetGraphName("analytics")(&c)
+
+	if _, err := c.executeDirect("g.V()"); err != nil {
+		t.Fatal(err)
+	}
+	if requests := writtenRequests(t, fake); requests[0].Args["graphName"] != "analytics" {
+		t.Errorf("Expected the direct request to be sent to the analytics graph, got %v", requests[0].Args)
+	}
+}
+
+func TestExecuteDirectBypassesQueue(t *testing.T) {
+	c := newClient()
+	fake := &fakeDialer{respond: fakeSuccess, client: &c}
+	c.conn = fake
+
+	resp, err := c.executeDirect("g.V().count()")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp) != 1 {
+		t.Errorf("Expected 1 response, got %d", len(resp))
+	}
+
+	if len(fake.written) != 1 || len(c.requests) != 0 {
+		t.Error("Expected the query to be written directly rather than queued")
+	}
+}
+
+func TestWriteCoalescing(t *testing.T) {
+	c := newClient()
+	fake := &fakeDialer{client: &c}
+	c.conn = fake
+	SetWriteCoalescing(200 * time.Millisecond)(&c)
+	quit := make(chan struct{})
+	defer close(quit)
+	go c.writeWorker(make(chan error, 1), quit)
+
+	written := func() int {
+		c.Lock()
+		defer c.Unlock()
+		return len(fake.written)
+	}
+	for i := 0; i < 3; i++ {
+		req, _, _ := prepareRequest(fmt.Sprintf("g.V(%d)", i))
+		msg, _ := packageRequest(req)
+		c.dispatchRequest(msg)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if n := written(); n != 0 {
+		t.Errorf("Expected the requests to be gathered during the window, got %d written", n)
+	}
+	for deadline := time.Now().Add(time.Second); written() < 3 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if n := written(); n != 3 {
+		t.Errorf("Expected the batch to be written once the window closed, got %d written", n)
+	}
+}
+
+func TestPing(t *testing.T) {
+	c := newClient()
+	c.conn = &fakeDialer{respond: fakeSuccess, client: &c}
+	quit := make(chan struct{})
+	defer close(quit)
+	go c.writeWorker(make(chan error, 1), quit)
+
+	if err := c.Ping(context.Background()); err != nil {
+		t.Error(err)
+	}
+
+	if c.Latency() != 0 {
+		t.Error("Expected ping not to be counted in the client latency")
+	}
+}
+
+func TestPingTimeout(t *testing.T) {
+	c := newClient()
+	c.conn = &fakeDialer{client: &c} // Never responds
+	quit := make(chan struct{})
+	defer close(quit)
+	go c.writeWorker(make(chan error, 1), quit)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.Ping(ctx); errors.Cause(err) != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got: %v", err)
+	}
+
+	pending := 0
+	c.responseNotifier.Range(func(id, notifier interface{}) bool { pending++; return true })
+	if pending != 0 || c.InFlight() != 0 {
+		t.Errorf("Expected the ping given up on to leave no request pending, got %d", pending)
+	}
+}
+
+func TestClone(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	serializer := GraphSONSerializer{}
+	errs := make(chan error, 1)
+	c, err := Dial(NewDialer(testServerHost(s), SetPingInterval(30)), errs, SetSerializer(serializer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	clone, err := c.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clone.Close()
+
+	if clone.conn == c.conn {
+		t.Error("Expected the clone to dial its own connection")
+	}
+
+	if clone.conn.(*Ws).pingInterval != 30*time.Second || clone.serializer != serializer {
+		t.Error("Expected the clone to be configured like the original client")
+	}
+
+	if !c.conn.IsConnected() || !clone.conn.IsConnected() {
+		t.Error("Expected both clients to be connected")
+	}
+}
+
+func TestCloneUsesCurrentCredentials(t *testing.T) {
+	var dials int32
+	s := httptest.NewServer(authServerHandler(t, &dials))
+	defer s.Close()
+
+	c, err := Dial(NewSecureDialer(testServerHost(s), "user", "pass"), make(chan error, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	SetClientCredentials("user", "wrong")(c)
+	if _, err := c.Clone(); err != ErrAuthFailed {
+		t.Errorf("Expected the clone to authenticate with the current credentials, got %v", err)
+	}
+}
+
+func TestRequestQueueObservability(t *testing.T) {
+	c := newClient()
+	SetRequestChannelSize(4)(&c)
+
+	c.dispatchRequest([]byte("1"))
+
+	if c.RequestQueueDepth() != 1 || c.RequestQueueCapacity() != 4 {
+		t.Errorf("Unexpected queue depth %d or capacity %d", c.RequestQueueDepth(), c.RequestQueueCapacity())
+	}
+
+	if c.RequestQueueUtilization() != 0.25 {
+		t.Errorf("Expected a utilization of 0.25, got %f", c.RequestQueueUtilization())
+	}
+}
+
+func TestNegativeRequestChannelSize(t *testing.T) {
+	c := newClient()
+	SetRequestChannelSize(-1)(&c)
+
+	if c.RequestQueueCapacity() != 3 {
+		t.Errorf("Expected a negative size to keep the default capacity of 3, got %d", c.RequestQueueCapacity())
+	}
+}
+
+// interruptFirst answers the first request with a reset and all others successfully
+func interruptFirst(c *Client) func(requestID string) []byte {
+	interrupted := false
+	return func(requestID string) []byte {
+		if interrupted {
+			return fakeSuccess(requestID)
+		}
+		interrupted = true
+		notifier, _ := c.responseNotifier.Load(requestID)
+		notifier.(chan error) <- ErrReset
+		return nil
+	}
+}
+
+func TestRetryReadOnlyAfterReset(t *testing.T) {
+	c, fake := startFakeClient(t)
+	SetRetryReadOnly()(c)
+	fake.respond = interruptFirst(c)
+
+	resp, err := c.Execute("g.V().count()")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp) != 1 || len(fake.written) != 2 {
+		t.Errorf("Expected the read to be retried once, got %d writes", len(fake.written))
+	}
+}
+
+func TestMutationNotRetriedAfterReset(t *testing.T) {
+	c, fake := startFakeClient(t)
+	SetRetryReadOnly()(c)
+	fake.respond = interruptFirst(c)
+
+	if _, err := c.Execute("g.addV('person')"); errors.Cause(err) != ErrMutationNotRetried {
+		t.Errorf("Expected ErrMutationNotRetried, got: %v", err)
+	}
+
+	if len(fake.written) != 1 {
+		t.Errorf("Expected the mutation not to be retried, got %d writes", len(fake.written))
+	}
+}
+
+func TestIdempotentMutationRetriedAfterReset(t *testing.T) {
+	c, fake := startFakeClient(t)
+	fake.respond = interruptFirst(c)
+
+	if _, err := c.ExecuteIdempotent("g.V('1').fold().coalesce(unfold(), addV('person'))"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.written) != 2 {
+		t.Errorf("Expected the idempotent mutation to be retried, got %d writes", len(fake.written))
+	}
+	if strings.Contains(string(fake.written[1]), idempotentArg) {
+		t.Error("Expected the idempotent marker not to be sent to the server")
+	}
+}
+
+func TestSubmitAsync(t *testing.T) {
+	c, fake := startFakeClient(t)
+	fake.respond = func(id string) []byte {
+		return []byte(`{"result":{"data":null,"meta":{}},"requestId":"` + id + `","status":{"code":204,"attributes":{},"message":""}}`)
+	}
+
+	req := Request{Op: "close", Processor: "session", Args: map[string]interface{}{"session": "s1"}}
+	frames, err := c.SubmitAsync(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resp []Response
+	for r := range frames {
+		resp = append(resp, r)
+	}
+	if len(resp) != 1 || resp[0].Status.Code != statusNoContent {
+		t.Errorf("Expected a single frame without content, got %+v", resp)
+	}
+
+	written := writtenRequests(t, fake)
+	if len(written) != 1 || written[0].Op != "close" || written[0].Args["session"] != "s1" || written[0].RequestID == "" {
+		t.Errorf("Expected the request to be sent as it is under a new request id, got %+v", written)
+	}
+}
+
+// TestSubmitAsyncMaxGoroutines tests that SubmitAsync starts no goroutine beyond the cap of the client
+func TestSubmitAsyncMaxGoroutines(t *testing.T) {
+	c, fake := startFakeClient(t)
+	SetMaxGoroutines(1)(c)
+	fake.respond = nil
+
+	ctx, cancel := context.WithCancel(context.Background())
+	frames, err := c.SubmitAsync(ctx, Request{Op: "eval", Args: map[string]interface{}{"gremlin": "g.V()"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.SubmitAsync(context.Background(), Request{Op: "eval", Args: map[string]interface{}{"gremlin": "g.E()"}}); err != ErrTooManyGoroutines {
+		t.Errorf("Expected the second request to be refused, got %v", err)
+	}
+
+	cancel()
+	for range frames {
+	}
+	deadline := time.Now().Add(time.Second)
+	for c.Goroutines() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := c.SubmitAsync(ctx, Request{Op: "eval", Args: map[string]interface{}{"gremlin": "g.E()"}}); err != nil {
+		t.Errorf("Expected a request to be accepted once the goroutine ended, got %v", err)
+	}
+}
+
+// TestSubmitAsyncRequestUUID tests that the frames of concurrent requests carry the id of their own request
+func TestSubmitAsyncRequestUUID(t *testing.T) {
+	c, fake := startFakeClient(t)
+	fake.respond = func(id string) []byte {
+		go func() {
+			c.handleResponse([]byte(`{"result":{"data":[1],"meta":{}},"requestId":"` + id + `","status":{"code":206}}`))
+			c.handleResponse([]byte(`{"result":{"data":[2],"meta":{}},"requestId":"` + id + `","status":{"code":200}}`))
+		}()
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, _ := uuid.NewV4()
+			frames, err := c.SubmitAsync(context.Background(), Request{RequestID: id.String(), Op: "eval", Args: map[string]interface{}{"gremlin": "g.V()"}})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			n := 0
+			for r := range frames {
+				if n++; r.RequestUUID() != id {
+					t.Errorf("Expected frames of request %s, got %s", id, r.RequestUUID())
+				}
+			}
+			if n != 2 {
+				t.Errorf("Expected 2 frames, got %d", n)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if (Response{RequestID: "not-a-uuid"}).RequestUUID() != uuid.Nil {
+		t.Error("Expected uuid.Nil for an id which is not a UUID")
+	}
+}
+
+func TestSubmitAsyncDeliversErrorFrame(t *testing.T) {
+	c, fake := startFakeClient(t)
+	fake.respond = func(id string) []byte {
+		return []byte(`{"result":{"data":null,"meta":{}},"requestId":"` + id + `","status":{"code":597,"attributes":{},"message":"boom"}}`)
+	}
+
+	frames, err := c.SubmitAsync(context.Background(), Request{Op: "eval", Args: map[string]interface{}{"gremlin": "g.V("}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, ok := <-frames
+	if !ok || r.Status.Code != statusScriptEvaluationError {
+		t.Errorf("Expected the frame carrying the error status, got %+v", r)
+	}
+	if _, ok := <-frames; ok {
+		t.Error("Expected the channel to be closed after the error frame")
+	}
+}
+
+func TestSubmitAsyncContextDone(t *testing.T) {
+	c, fake := startFakeClient(t)
+	fake.respond = nil // Never responds
+
+	ctx, cancel := context.WithCancel(context.Background())
+	frames, err := c.SubmitAsync(ctx, Request{Op: "eval", Args: map[string]interface{}{"gremlin": pingQuery}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	select {
+	case _, ok := <-frames:
+		if ok {
+			t.Error("Expected no frames once the context is done")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the channel to be closed once the context is done")
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	c, fake := startFakeClient(t)
+	fake.respond = nil // Never responds
+	SetRequestTimeout(20 * time.Millisecond)(c)
+
+	if _, err := c.Execute("g.V()"); errors.Cause(err) != context.DeadlineExceeded {
+		t.Fatalf("Expected the request to time out, got %v", err)
+	}
+
+	empty := true
+	c.responseNotifier.Range(func(k, v interface{}) bool {
+		empty = false
+		return false
+	})
+	if !empty {
+		t.Error("Expected the timed out request to be cleaned up")
+	}
+}
+
+func TestRequestTimeoutKeepsEarlierDeadline(t *testing.T) {
+	c, fake := startFakeClient(t)
+	fake.respond = nil
+	SetRequestTimeout(time.Hour)(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	frames, err := c.SubmitAsync(ctx, Request{Op: "eval", Args: map[string]interface{}{"gremlin": "g.V()"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-frames:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the earlier deadline of the context to be kept")
+	}
+}
+
+func TestEvaluationTimeoutMargin(t *testing.T) {
+	c, fake := startFakeClient(t)
+	fake.respond = nil // Ignores the evaluation timeout
+	SetEvaluationTimeoutMargin(20 * time.Millisecond)(c)
+
+	req, err := c.NewRequestBuilder().WithGremlin("g.V()").WithScriptEvaluationTimeout(10 * time.Millisecond).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := c.roundTrip(req); err != ErrEvaluationTimeoutExceeded {
+		t.Fatalf("Expected the request to fail past its evaluation timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("Expected the request to be failed after the timeout plus the margin, failed after %v", elapsed)
+	}
+}
+
+func TestEvaluationTimeoutMarginKeepsCallerDeadline(t *testing.T) {
+	c, fake := startFakeClient(t)
+	fake.respond = nil
+	SetEvaluationTimeoutMargin(time.Hour)(c)
+
+	req, err := c.NewRequestBuilder().WithGremlin("g.V()").WithScriptEvaluationTimeout(time.Hour).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.roundTripContext(ctx, req); err != context.DeadlineExceeded {
+		t.Errorf("Expected the deadline of the caller to be reported, got %v", err)
+	}
+}
+
+func TestShutdownLeaksNoGoroutines(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 20; i++ {
+		c, err := Dial(NewDialer(testServerHost(s)), make(chan error, 1), SetResponseHandlerWorkers(2), SetBackpressure(2, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := c.Shutdown(ctx); err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+		if n := c.Goroutines(); n != 0 {
+			t.Fatalf("Expected the client to run no goroutine once shut down, got %d", n)
+		}
+		if _, ok := <-c.Backpressure(); ok {
+			t.Fatal("Expected the backpressure channel to be closed")
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second) // The server side of the connections takes a moment to go away
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("Expected no leaked goroutines, %d before and %d after", before, n)
+	}
+}
+
+func TestGoroutinesCounted(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	before := runtime.NumGoroutine()
+	clients := make([]*Client, 10)
+	for i := range clients {
+		c, err := Dial(NewDialer(testServerHost(s)), make(chan error, 1), SetResponseHandlerWorkers(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		deadline := time.Now().Add(time.Second) // The read worker starts the response handlers
+		for c.Goroutines() < 5 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if n := c.Goroutines(); n != 5 {
+			t.Errorf("Expected the read, write and ping workers and 2 response handlers, got %d goroutines", n)
+		}
+		clients[i] = c
+	}
+	for _, c := range clients {
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if n := c.Goroutines(); n != 0 {
+			t.Errorf("Expected the client to run no goroutine once closed, got %d", n)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("Expected the goroutines to return to %d once the clients were closed, got %d", before, n)
+	}
+}
+
+func TestShutdownFailsPendingRequests(t *testing.T) {
+	c, fake := startFakeClient(t)
+	fake.respond = nil // Never responds
+
+	failed := make(chan error, 1)
+	go func() {
+		_, err := c.Execute("g.V()")
+		failed <- err
+	}()
+	for c.Stats().InFlight == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := c.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-failed; errors.Cause(err) != ErrClientShutdown {
+		t.Errorf("Expected the pending request to fail with ErrClientShutdown, got %v", err)
+	}
+	if _, err := c.Execute("g.V()"); errors.Cause(err) != ErrClientShutdown {
+		t.Errorf("Expected requests after shutdown to fail, got %v", err)
+	}
+	if err := c.Shutdown(context.Background()); err != nil {
+		t.Errorf("Expected shutting down twice to succeed, got %v", err)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	c := newClient()
+	c.goWorker(func() { time.Sleep(time.Second) }) // A worker which hangs
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := c.Shutdown(ctx)
+	if shutdownErr, ok := err.(*ShutdownError); !ok || errors.Cause(shutdownErr.Errors[0]) != context.DeadlineExceeded {
+		t.Errorf("Expected the hanging worker to be reported, got %v", err)
+	}
+}
+
+func TestFirstFrameTimeout(t *testing.T) {
+	c, fake := startFakeClient(t)
+	fake.respond = nil // Never responds
+	SetFirstFrameTimeout(20 * time.Millisecond)(c)
+
+	if _, err := c.Execute("g.V()"); errors.Cause(err) != ErrFirstFrameTimeout {
+		t.Errorf("Expected the first frame timeout, got %v", err)
+	}
+}
+
+func TestFirstFrameTimeoutAllowsLongStreams(t *testing.T) {
+	c, fake := startFakeClient(t)
+	SetFirstFrameTimeout(30 * time.Millisecond)(c)
+	fake.respond = func(id string) []byte {
+		go func() { // The rest of the response takes longer than the first frame timeout
+			time.Sleep(100 * time.Millisecond)
+			c.handleResponse(fakeSuccess(id))
+		}()
+		return []byte(`{"result":{"data":[1],"meta":{}},"requestId":"` + id + `","status":{"code":206,"attributes":{},"message":""}}`)
+	}
+
+	resp, err := c.Execute("g.V()")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp) != 2 {
+		t.Errorf("Expected both frames, got %d", len(resp))
+	}
+}
+
+func TestCloseWaitsForWorkers(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	c := startTestClient(t, NewDialer(testServerHost(s)), make(chan error, 1))
+	c.goWorker(func() { c.conn.ping(c.errs) })
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		c.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(50 * time.Millisecond):
+		t.Error("Expected every worker to have exited once Close returned")
+	}
+}
+
+func TestMaxScriptSize(t *testing.T) {
+	c, fake := startFakeClient(t)
+	SetMaxScriptSize(10)(c)
+
+	if _, err := c.Execute("g.V().has('name', 'marko')"); errors.Cause(err) != ErrScriptTooLarge {
+		t.Errorf("Expected the script to be rejected, got %v", err)
+	}
+	if _, err := c.Execute("g.V()"); err != nil {
+		t.Error(err)
+	}
+	if len(fake.written) != 1 {
+		t.Errorf("Expected only the small script to be sent, got %d", len(fake.written))
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	c, fake := startFakeClient(t)
+	SetReadOnly()(c)
+
+	for _, query := range []string{"g.addV('person')", "g.V('1').property ('name', 'x')", "g.V().drop()", "g.mergeE([:])"} {
+		if _, err := c.Execute(query); errors.Cause(err) != ErrReadOnly {
+			t.Errorf("Expected %s to be rejected, got %v", query, err)
+		}
+	}
+	if _, err := c.Execute("g.V().properties('name')"); err != nil {
+		t.Error(err)
+	}
+	if len(fake.written) != 1 {
+		t.Errorf("Expected only the read to be sent, got %d", len(fake.written))
+	}
+}
+
+func TestInvalidBindingKeys(t *testing.T) {
+	c, fake := startFakeClient(t)
+	SetReservedBindingNames("g")(c)
+
+	for key, reserved := range map[string]bool{"class": true, "g": true, "first-name": false, "1st": false} {
+		_, err := c.ExecuteWithBindings("g.V().has('name', "+key+")", map[string]string{key: "marko"}, map[string]string{})
+		invalid, ok := errors.Cause(err).(*InvalidBindingError)
+		if !ok || invalid.Key != key || invalid.Reserved != reserved {
+			t.Errorf("Expected the binding %q to be rejected, got %v", key, err)
+		}
+	}
+	if _, err := c.ExecuteWithTypedBindings("g.V().has('name', $name_1)", map[string]interface{}{"$name_1": "marko"}, map[string]string{}); err != nil {
+		t.Error(err)
+	}
+	if len(fake.written) != 1 {
+		t.Errorf("Expected only the valid bindings to be sent, got %d", len(fake.written))
+	}
+}
+
+func TestResultShape(t *testing.T) {
+	c, fake := startFakeClient(t)
+	SetResultShape(".valueMap(true)")(c)
+
+	for _, query := range []string{"g.V().hasLabel('person');", "g.V().project('name').by('name')", "g.V().count()"} {
+		if _, err := c.Execute(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.ExecuteUnshaped("g.V().out()"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"g.V().hasLabel('person').valueMap(true)", "g.V().project('name').by('name')", "g.V().count()", "g.V().out()"}
+	requests := writtenRequests(t, fake)
+	if len(requests) != len(expected) {
+		t.Fatalf("Expected %d requests, got %d", len(expected), len(requests))
+	}
+	for i, req := range requests {
+		if req.Args["gremlin"] != expected[i] {
+			t.Errorf("Expected %s to be sent, got %v", expected[i], req.Args["gremlin"])
+		}
+		if _, ok := req.Args[unshapedArg]; ok {
+			t.Error("Expected the unshaped marker not to be sent")
+		}
+	}
+}
+
+func TestQueryValidator(t *testing.T) {
+	c, fake := startFakeClient(t)
+	rejected := errors.New("drop is not allowed")
+	SetQueryValidator(func(query string) error {
+		if strings.Contains(query, "drop()") {
+			return rejected
+		}
+		return nil
+	})(c)
+
+	if _, err := c.Execute("g.V().drop()"); errors.Cause(err) != rejected {
+		t.Errorf("Expected the script to be rejected by the validator, got %v", err)
+	}
+	if len(fake.written) != 0 {
+		t.Error("Expected the rejected script not to be sent")
+	}
+}
+
+func TestRetryDecision(t *testing.T) {
+	c, fake := startFakeClient(t)
+	throttled := true
+	fake.respond = func(id string) []byte {
+		if throttled {
+			throttled = false
+			return []byte(`{"result":{"data":null,"meta":{}},"requestId":"` + id + `","status":{"code":500,"attributes":{"x-ms-retry-after-ms":5},"message":"throttled"}}`)
+		}
+		return fakeSuccess(id)
+	}
+
+	var decisions []int
+	SetRetryDecision(func(attempt int, err error, elapsed time.Duration) (bool, time.Duration) {
+		decisions = append(decisions, attempt)
+		statusErr, ok := errors.Cause(err).(*StatusError)
+		if !ok {
+			return false, 0
+		}
+		after, ok := statusErr.Status.Attributes["x-ms-retry-after-ms"].(float64)
+		return ok, time.Duration(after) * time.Millisecond
+	})(c)
+
+	if _, err := c.Execute("g.V()"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decisions, []int{1}) || len(fake.written) != 2 {
+		t.Errorf("Expected a single retry, decided %v and sent %d requests", decisions, len(fake.written))
+	}
+}
+
+func TestRetryDecisionGivesUp(t *testing.T) {
+	c, fake := startFakeClient(t)
+	fake.respond = func(id string) []byte {
+		return []byte(`{"result":{"data":null,"meta":{}},"requestId":"` + id + `","status":{"code":500,"attributes":{},"message":"down"}}`)
+	}
+	SetRetryDecision(func(attempt int, err error, elapsed time.Duration) (bool, time.Duration) {
+		return attempt < 3, 0
+	})(c)
+
+	if _, err := c.Execute("g.V()"); err == nil {
+		t.Fatal("Expected the request to fail once the decision gave up")
+	}
+	if len(fake.written) != 3 {
+		t.Errorf("Expected the request to be sent 3 times, got %d", len(fake.written))
+	}
+}
+
+func TestRetryAfterThrottling(t *testing.T) {
+	c, fake := startFakeClient(t)
+	throttled := true
+	fake.respond = func(id string) []byte {
+		if throttled {
+			throttled = false
+			return []byte(`{"result":{"data":null,"meta":{}},"requestId":"` + id + `","status":{"code":500,"attributes":{"x-ms-retry-after-ms":"00:00:00.0200000","x-ms-status-code":429},"message":"throttled"}}`)
+		}
+		return fakeSuccess(id)
+	}
+
+	start := time.Now()
+	if _, err := c.Execute("g.addV('person')"); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.written) != 2 {
+		t.Errorf("Expected the throttled request to be sent again, sent %d requests", len(fake.written))
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Expected the retry to wait for the hinted 20ms, waited %s", elapsed)
+	}
+}
+
+func TestQueryInErrors(t *testing.T) {
+	failing := func(id string) []byte {
+		return []byte(`{"result":{"data":null,"meta":{}},"requestId":"` + id + `","status":{"code":597,"attributes":{},"message":"failed"}}`)
+	}
+
+	c, fake := startFakeClient(t)
+	fake.respond = failing
+	if _, err := c.Execute("g.V().has('ssn', '123-45-6789')"); err == nil || strings.Contains(err.Error(), "ssn") {
+		t.Errorf("Expected the query to be left out of the error by default, got %v", err)
+	}
+
+	SetQueryInErrors(17, func(query string) string {
+		return regexp.MustCompile(`'[^']*'`).ReplaceAllString(query, "'?'")
+	})(c)
+	_, err := c.Execute("g.V().has('ssn', '123-45-6789')")
+	if err == nil || !strings.HasPrefix(err.Error(), "query: g.V().has('?', '?..."+":") {
+		t.Errorf("Expected the redacted and truncated query in the error, got %v", err)
+	}
+	if _, ok := errors.Cause(err).(*StatusError); !ok {
+		t.Errorf("Expected the status error to remain the cause, got %T", errors.Cause(err))
+	}
+}
+
+func TestConcurrentUse(t *testing.T) {
+	s := newTestServer(t, func(conn *websocket.Conn, msg []byte) {
+		var req Request
+		json.Unmarshal(msg[msg[0]+1:], &req)
+		data := req.Args["gremlin"].(string)
+		conn.WriteMessage(websocket.BinaryMessage, []byte(`{"result":{"data":"`+data+`","meta":{}},"requestId":"`+req.RequestID+`","status":{"code":200,"attributes":{},"message":""}}`))
+	})
+	defer s.Close()
+
+	c, err := Dial(NewDialer(testServerHost(s)), make(chan error, 1), SetResponseHandlerWorkers(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	const goroutines, requests = 20, 25
+	var wg sync.WaitGroup
+	failures := make(chan error, goroutines*requests)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < requests; i++ {
+				query := fmt.Sprintf("g.V(%d)", g*requests+i)
+				resp, err := c.Execute(query)
+				if err == nil && string(resp[0].Result.Data) != `"`+query+`"` {
+					err = fmt.Errorf("expected the response of %s, got %s", query, resp[0].Result.Data)
+				}
+				if err != nil {
+					failures <- err
+				}
+				c.Stats()
+				c.IsErrored()
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(failures)
+	for err := range failures {
+		t.Error(err)
+	}
+}
+
+func TestExecutePrebuilt(t *testing.T) {
+	c, fake := startFakeClient(t)
+	req, err := NewRequestBuilder().WithGremlin("g.V()").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := c.SerializeRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.ExecutePrebuilt(raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	requests := writtenRequests(t, fake)
+	if len(requests) != 2 || requests[0].RequestID != req.RequestID || requests[1].RequestID != req.RequestID {
+		t.Errorf("Expected the prebuilt request to be sent twice under its id, got %+v", requests)
+	}
+}
+
+func TestExecutePrebuiltInFlight(t *testing.T) {
+	c, fake := startFakeClient(t)
+	held := holdFirstResponse(fake)
+	raw, err := c.SerializeRequest(Request{Op: "eval", Args: map[string]interface{}{"gremlin": "g.V()", "language": "gremlin-groovy"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.ExecutePrebuilt(raw)
+		done <- err
+	}()
+	id := <-held
+
+	if _, err := c.ExecutePrebuilt(raw); err != nil {
+		t.Fatal(err)
+	}
+	c.handleResponse(fakeSuccess(id))
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	requests := writtenRequests(t, fake)
+	if len(requests) != 2 || requests[0].RequestID == requests[1].RequestID {
+		t.Errorf("Expected the concurrent request to be sent under a fresh id, got %+v", requests)
+	}
+}
+
+func TestExecutePrebuiltWithoutID(t *testing.T) {
+	c, _ := startFakeClient(t)
+	if _, err := c.ExecutePrebuilt([]byte(`{"op":"eval"}`)); err == nil {
+		t.Error("Expected a prebuilt request without a request id to be rejected")
+	}
+}
+
+func TestExecutePrebuiltRewritesRequestID(t *testing.T) {
+	c, fake := startFakeClient(t)
+	held := holdFirstResponse(fake)
+	id := "41d2e28a-20a4-4ab0-b379-d810dede3786"
+	raw := append([]byte("\x21application/vnd.gremlin-v3.0+json"), `{"args":{"gremlin":"g.inject('`+id+`')","language":"gremlin-groovy"},"op":"eval","processor":"","requestId":"`+id+`"}`...)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.ExecutePrebuilt(raw)
+		done <- err
+	}()
+	<-held
+	if _, err := c.ExecutePrebuilt(raw); err != nil {
+		t.Fatal(err)
+	}
+	c.handleResponse(fakeSuccess(id))
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	requests := writtenRequests(t, fake)
+	if len(requests) != 2 || requests[1].RequestID == id || requests[1].Args["gremlin"] != "g.inject('"+id+"')" {
+		t.Errorf("Expected only the request id of the concurrent request to be rewritten, got %+v", requests)
+	}
+}
+
+func TestExecutePrebuiltTimeoutCleansUp(t *testing.T) {
+	c, fake := startFakeClient(t)
+	fake.respond = nil
+	SetRequestTimeout(20 * time.Millisecond)(c)
+	raw, err := c.SerializeRequest(Request{Op: "eval", Args: map[string]interface{}{"gremlin": "g.V()"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.ExecutePrebuilt(raw); err != context.DeadlineExceeded {
+			t.Fatalf("Expected the request to time out, got %v", err)
+		}
+	}
+	requests := writtenRequests(t, fake)
+	if requests[0].RequestID != requests[1].RequestID {
+		t.Error("Expected the timed out request to be given up, so its id is free to be sent again")
+	}
+	c.responseNotifier.Range(func(k, v interface{}) bool {
+		t.Errorf("Expected the timed out request %v to be cleaned up", k)
+		return true
+	})
+}
+
+func TestExecutePrebuiltValidated(t *testing.T) {
+	c, fake := startFakeClient(t)
+	SetReadOnly()(c)
+	raw, err := c.SerializeRequest(Request{Op: "eval", Args: map[string]interface{}{"gremlin": "g.addV('person')"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ExecutePrebuilt(raw); errors.Cause(err) != ErrReadOnly {
+		t.Errorf("Expected the prebuilt mutation to be rejected, got %v", err)
+	}
+	if len(fake.written) != 0 {
+		t.Error("Expected nothing to be sent")
+	}
+}
+
+func TestOrderedReconnect(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	s := newTestServer(t, func(conn *websocket.Conn, msg []byte) {
+		var req Request
+		json.Unmarshal(msg[msg[0]+1:], &req)
+		mu.Lock()
+		received = append(received, req.Args["gremlin"].(string))
+		mu.Unlock()
+		conn.WriteMessage(websocket.BinaryMessage, fakeSuccess(req.RequestID))
+	})
+	defer s.Close()
+
+	ws := NewDialer(testServerHost(s), SetLogger(&recordingLogger{}))
+	c := startTestClient(t, ws, make(chan error, 10), SetOrderedReconnect(), SetRequestChannelSize(10))
+	defer c.Shutdown(context.Background())
+	if _, err := c.Execute("g.V(0)"); err != nil {
+		t.Fatal(err)
+	}
+
+	ws.conn.Close() // The connection drops, requests queue until the client is reset
+	var results []<-chan Response
+	for i := 1; i <= 5; i++ {
+		req, _ := NewRequestBuilder().WithGremlin(fmt.Sprintf("g.V(%d)", i)).Build()
+		frames, err := c.SubmitAsync(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, frames)
+	}
+	time.Sleep(20 * time.Millisecond) // Lets the write of the first one fail
+
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	for _, frames := range results {
+		for frame := range frames {
+			if frame.Status.Code != statusSuccess {
+				t.Errorf("Expected the queued request to succeed after the reset, got %+v", frame.Status)
+			}
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{"g.V(0)", "g.V(1)", "g.V(2)", "g.V(3)", "g.V(4)", "g.V(5)"}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("Expected the queued requests in submission order, got %v", received)
+	}
+}
+
+func TestInitQuery(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	s := newTestServer(t, func(conn *websocket.Conn, msg []byte) {
+		var req Request
+		json.Unmarshal(msg[msg[0]+1:], &req)
+		mu.Lock()
+		received = append(received, req.Args["gremlin"].(string))
+		mu.Unlock()
+		conn.WriteMessage(websocket.BinaryMessage, fakeSuccess(req.RequestID))
+	})
+	defer s.Close()
+
+	ws := NewDialer(testServerHost(s), SetLogger(&recordingLogger{}))
+	c, err := Dial(ws, make(chan error, 10), SetInitQuery("g.V().limit(1)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown(context.Background())
+	if _, err := c.Execute("g.V(1)"); err != nil {
+		t.Fatal(err)
+	}
+	ws.conn.Close()
+	if err := c.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Execute("g.V(2)"); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{"g.V().limit(1)", "g.V(1)", "g.V().limit(1)", "g.V(2)"}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("Expected the init query first on every connection, got %v", received)
+	}
+}
+
+func TestInitQueryFailsDial(t *testing.T) {
+	s := newTestServer(t, func(conn *websocket.Conn, msg []byte) {
+		var req Request
+		json.Unmarshal(msg[msg[0]+1:], &req)
+		conn.WriteMessage(websocket.BinaryMessage, []byte(`{"result":{"data":null,"meta":{}},"requestId":"`+req.RequestID+`","status":{"code":597,"attributes":{},"message":"boom"}}`))
+	})
+	defer s.Close()
+
+	ws := NewDialer(testServerHost(s), SetLogger(&recordingLogger{}))
+	if _, err := Dial(ws, make(chan error, 10), SetInitQuery("g.fail()")); err == nil {
+		t.Fatal("Expected Dial to fail with the init query")
+	}
+	if !ws.IsDisposed() {
+		t.Error("Expected the connection to be closed after the init query failed")
+	}
+}
+
+func TestClientString(t *testing.T) {
+	c := newClient()
+	c.conn = &Ws{host: "wss://localhost:8182", connected: true}
+	c.requestStarted()
+
+	if s, want := c.String(), `Client{host:"wss://localhost:8182", connected:true, inFlight:1, errored:false, uptime:0s}`; s != want {
+		t.Errorf("Expected %s, got %s", want, s)
+	}
+	if s, want := c.conn.(*Ws).String(), `Ws{host:"wss://localhost:8182", connected:true, disposed:false}`; s != want {
+		t.Errorf("Expected %s, got %s", want, s)
+	}
+}
+
+func TestDialContextCancelled(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done() // The handshake never completes
+	}))
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := DialContext(ctx, NewDialer(testServerHost(s), SetLogger(&recordingLogger{})), make(chan error, 1))
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the dial to end once cancelled, it took %s", elapsed)
+	}
+}
@@ -0,0 +1,48 @@
+package gremtune
+
+import "time"
+
+// clock is the source of time of the time based logic, such as timeouts, pings, retry backoff and the eviction of
+// idle connections. It is the real clock outside of tests, which replace it to move time forward without sleeping.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) ticker
+	NewTimer(d time.Duration) timer
+}
+
+// ticker is a time.Ticker of a clock
+type ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// timer is a time.Timer of a clock
+type timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// realClock is the clock of the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTicker(d time.Duration) ticker       { return realTicker{time.NewTicker(d)} }
+func (realClock) NewTimer(d time.Duration) timer         { return realTimer{time.NewTimer(d)} }
+
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+type realTimer struct{ *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+// orRealClock returns c, or the real clock for values created without one
+func orRealClock(c clock) clock {
+	if c == nil {
+		return realClock{}
+	}
+	return c
+}
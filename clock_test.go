@@ -0,0 +1,135 @@
+package gremtune
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock whose time only moves forward with Advance
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+// fakeTimer fires once it is due, tickers fire again every period
+type fakeTimer struct {
+	clock   *fakeClock
+	at      time.Time
+	period  time.Duration
+	c       chan time.Time
+	stopped bool
+}
+
+type fakeTicker struct{ *fakeTimer }
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) After(d time.Duration) <-chan time.Time { return f.NewTimer(d).C() }
+func (f *fakeClock) NewTicker(d time.Duration) ticker       { return fakeTicker{f.add(d, d)} }
+func (f *fakeClock) NewTimer(d time.Duration) timer         { return f.add(d, 0) }
+
+func (f *fakeClock) add(d, period time.Duration) *fakeTimer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := &fakeTimer{clock: f, at: f.now.Add(d), period: period, c: make(chan time.Time, 1)}
+	f.timers = append(f.timers, t)
+	return t
+}
+
+// Advance moves the time forward by d, firing the timers which became due
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	for _, t := range f.timers {
+		for !t.stopped && !t.at.After(f.now) {
+			select {
+			case t.c <- f.now:
+			default: // Like time.Ticker, ticks are dropped for slow receivers
+			}
+			if t.period <= 0 {
+				t.stopped = true
+			} else {
+				t.at = t.at.Add(t.period)
+			}
+		}
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := !t.stopped
+	t.stopped = true
+	return active
+}
+
+func (t fakeTicker) Stop() { t.fakeTimer.Stop() }
+
+func TestSleepContextClock(t *testing.T) {
+	clk := newFakeClock()
+	done := make(chan error, 1)
+	go func() { done <- sleepContext(context.Background(), clk, time.Hour) }()
+
+	for !clk.hasTimers() {
+		time.Sleep(time.Millisecond)
+	}
+	clk.Advance(time.Hour)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the sleep to end once the clock moved on by an hour")
+	}
+}
+
+func (f *fakeClock) hasTimers() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.timers) > 0
+}
+
+func TestPurgeClock(t *testing.T) {
+	clk := newFakeClock()
+	p := &Pool{IdleTimeout: time.Minute, clock: clk}
+	p.put(&PooledConnection{Pool: p, Client: &Client{}})
+
+	clk.Advance(59 * time.Second)
+	if expired := p.purge(); len(expired) != 0 {
+		t.Errorf("Expected the connection to stay idle within the idle timeout, got %d expired", len(expired))
+	}
+	clk.Advance(time.Second)
+	if expired := p.purge(); len(expired) != 1 || len(p.idle) != 0 {
+		t.Errorf("Expected the connection to expire after the idle timeout, got %d expired", len(expired))
+	}
+}
+
+func TestResultCacheClock(t *testing.T) {
+	clk := newFakeClock()
+	rc := newResultCache(time.Minute, 0)
+	rc.clock = clk
+	rc.put("key", []Response{dummySuccessfulResponseMarshalled})
+
+	if _, ok := rc.get("key"); !ok {
+		t.Error("Expected the entry to be cached within its ttl")
+	}
+	clk.Advance(time.Minute + time.Millisecond)
+	if _, ok := rc.get("key"); ok {
+		t.Error("Expected the entry to expire after its ttl")
+	}
+}
@@ -1,6 +1,287 @@
 package gremtune
 
-import "time"
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClientConfig is the type for defining configuration for the gremtune client
+type ClientConfig func(*Client)
+
+// SetSerializer sets the serializer used to encode requests and decode responses
+func SetSerializer(s Serializer) ClientConfig {
+	return func(c *Client) {
+		c.serializer = s
+	}
+}
+
+// SetFrameHandler disables the aggregation of response frames. Every frame is handed to the handler as soon
+// as it arrives and Execute only returns the error of the request, without any response data.
+func SetFrameHandler(handler FrameHandler) ClientConfig {
+	return func(c *Client) {
+		c.frameHandler = handler
+	}
+}
+
+// SetResultCache caches the responses of read queries in memory for ttl, keeping at most maxEntries results.
+// Queries containing addV, addE, property or drop steps are never cached.
+func SetResultCache(ttl time.Duration, maxEntries int) ClientConfig {
+	return func(c *Client) {
+		c.cache = newResultCache(ttl, maxEntries)
+	}
+}
+
+// SetCacheInvalidation flushes the result cache whenever a mutating query is executed
+func SetCacheInvalidation() ClientConfig {
+	return func(c *Client) {
+		c.flushOnMutation = true
+	}
+}
+
+// SetResponseHandlerWorkers hands response frames from the read worker to a pool of workers, so that decoding
+// and aggregating a frame does not hold up reading the next one. The frames of a request are all handled by the
+// same worker, in the order they arrived. Decoding only runs in parallel on machines with several cores, see
+// BenchmarkResponseHandlers.
+func SetResponseHandlerWorkers(workers int) ClientConfig {
+	return func(c *Client) {
+		c.responseWorkers = workers
+	}
+}
+
+// SetMaxGoroutines caps the goroutines the client runs, as counted by Goroutines. The read, write and ping workers,
+// response handlers and reconnects are always started, while SubmitAsync returns ErrTooManyGoroutines instead of
+// starting the goroutine delivering the frames of another request once the cap is reached. 0 is unlimited.
+func SetMaxGoroutines(max int) ClientConfig {
+	return func(c *Client) {
+		c.maxGoroutines = int64(max)
+	}
+}
+
+// SetResponseBufferPool reads response frames into buffers recycled once a frame has been decoded, instead of
+// allocating a new slice for every frame, which reduces garbage at high throughput. Buffers start at sizeHint bytes
+// and follow the average frame size. Custom serializers must not keep references to the frame they decode.
+func SetResponseBufferPool(sizeHint int) ClientConfig {
+	return func(c *Client) {
+		c.buffers = newBufferPool(sizeHint)
+	}
+}
+
+// SetGraphName sends every script with the graphName arg, which some servers route requests to a named graph by
+// instead of aliases. Servers differ in which of the two they understand, use RequestBuilder.WithAlias for aliases.
+func SetGraphName(name string) ClientConfig {
+	return func(c *Client) {
+		c.graphName = name
+	}
+}
+
+// SetBackpressure signals on Client.Backpressure when the number of queued outbound requests reaches high and when
+// it drops back to low, so that producers can slow down before Execute blocks
+func SetBackpressure(high, low int) ClientConfig {
+	return func(c *Client) {
+		c.backpressure = newBackpressure(high, low)
+	}
+}
+
+// SetRequestChannelSize sets how many outbound requests can be queued before Execute blocks. A size of 0 queues
+// none, so Execute blocks until the write worker takes the request. A negative size keeps the default of 3.
+func SetRequestChannelSize(size int) ClientConfig {
+	return func(c *Client) {
+		if size < 0 {
+			return
+		}
+		c.requests = make(chan []byte, size)
+	}
+}
+
+// SetRequestIDGenerator sets the generator of request ids, such as ULIDRequestIDs() or SequentialRequestIDs()
+// for request ids which sort in the order requests were sent. Request ids are UUIDv4 by default.
+func SetRequestIDGenerator(gen RequestIDGenerator) ClientConfig {
+	return func(c *Client) {
+		c.requestIDs = gen
+	}
+}
+
+// SetRequestTimeout bounds every request of the client, including session requests and SubmitAsync, by timeout.
+// A request given a context with an earlier deadline keeps that deadline.
+func SetRequestTimeout(timeout time.Duration) ClientConfig {
+	return func(c *Client) {
+		c.requestTimeout = timeout
+	}
+}
+
+// SetWriteCoalescing gathers the requests queued within window, such as 100µs, and writes them back to back in one
+// go, for many small queries sent at once. Every request stays a frame of its own. The window starts with the first
+// request of a batch and never outlasts the request timeout, a batch is written early once it holds
+// maxCoalescedRequests. Requests are written as soon as they are queued by default.
+func SetWriteCoalescing(window time.Duration) ClientConfig {
+	return func(c *Client) {
+		c.writeCoalescing = window
+		if window > 0 {
+			c.held = make(chan []byte, maxCoalescedRequests) // A failed batch is held as a whole
+		}
+	}
+}
+
+// SetFirstFrameTimeout fails requests with ErrFirstFrameTimeout when the first frame of their response does not
+// arrive within timeout, so queries which never start producing results fail fast. Once the first frame has
+// arrived, the rest of the response is only bounded by SetRequestTimeout and the context of the request.
+func SetFirstFrameTimeout(timeout time.Duration) ClientConfig {
+	return func(c *Client) {
+		c.firstFrameTimeout = timeout
+	}
+}
+
+// SetMaxScriptSize rejects scripts longer than size bytes with ErrScriptTooLarge before they are sent, protecting
+// shared servers from accidental giant queries. Scripts of any length are sent by default.
+func SetMaxScriptSize(size int) ClientConfig {
+	return func(c *Client) {
+		c.maxScriptSize = size
+	}
+}
+
+// SetReadOnly rejects scripts with a step writing to the graph, addV, addE, mergeV, mergeE, property or drop, with
+// ErrReadOnly before they are sent, for clients of read replicas. Every request of the client is checked: scripts
+// of the Execute methods, sessions, SubmitAsync and ExecuteReduce, the init query and reconnect hooks, bytecode of
+// ExecuteBytecode, ExecuteSteps and BulkClient.Execute by the operators of its steps, and prebuilt GraphSON requests
+// of ExecutePrebuilt. The steps are found by their name, so scripts writing to the graph in other ways, such as
+// through the Java API of the graph, are still sent.
+func SetReadOnly() ClientConfig {
+	return func(c *Client) {
+		c.readOnly = true
+	}
+}
+
+// SetReservedBindingNames rejects bindings named like one of names with an InvalidBindingError, in addition to the
+// Groovy keywords, such as the variables the scripts of the client define themselves or the traversal source g.
+func SetReservedBindingNames(names ...string) ClientConfig {
+	return func(c *Client) {
+		if c.reservedBindings == nil {
+			c.reservedBindings = make(map[string]bool, len(names))
+		}
+		for _, name := range names {
+			c.reservedBindings[name] = true
+		}
+	}
+}
+
+// SetResultShape appends step, such as valueMap(true) or elementMap(), to every script without a step projecting
+// its result already, such as project, valueMap, elementMap, select, values or count, so that results have a
+// uniform shape. Scripts are shaped by appending the step to their end, which suits scripts of a single traversal.
+// Use ExecuteUnshaped for scripts which must be sent as they are.
+func SetResultShape(step string) ClientConfig {
+	return func(c *Client) {
+		c.resultShape = strings.TrimPrefix(step, ".")
+	}
+}
+
+// SetQueryValidator checks every script with validator before it is sent, a script it returns an error for is
+// not sent and the request fails with that error
+func SetQueryValidator(validator QueryValidator) ClientConfig {
+	return func(c *Client) {
+		c.validator = validator
+	}
+}
+
+// SetMetricsCollector reports the size of every request and response frame to collector, as MetricRequestBytes
+// and MetricResponseBytes
+func SetMetricsCollector(collector MetricsCollector) ClientConfig {
+	return func(c *Client) {
+		c.metrics = collector
+	}
+}
+
+// SetRetryDecision lets decision decide about every retry of a failed request, including error responses of the
+// server, replacing the built in retries after a reset. It allows custom backoff and server specific throttling.
+func SetRetryDecision(decision RetryDecision) ClientConfig {
+	return func(c *Client) {
+		c.retryDecision = decision
+	}
+}
+
+// SetClientCredentials sets the credentials the dialer of the client authenticates with, like SetCredentials on
+// the dialer. Empty credentials clear them.
+func SetClientCredentials(username, password string) ClientConfig {
+	return func(c *Client) {
+		if ws, ok := c.conn.(*Ws); ok {
+			ws.setCredentials(username, password)
+		}
+	}
+}
+
+// SetQueryInErrors attaches the query to the errors of failed requests, so logs show what failed. The query is
+// rewritten by redact unless it is nil, and truncated to maxLength bytes when maxLength is positive. Queries are
+// left out of errors by default, as they may contain sensitive literals.
+func SetQueryInErrors(maxLength int, redact QueryRedactor) ClientConfig {
+	return func(c *Client) {
+		c.errorQuery = &errorQuery{maxLength: maxLength, redact: redact}
+	}
+}
+
+// SetEvaluationTimeoutMargin fails requests setting an evaluation timeout, such as with
+// RequestBuilder.WithScriptEvaluationTimeout, with ErrEvaluationTimeoutExceeded when their response did not complete
+// within that timeout plus margin. It guards against servers ignoring the timeout. 0, the default, disables it.
+func SetEvaluationTimeoutMargin(margin time.Duration) ClientConfig {
+	return func(c *Client) {
+		c.evaluationMargin = margin
+	}
+}
+
+// SetOrderedReconnect keeps the requests which were not written yet when the connection is lost queued across
+// Reset, instead of failing them with ErrReset. Once reconnected they are written in the order they were submitted,
+// before any request submitted later. Requests already written to the lost connection still fail with ErrReset.
+func SetOrderedReconnect() ClientConfig {
+	return func(c *Client) {
+		c.unsent = &sync.Map{}
+	}
+}
+
+// SetRetryReadOnly sends read only queries again when a reset interrupts them. Queries with mutating steps fail
+// with ErrMutationNotRetried instead, unless they were marked with ExecuteIdempotent.
+func SetRetryReadOnly() ClientConfig {
+	return func(c *Client) {
+		c.retryReadOnly = true
+	}
+}
+
+// SetInitQuery runs query once on every new connection of the client, the first one and those of Reset, before the
+// connection is used for any other request. It suits one-off setup such as creating indexes or warming a cache.
+// Requests wait for it to finish. When it fails, Dial fails and a reset client is left errored with its connection
+// closed. Unlike SetReconnectHook it also runs on the first connection.
+func SetInitQuery(query string) ClientConfig {
+	return func(c *Client) {
+		c.initQuery = query
+	}
+}
+
+// SetMaxResponseSize fails requests whose response frames are larger than max bytes with ErrResponseTooLarge. Such
+// frames are skipped without being read into memory, and the connection stays usable. Frames exceeding max by more
+// than maxResponseOverhead hit the read limit of the WebSocket connection, which drops it.
+func SetMaxResponseSize(max int64) ClientConfig {
+	return func(c *Client) {
+		c.MaxResponseSize = max
+	}
+}
+
+// SetMaxResultSize fails requests whose result, reassembled from all of its frames, is larger than max bytes with
+// ErrResultTooLarge. Unlike SetMaxResponseSize it bounds results streamed in many frames which are each small
+// enough. The frames aggregated so far are dropped with the request, and so are those arriving after it failed.
+func SetMaxResultSize(max int64) ClientConfig {
+	return func(c *Client) {
+		c.MaxResultSize = max
+	}
+}
+
+// SetRawResponses keeps the frame every response was decoded from, as returned by Response.Raw and decoded by
+// Response.Unmarshal, for callers passing responses on or decoding them into types of their own. The fields of the
+// responses are still decoded, as the client needs their request id and status. Every frame is copied once more.
+func SetRawResponses() ClientConfig {
+	return func(c *Client) {
+		c.rawResponses = true
+	}
+}
 
 //DialerConfig is the struct for defining configuration for WebSocket dialer
 type DialerConfig func(*Ws)
@@ -9,6 +290,15 @@ type DialerConfig func(*Ws)
 func SetAuthentication(username string, password string) DialerConfig {
 	return func(c *Ws) {
 		c.auth = &auth{username: username, password: password}
+		c.authFailed = false
+	}
+}
+
+// SetCredentials sets the credentials the dialer authenticates with. Empty credentials clear them, leaving the
+// dialer unauthenticated.
+func SetCredentials(username, password string) DialerConfig {
+	return func(c *Ws) {
+		c.setCredentials(username, password)
 	}
 }
 
@@ -40,3 +330,124 @@ func SetReadingWait(seconds int) DialerConfig {
 		c.readingWait = time.Duration(seconds) * time.Second
 	}
 }
+
+// SetAuthTimeout sets the time the server has to accept the credentials of a new connection, 10 seconds by default.
+// It is counted apart from the handshake, connecting fails with ErrAuthTimeout once it ran out.
+func SetAuthTimeout(timeout time.Duration) DialerConfig {
+	return func(c *Ws) {
+		c.authTimeout = timeout
+	}
+}
+
+// SetCloseTimeout sets the time for waiting that the server acknowledges the close of the connection
+func SetCloseTimeout(seconds int) DialerConfig {
+	return func(c *Ws) {
+		c.closeTimeout = time.Duration(seconds) * time.Second
+	}
+}
+
+// SetCompression negotiates per message compression with the server and compresses every request frame of at
+// least threshold bytes, such as large scripts. Smaller frames are sent uncompressed. Ws.CompressionNegotiated
+// reports whether the server accepted compression.
+func SetCompression(threshold int) DialerConfig {
+	return func(c *Ws) {
+		c.compression = threshold
+	}
+}
+
+// SetSubprotocols sets the subprotocols requested during the handshake, in order of preference, for gateways which
+// only accept connections negotiating one. Ws.Subprotocol returns the one the server selected.
+func SetSubprotocols(protocols ...string) DialerConfig {
+	return func(c *Ws) {
+		c.subprotocols = protocols
+	}
+}
+
+// SetUserAgent sets the User-Agent header of the handshake, such as "inventory-service/1.4", so that operators of
+// shared servers can attribute connections to the application making them.
+func SetUserAgent(userAgent string) DialerConfig {
+	return func(c *Ws) {
+		c.userAgent = userAgent
+	}
+}
+
+// SetGremlinPathOrder sets whether the host is dialed as given or with /gremlin appended to its path first, or only
+// one of them. Servers known to serve /gremlin, as most managed ones do, connect without a failed attempt first
+// with GremlinPathFirst or GremlinPathOnly.
+func SetGremlinPathOrder(order GremlinPathOrder) DialerConfig {
+	return func(c *Ws) {
+		c.gremlinPath = order
+	}
+}
+
+// SetNetDialer sets the dialer opening the TCP connection to the server, for socket level options such as
+// &net.Dialer{KeepAlive: 30 * time.Second} on networks dropping idle connections. A nil dialer keeps the default.
+func SetNetDialer(d *net.Dialer) DialerConfig {
+	return func(c *Ws) {
+		c.netDialer = d
+	}
+}
+
+// SetCertificatePin only accepts servers whose certificate has the fingerprint, the hex encoded hash of the DER
+// encoded certificate such as printed by openssl x509 -fingerprint -sha256, for self-signed certificates or
+// deployments not trusting the PKI. The certificate chain is not verified. A mismatch fails the dial with a
+// *CertificatePinError. A TLS configuration the dialer already has is kept, with the pin added to it.
+func SetCertificatePin(fingerprint string, algo HashAlgo) DialerConfig {
+	return func(c *Ws) {
+		if c.tlsConfig == nil {
+			c.tlsConfig = &tls.Config{}
+		}
+		pinCertificate(c.tlsConfig, fingerprint, algo)
+	}
+}
+
+// SetCredentialProvider makes the dialer fetch the credentials it authenticates with from provider for every
+// connection, reconnects included, replacing those set by SetCredentials. An error of the provider fails the connect.
+func SetCredentialProvider(provider CredentialProvider) DialerConfig {
+	return func(c *Ws) {
+		c.credentials = provider
+	}
+}
+
+// SetConnectListener sets a listener told about every connection the dialer established, such as whether it was a
+// reconnect and whether it was authenticated with fresh credentials.
+func SetConnectListener(listener ConnectListener) DialerConfig {
+	return func(c *Ws) {
+		c.onConnect = listener
+	}
+}
+
+// SetPreDialHook sets a hook called before every attempt to dial a host, such as to acquire a semaphore. An error
+// returned by the hook aborts the attempt.
+func SetPreDialHook(hook PreDialHook) DialerConfig {
+	return func(c *Ws) {
+		c.preDial = hook
+	}
+}
+
+// SetPostDialHook sets a hook called after every attempt to dial a host, whether it succeeded or not, such as to
+// emit a metric or release what the PreDialHook acquired.
+func SetPostDialHook(hook PostDialHook) DialerConfig {
+	return func(c *Ws) {
+		c.postDial = hook
+	}
+}
+
+// SetFailoverHosts sets alternate hosts for a single connection, as opposed to spreading connections with a Pool.
+// Whenever the dialer connects, it tries the primary host first and then each alternate in order, so a reset
+// client fails over while the primary is down and returns to it once it has recovered.
+func SetFailoverHosts(hosts ...string) DialerConfig {
+	return func(c *Ws) {
+		c.failover = make([]string, len(hosts))
+		for i, host := range hosts {
+			c.failover[i] = normalizeHost(host)
+		}
+	}
+}
+
+// SetLogger sets the logger used to report diagnostic events of the connection
+func SetLogger(logger Logger) DialerConfig {
+	return func(c *Ws) {
+		c.logger = logger
+	}
+}
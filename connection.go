@@ -1,10 +1,20 @@
 package gremtune
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"sync"
+	"sync/atomic"
 
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
@@ -12,13 +22,15 @@ import (
 
 type dialer interface {
 	connect() error
+	reconnect() error
 	IsConnected() bool
 	IsDisposed() bool
 	write([]byte) error
 	read() (int, []byte, error)
 	close() error
-	getAuth() *auth
+	getAuth() (*auth, error)
 	ping(errs chan error)
+	waitForConnection(ctx context.Context) error
 }
 
 /////
@@ -32,16 +44,66 @@ type Ws struct {
 	host         string
 	conn         *websocket.Conn
 	auth         *auth
+	credentials  CredentialProvider // credentials are fetched again for every connection when set
+	authTimeout  time.Duration      // authTimeout bounds the authentication exchange of a new connection
+	authFailed   bool               // authFailed is set once the server rejected the credentials, which are not sent again
 	disposed     bool
 	connected    bool
+	stateChanged *sync.Cond // stateChanged is broadcast whenever the connection becomes connected
 	pingInterval time.Duration
+	pingFailures int64 // pingFailures counts the pings which could not be written, accessed atomically
 	writingWait  time.Duration
 	readingWait  time.Duration
 	timeout      time.Duration
+	closeTimeout time.Duration
+	compression  int // compression is the frame size from which writes are compressed, 0 disables compression
+	subprotocols []string
+	userAgent    string // userAgent is sent in the handshake instead of the default of the WebSocket library
+	gremlinPath  GremlinPathOrder
+	extensions   []string    // extensions are the WebSocket extensions the server accepted in the last handshake
+	netDialer    *net.Dialer // netDialer opens the TCP connection when set, for socket level options
+	tlsConfig    *tls.Config // tlsConfig configures wss connections, the default verifies the server with the system roots
+	primary      string      // primary is the host connections are made to while it is reachable
+	failover     []string    // failover are the hosts tried in order when the primary cannot be reached
+	preDial      PreDialHook
+	postDial     PostDialHook
+	onConnect    ConnectListener
+	dialedBefore bool // dialedBefore is set once a connection was established, later ones are reconnects
 	quit         chan struct{}
+	quitOnce     sync.Once     // quitOnce closes quit once, however often the connection is closed
+	readClosed   chan struct{} // readClosed is closed once reading from the current connection has failed
+	writeMu      sync.Mutex    // writeMu serializes all writes, ping and close frames included, see write
+	logger       Logger
+	serializer   Serializer     // serializer encodes the authentication exchange, the serializer of the client dialing
+	configs      []DialerConfig // configs are kept so that the dialer can be recreated for a new connection
+	clock        clock          // clock times the pings and the close, the real clock when nil
 	sync.RWMutex
 }
 
+// PreDialHook is called before every attempt to dial a host, including those of reconnects. An error aborts the
+// attempt and is returned as the dial error.
+type PreDialHook func(host string) error
+
+// CredentialProvider returns the credentials a new connection authenticates with. It is called for every
+// connection, the first one and those of reconnects, so that short-lived tokens which expired while the server was
+// unreachable are not sent again.
+type CredentialProvider func(ctx context.Context) (username, password string, err error)
+
+// ConnectEvent describes a connection the dialer established
+type ConnectEvent struct {
+	Host                 string
+	Reconnect            bool // Reconnect is set for every connection after the first one of the dialer
+	CredentialsRefreshed bool // CredentialsRefreshed is set when the CredentialProvider was asked for credentials
+	Authenticated        bool // Authenticated is set when the server asked for the credentials and accepted them
+}
+
+// ConnectListener is called whenever the dialer established a connection, before it is used
+type ConnectListener func(event ConnectEvent)
+
+// PostDialHook is called after every attempt to dial a host, with the connection on success or the error when
+// the attempt failed or was aborted by the PreDialHook.
+type PostDialHook func(host string, conn *websocket.Conn, err error)
+
 //Auth is the container for authentication data of dialer
 type auth struct {
 	username string
@@ -49,102 +111,687 @@ type auth struct {
 }
 
 func (ws *Ws) connect() (err error) {
+	return ws.connectContext(context.Background())
+}
+
+// connectContext connects like connect, giving up when ctx is done. The handshake timeout still applies, whichever
+// ends first bounds the handshake.
+func (ws *Ws) connectContext(ctx context.Context) (err error) {
 	d := websocket.Dialer{
-		WriteBufferSize:  8192,
-		ReadBufferSize:   8192,
-		HandshakeTimeout: 5 * time.Second, // Timeout or else we'll hang forever and never fail on bad hosts.
+		WriteBufferSize:   8192,
+		ReadBufferSize:    8192,
+		HandshakeTimeout:  5 * time.Second, // Timeout or else we'll hang forever and never fail on bad hosts.
+		EnableCompression: ws.compression > 0,
+		Subprotocols:      ws.subprotocols,
+		TLSClientConfig:   ws.tlsConfig,
+	}
+	if ws.netDialer != nil {
+		d.NetDialContext = ws.netDialer.DialContext
+	}
+
+	refreshed := ws.credentials != nil
+	if refreshed {
+		username, password, err := ws.credentials(ctx)
+		if err != nil {
+			return errors.Wrap(err, "fetching credentials")
+		}
+		ws.setCredentials(username, password)
+	}
+
+	if ws.primary == "" {
+		ws.primary = ws.host
+	}
+	hosts := append([]*string{&ws.primary}, ws.failoverHosts()...)
+	for i, host := range hosts { // Always starts over at the primary, so it is preferred again once it recovered
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		ws.host = *host
+		if err = ws.dial(ctx, &d); err == nil {
+			*host = ws.host // Keeps a /gremlin suffix the host needed
+			if i > 0 {
+				ws.getLogger().Info("Failed over to alternate host", "host", ws.host, "primary", ws.primary)
+			}
+			break
+		}
+	}
+
+	authenticated := false
+	if err == nil {
+		if authenticated, err = ws.authenticateIfRequired(ctx); err != nil {
+			ws.conn.Close()
+		}
+	}
+	if err != nil {
+		if ctx.Err() != nil { // Cancelled, rather than failed on its own
+			return ctx.Err()
+		}
+		return
+	}
+
+	if ws.compression > 0 && !ws.CompressionNegotiated() {
+		ws.getLogger().Info("Compression requested but not negotiated by the server, frames are sent uncompressed", "host", ws.host)
+	}
+	ws.setConnected(true)
+	ws.conn.SetPongHandler(func(appData string) error {
+		ws.setConnected(true)
+		return nil
+	})
+	if ws.onConnect != nil {
+		ws.onConnect(ConnectEvent{Host: ws.host, Reconnect: ws.dialedBefore, CredentialsRefreshed: refreshed, Authenticated: authenticated})
+	}
+	ws.dialedBefore = true
+	return
+}
+
+// authenticateIfRequired authenticates a new connection with the credentials of the dialer, if it has any, before
+// the workers of the client start using it, so that rejected credentials fail connecting rather than the first
+// query. Servers only ask for credentials in answer to a request, so a trivial query is sent as a probe and the
+// credentials are sent when the server challenges it. Sessions are opened on connections which were connected this
+// way, so they need no authentication of their own. It returns whether the credentials were sent and accepted.
+func (ws *Ws) authenticateIfRequired(ctx context.Context) (authenticated bool, err error) {
+	if ws.auth == nil {
+		return false, nil
+	}
+	id := newRequestID()
+	probe, _, err := prepareRequest(pingQuery)
+	if err != nil {
+		return false, err
 	}
-	ws.conn, _, err = d.Dial(ws.host, http.Header{})
+	probe.RequestID = id
+	msg, err := ws.getSerializer().Serialize(probe)
 	if err != nil {
+		return false, err
+	}
+	timeout := ws.authTimeout
+	if timeout <= 0 { // Dialers not created by NewDialer
+		timeout = defaultAuthTimeout
+	}
+	// The exchange has a budget of its own, the handshake timeout only covers the WebSocket upgrade
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	ws.conn.SetWriteDeadline(deadline)
+	ws.conn.SetReadDeadline(deadline)
+	defer func() {
+		ws.conn.SetWriteDeadline(time.Time{})
+		ws.conn.SetReadDeadline(time.Time{})
+	}()
+
+	if err = ws.write(msg); err != nil {
+		return false, authError(err, "sending the authentication probe")
+	}
+	for { // Nothing else has been sent yet, but frames of other requests are skipped all the same
+		_, data, err := ws.conn.ReadMessage()
+		if err != nil {
+			return false, authError(err, "authenticating")
+		}
+		resp, err := ws.getSerializer().Deserialize(data)
+		if err != nil || resp.RequestID != id {
+			continue
+		}
+		switch {
+		case resp.Status.IsAuthChallenge():
+			if err = ws.sendCredentials(id); err != nil {
+				return false, err
+			}
+			authenticated = true
+		case resp.Status.Code == statusUnauthorized:
+			ws.getLogger().Error("Authentication failed", "host", ws.host, "code", resp.Status.Code, "message", resp.Status.Message)
+			ws.authFailed = true
+			return false, ErrAuthFailed
+		case resp.Status.IsPartial(): // The rest of the probe result follows
+		default: // Answered by a server which accepted the credentials, or did not ask for them
+			return authenticated, nil
+		}
+	}
+}
+
+// sendCredentials answers the authentication challenge of the request with the given id
+func (ws *Ws) sendCredentials(id string) error {
+	req, err := prepareAuthRequest(id, ws.auth.username, ws.auth.password)
+	if err != nil {
+		return err
+	}
+	msg, err := ws.getSerializer().Serialize(req)
+	if err != nil {
+		return err
+	}
+	return authError(ws.write(msg), "sending credentials")
+}
+
+// authError turns a failed write or read of the authentication exchange into ErrAuthTimeout when it ran out of time
+func authError(err error, msg string) error {
+	if netErr, ok := errors.Cause(err).(net.Error); ok && netErr.Timeout() {
+		return ErrAuthTimeout
+	}
+	return errors.Wrap(err, msg)
+}
+
+// GremlinPathOrder is the order in which a dialer tries the host as given and the host with /gremlin appended to its
+// path, the endpoint of Gremlin Server since 3.2.2. Hosts whose path already ends with /gremlin are dialed as given.
+type GremlinPathOrder int
+
+const (
+	// GremlinPathFallback dials the host as given and falls back to its /gremlin path, the default
+	GremlinPathFallback GremlinPathOrder = iota
+	// GremlinPathFirst dials the /gremlin path and falls back to the host as given
+	GremlinPathFirst
+	// GremlinPathOnly only dials the /gremlin path, for servers known to serve it
+	GremlinPathOnly
+	// GremlinPathNever only dials the host as given
+	GremlinPathNever
+)
+
+// dial dials the current host, trying it with and without the /gremlin path in the order of the dialer
+func (ws *Ws) dial(ctx context.Context, d *websocket.Dialer) (err error) {
+	if err = checkScheme(ws.host); err != nil {
+		return
+	}
+	for i, host := range ws.hostsToDial(ws.host) {
+		if i > 0 {
+			// As of 3.2.2 the URL has changed.
+			// https://groups.google.com/forum/#!msg/gremlin-users/x4hiHsmTsHM/Xe4GcPtRCAAJ
+			msg := "Retrying connection with /gremlin suffix"
+			if ws.gremlinPath == GremlinPathFirst {
+				msg = "Retrying connection without /gremlin suffix"
+			}
+			ws.getLogger().Info(msg, "host", host)
+		}
+		ws.host = host
+		if err = ws.dialConn(ctx, d, host); err == nil || ctx.Err() != nil {
+			return
+		}
+	}
+	return
+}
 
-		// As of 3.2.2 the URL has changed.
-		// https://groups.google.com/forum/#!msg/gremlin-users/x4hiHsmTsHM/Xe4GcPtRCAAJ
-		ws.host = ws.host + "/gremlin"
-		ws.conn, _, err = d.Dial(ws.host, http.Header{})
+// hostsToDial returns the hosts to try in order to connect to host
+func (ws *Ws) hostsToDial(host string) []string {
+	suffixed, ok := withGremlinPath(host)
+	if !ok {
+		return []string{host}
+	}
+	switch ws.gremlinPath {
+	case GremlinPathFirst:
+		return []string{suffixed, host}
+	case GremlinPathOnly:
+		return []string{suffixed}
+	case GremlinPathNever:
+		return []string{host}
+	default:
+		return []string{host, suffixed}
 	}
+}
+
+// dialConn dials the host and makes the new connection the current one, under the write lock so that a ping loop
+// of the previous connection still running, or a close racing the reconnect, never sees it half set
+func (ws *Ws) dialConn(ctx context.Context, d *websocket.Dialer, host string) error {
+	conn, err := ws.dialHost(ctx, d, host)
+	ws.writeMu.Lock()
+	ws.conn = conn
+	ws.readClosed = make(chan struct{})
+	ws.writeMu.Unlock()
+	return err
+}
 
+// dialHost makes a single attempt to dial the host, running the dial hooks around it
+func (ws *Ws) dialHost(ctx context.Context, d *websocket.Dialer, host string) (conn *websocket.Conn, err error) {
+	if ws.preDial != nil {
+		err = ws.preDial(host)
+	}
 	if err == nil {
-		ws.connected = true
-		ws.conn.SetPongHandler(func(appData string) error {
-			ws.connected = true
-			return nil
-		})
+		var resp *http.Response
+		cancellable, stop := closeOnDone(ctx, d)
+		conn, resp, err = cancellable.DialContext(ctx, host, ws.handshakeHeader())
+		if !stop() && err == nil { // Cancelled as the handshake completed
+			conn.Close()
+			conn, err = nil, ctx.Err()
+		}
+		if err == nil {
+			ws.setExtensions(resp.Header)
+		}
+	}
+	if ws.postDial != nil {
+		ws.postDial(host, conn, err)
 	}
 	return
 }
 
+// handshakeHeader returns the headers of the handshake request
+func (ws *Ws) handshakeHeader() http.Header {
+	header := http.Header{}
+	if ws.userAgent != "" {
+		header.Set("User-Agent", ws.userAgent)
+	}
+	return header
+}
+
+// closeOnDone returns a copy of d whose connections are closed when ctx is done, as the handshake itself only ends
+// with its timeout or the deadline of ctx. stop stops watching ctx once the handshake is over, it returns false
+// when the connection has been closed.
+func closeOnDone(ctx context.Context, d *websocket.Dialer) (cancellable *websocket.Dialer, stop func() bool) {
+	netDial := d.NetDialContext
+	if netDial == nil {
+		netDial = (&net.Dialer{}).DialContext
+	}
+	stopWatching := func() bool { return true }
+	copied := *d
+	copied.NetDialContext = func(dialCtx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := netDial(dialCtx, network, addr)
+		if err == nil {
+			stopWatching = context.AfterFunc(ctx, func() { conn.Close() })
+		}
+		return conn, err
+	}
+	return &copied, func() bool { return stopWatching() }
+}
+
+// failoverHosts returns pointers to the alternate hosts, so that connect can update them
+func (ws *Ws) failoverHosts() []*string {
+	hosts := make([]*string, len(ws.failover))
+	for i := range ws.failover {
+		hosts[i] = &ws.failover[i]
+	}
+	return hosts
+}
+
+// checkScheme fails with an *InvalidSchemeError for hosts whose scheme is neither ws nor wss
+func checkScheme(host string) error {
+	scheme := ""
+	if i := strings.Index(host, "://"); i >= 0 {
+		scheme = strings.ToLower(host[:i])
+	}
+	if scheme != "ws" && scheme != "wss" {
+		return &InvalidSchemeError{Scheme: scheme}
+	}
+	return nil
+}
+
+// withDefaultScheme prefixes a host without a scheme, such as localhost:8182, with ws://
+func withDefaultScheme(host string) (string, bool) {
+	if strings.Contains(host, "://") {
+		return host, false
+	}
+	return "ws://" + host, true
+}
+
+// normalizeHost parses the host URL and brackets a bare IPv6 address, so ws://::1:8182 becomes ws://[::1]:8182.
+// Hosts which cannot be normalized are returned unchanged and fail when dialed.
+func normalizeHost(host string) string {
+	i := strings.Index(host, "://")
+	if i < 0 {
+		return host
+	}
+	scheme, rest := host[:i+3], host[i+3:]
+	authority, tail := rest, ""
+	if j := strings.IndexAny(rest, "/?#"); j >= 0 {
+		authority, tail = rest[:j], rest[j:]
+	}
+
+	if strings.Count(authority, ":") > 1 && !strings.HasPrefix(authority, "[") {
+		if j := strings.LastIndex(authority, ":"); net.ParseIP(authority[:j]) != nil && isPort(authority[j+1:]) {
+			authority = "[" + authority[:j] + "]" + authority[j:]
+		} else if net.ParseIP(authority) != nil {
+			authority = "[" + authority + "]"
+		}
+	}
+
+	normalized := scheme + authority + tail
+	if _, err := url.Parse(normalized); err != nil {
+		return host
+	}
+	return normalized
+}
+
+func isPort(s string) bool {
+	n, err := strconv.Atoi(s)
+	return err == nil && n > 0 && n <= 65535
+}
+
+// withGremlinPath returns the host with /gremlin appended to its URL path, unless the path already ends with it
+func withGremlinPath(host string) (string, bool) {
+	u, err := url.Parse(host)
+	if err != nil || strings.HasSuffix(u.Path, "/gremlin") {
+		return host, false
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/gremlin"
+	return u.String(), true
+}
+
+// reconnect closes the current connection, if still open, and dials the host again with a fresh quit channel
+// so that the connection can be reused after it has been disposed.
+func (ws *Ws) reconnect() (err error) {
+	if ws.authFailed && ws.credentials == nil { // A provider may hand out credentials which are accepted again
+		return ErrAuthFailed
+	}
+	if !ws.IsDisposed() && ws.conn != nil {
+		ws.close() // Stops the workers and ping loop bound to the old quit channel
+	}
+	ws.Lock()
+	ws.quit = make(chan struct{})
+	ws.quitOnce = sync.Once{}
+	ws.Unlock()
+	ws.setDisposed(false)
+	return ws.connect()
+}
+
+// quitChan returns the channel closed once the current connection is closed, which the workers of the connection
+// select on. Reconnects replace it, while the workers of the old connection may still be running.
+func (ws *Ws) quitChan() chan struct{} {
+	ws.RLock()
+	defer ws.RUnlock()
+	return ws.quit
+}
+
+// ActualHost returns the host the connection was established with, which includes the /gremlin
+// suffix when the configured host had to fall back to it.
+func (ws *Ws) ActualHost() string {
+	return ws.host
+}
+
+// Subprotocol returns the subprotocol negotiated with the server, which is empty when none was negotiated
+func (ws *Ws) Subprotocol() string {
+	if ws.conn == nil {
+		return ""
+	}
+	return ws.conn.Subprotocol()
+}
+
+// setExtensions keeps the extensions of the handshake response
+func (ws *Ws) setExtensions(header http.Header) {
+	var extensions []string
+	for _, value := range header["Sec-Websocket-Extensions"] {
+		for _, ext := range strings.Split(value, ",") {
+			if ext = strings.TrimSpace(ext); ext != "" {
+				extensions = append(extensions, ext)
+			}
+		}
+	}
+	ws.Lock()
+	ws.extensions = extensions
+	ws.Unlock()
+}
+
+// Extensions returns the WebSocket extensions negotiated with the server in the last handshake, with their
+// parameters, such as "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+func (ws *Ws) Extensions() []string {
+	ws.RLock()
+	defer ws.RUnlock()
+	return append([]string(nil), ws.extensions...)
+}
+
+// CompressionNegotiated reports whether the server accepted per message compression in the last handshake. Without
+// it frames are sent uncompressed even when SetCompression is configured.
+func (ws *Ws) CompressionNegotiated() bool {
+	for _, ext := range ws.Extensions() {
+		if strings.HasPrefix(ext, "permessage-deflate") {
+			return true
+		}
+	}
+	return false
+}
+
 // IsConnected returns whether the underlying websocket is connected
 func (ws *Ws) IsConnected() bool {
+	ws.RLock()
+	defer ws.RUnlock()
 	return ws.connected
 }
 
+// String describes the dialer for logs and debugging, such as Ws{host:"wss://localhost:8182", connected:true,
+// disposed:false}
+func (ws *Ws) String() string {
+	return fmt.Sprintf("Ws{host:%q, connected:%t, disposed:%t}", ws.ActualHost(), ws.IsConnected(), ws.IsDisposed())
+}
+
+// setConnected records the connection state and wakes up anyone waiting for the connection
+func (ws *Ws) setConnected(connected bool) {
+	ws.Lock()
+	ws.connected = connected
+	if connected && ws.stateChanged != nil {
+		ws.stateChanged.Broadcast()
+	}
+	ws.Unlock()
+}
+
+// waitForConnection blocks until the websocket is connected or the context is done
+func (ws *Ws) waitForConnection(ctx context.Context) error {
+	ws.Lock()
+	defer ws.Unlock()
+	if ws.stateChanged == nil {
+		ws.stateChanged = sync.NewCond(ws)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() { // Wake up the waiter when the context is done
+		select {
+		case <-ctx.Done():
+			ws.Lock()
+			ws.stateChanged.Broadcast()
+			ws.Unlock()
+		case <-stop:
+		}
+	}()
+
+	for !ws.connected {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		ws.stateChanged.Wait()
+	}
+	return nil
+}
+
 // IsDisposed returns whether the underlying websocket is disposed
 func (ws *Ws) IsDisposed() bool {
+	ws.RLock()
+	defer ws.RUnlock()
 	return ws.disposed
 }
 
+func (ws *Ws) setDisposed(disposed bool) {
+	ws.Lock()
+	ws.disposed = disposed
+	ws.Unlock()
+}
+
+// write writes a message under writeMu. Every write to the connection takes writeMu, control frames included, so
+// that the write worker, the ping loop, close and the authentication of a new connection never write at once.
 func (ws *Ws) write(msg []byte) (err error) {
+	ws.writeMu.Lock() // The connection supports a single writer, clients and their reconnect hooks share it
+	defer ws.writeMu.Unlock()
+	if ws.compression > 0 { // Only takes effect when the server negotiated compression
+		ws.conn.EnableWriteCompression(len(msg) >= ws.compression)
+	}
 	err = ws.conn.WriteMessage(2, msg)
 	return
 }
 
+// writeControl writes a control frame, such as a ping or a close frame, under the write lock like write
+func (ws *Ws) writeControl(messageType int, data []byte) error {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+	return ws.conn.WriteControl(messageType, data, time.Now().Add(ws.writingWait))
+}
+
+// errClosedByClient is returned by read once the connection has been closed on purpose
+var errClosedByClient = errors.New("connection closed by the client")
+
 func (ws *Ws) read() (msgType int, msg []byte, err error) {
 	msgType, msg, err = ws.conn.ReadMessage()
-	return
+	return msgType, msg, ws.readFailed(err)
+}
+
+// readInto reads the next frame into buf instead of allocating a new slice for it
+func (ws *Ws) readInto(buf *bytes.Buffer) (msgType int, err error) {
+	msgType, r, err := ws.conn.NextReader()
+	if err == nil {
+		_, err = buf.ReadFrom(r)
+	}
+	return msgType, ws.readFailed(err)
+}
+
+// readLimited reads the next frame into buf up to max bytes and a byte more, the rest of a larger frame is discarded
+func (ws *Ws) readLimited(buf *bytes.Buffer, max int64) (msgType int, size int64, err error) {
+	msgType, r, err := ws.conn.NextReader()
+	if err == nil {
+		size, err = io.CopyN(buf, r, max+1)
+		if err == io.EOF {
+			err = nil
+		} else if err == nil {
+			var rest int64
+			rest, err = io.Copy(io.Discard, r)
+			size += rest
+		}
+	}
+	return msgType, size, ws.readFailed(err)
+}
+
+// setReadLimit limits the size of the frames read from the current connection, a larger frame drops it
+func (ws *Ws) setReadLimit(limit int64) {
+	if ws.conn != nil {
+		ws.conn.SetReadLimit(limit)
+	}
+}
+
+// readFailed notes a failed read, so that close does not wait for the read any longer
+func (ws *Ws) readFailed(err error) error {
+	if err != nil && ws.IsDisposed() { // Checked before readClosed is closed, while close still waits for it
+		err = errClosedByClient
+	}
+	if err != nil && ws.readClosed != nil {
+		select {
+		case <-ws.readClosed:
+		default:
+			close(ws.readClosed)
+		}
+	}
+	return err
 }
 
 func (ws *Ws) close() (err error) {
+	ws.setDisposed(true) // Disposed right away, so that reading knows the connection ends on purpose
+	ws.writeMu.Lock()
+	conn, readClosed := ws.conn, ws.readClosed // A reconnect may be dialing a new connection meanwhile
+	ws.writeMu.Unlock()
 	defer func() {
-		close(ws.quit)
-		ws.conn.Close()
-		ws.disposed = true
+		if quit := ws.quitChan(); quit != nil {
+			ws.quitOnce.Do(func() { close(quit) })
+		}
+		if conn != nil {
+			conn.Close()
+		}
+		ws.setConnected(false)
 	}()
+	if conn == nil {
+		return
+	}
+
+	// Cleanly close the connection with the server, bounded by writingWait so a dead peer cannot hang the close
+	err = ws.writeControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	if err != nil {
+		return
+	}
 
-	err = ws.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")) //Cleanly close the connection with the server
+	// Give the server up to closeTimeout to echo the close frame, so it does not see a broken pipe.
+	// The read worker owns reads on the connection, the deadline makes sure it gives up in time.
+	conn.SetReadDeadline(time.Now().Add(ws.closeTimeout))
+	select {
+	case <-readClosed:
+	case <-orRealClock(ws.clock).After(ws.closeTimeout):
+	}
 	return
 }
 
-func (ws *Ws) getAuth() *auth {
+func (ws *Ws) getAuth() (*auth, error) {
 	if ws.auth == nil {
-		panic("You must create a Secure Dialer for authenticate with the server")
+		return nil, ErrNotAuthenticated
+	}
+	return ws.auth, nil
+}
+
+// setCredentials sets the credentials the dialer authenticates with, empty credentials clear them. New credentials
+// are tried again after the previous ones were rejected.
+func (ws *Ws) setCredentials(username, password string) {
+	ws.authFailed = false
+	if username == "" && password == "" {
+		ws.auth = nil
+		return
 	}
-	return ws.auth
+	ws.auth = &auth{username: username, password: password}
+}
+
+func (ws *Ws) getSerializer() Serializer {
+	if ws.serializer == nil {
+		return GraphSONSerializer{}
+	}
+	return ws.serializer
+}
+
+func (ws *Ws) getLogger() Logger {
+	if ws.logger == nil {
+		return stdLogger{}
+	}
+	return ws.logger
+}
+
+// pingFailureCount returns the number of pings which could not be written, over all the connections of the dialer
+func (ws *Ws) pingFailureCount() int64 {
+	return atomic.LoadInt64(&ws.pingFailures)
 }
 
 func (ws *Ws) ping(errs chan error) {
-	ticker := time.NewTicker(ws.pingInterval)
+	quit := ws.quitChan() // Captured so that a reset connection does not keep an old ping loop alive
+	interval := ws.pingInterval
+	if interval <= 0 { // Dialers not created by NewDialer
+		interval = defaultPingInterval
+	}
+	ticker := orRealClock(ws.clock).NewTicker(interval)
 	defer ticker.Stop()
 	for {
 		select {
-		case <-ticker.C:
+		case <-ticker.C():
 			connected := true
-			if err := ws.conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(ws.writingWait)); err != nil {
+			if err := ws.writeControl(websocket.PingMessage, []byte{}); err != nil {
+				atomic.AddInt64(&ws.pingFailures, 1)
 				errs <- err
 				connected = false
 			}
-			ws.Lock()
-			ws.connected = connected
-			ws.Unlock()
+			ws.setConnected(connected)
 
-		case <-ws.quit:
+		case <-quit:
 			return
 		}
 	}
 }
 
 func (c *Client) writeWorker(errs chan error, quit chan struct{}) { // writeWorker works on a loop and dispatches messages as soon as it receives them
+	for held := true; held; {
+		select {
+		case msg := <-c.held: // Left over by the worker of the previous connection, written before any later request
+			if !c.writeRequest(errs, msg) {
+				return
+			}
+		default:
+			held = false
+		}
+	}
 	for {
 		select {
 		case msg := <-c.requests:
-			c.Lock()
-			err := c.conn.write(msg)
-			if err != nil {
-				errs <- err
-				c.Errored = true
-				c.Unlock()
-				break
+			c.backpressure.observe(len(c.requests))
+			if c.writeCoalescing > 0 {
+				if !c.writeBatch(errs, c.coalesce(msg, quit)) {
+					return
+				}
+				continue
+			}
+			if !c.writeRequest(errs, msg) {
+				return
 			}
-			c.Unlock()
 
 		case <-quit:
 			return
@@ -152,19 +799,99 @@ func (c *Client) writeWorker(errs chan error, quit chan struct{}) { // writeWork
 	}
 }
 
+// maxCoalescedRequests is the number of requests a coalesced batch is written at
+const maxCoalescedRequests = 64
+
+// coalesce gathers the requests queued within the coalescing window after msg, see SetWriteCoalescing
+func (c *Client) coalesce(msg []byte, quit chan struct{}) [][]byte {
+	window := c.writeCoalescing
+	if c.requestTimeout > 0 && c.requestTimeout < window { // Requests would time out while waiting to be written
+		window = c.requestTimeout
+	}
+	timer := orRealClock(c.clock).NewTimer(window)
+	defer timer.Stop()
+
+	batch := [][]byte{msg}
+	for len(batch) < maxCoalescedRequests {
+		select {
+		case msg := <-c.requests:
+			batch = append(batch, msg)
+		case <-timer.C():
+			return batch
+		case <-quit:
+			return batch
+		}
+	}
+	return batch
+}
+
+// writeBatch writes coalesced requests in order. When a write fails and requests outlive a reset, the rest of the
+// batch is held for the next connection along with the failed request.
+func (c *Client) writeBatch(errs chan error, batch [][]byte) bool {
+	for i, msg := range batch {
+		if !c.writeRequest(errs, msg) {
+			for _, rest := range batch[i+1:] {
+				c.held <- rest
+			}
+			return false
+		}
+	}
+	c.backpressure.observe(len(c.requests))
+	return true
+}
+
+// writeRequest writes a request to the connection. When the write fails and requests outlive a reset, the request
+// is held for the next connection and false is returned so that no later request overtakes it.
+func (c *Client) writeRequest(errs chan error, msg []byte) bool {
+	c.Lock()
+	err := c.conn.write(msg)
+	if err != nil {
+		errs <- &WorkerError{Worker: "write", RequestID: frameRequestID(msg), ID: frameRequestIDString(msg), Err: err}
+		c.stats.writeFailed()
+		c.Errored = true
+		c.Unlock()
+		if c.unsent != nil {
+			c.held <- msg
+			return false
+		}
+		c.outbound.take(1)
+		return true
+	}
+	c.Unlock()
+	c.outbound.take(1)
+	if c.unsent != nil {
+		c.unsent.Delete(frameRequestIDString(msg))
+	}
+	c.stats.written(len(msg))
+	c.observe(MetricRequestBytes, float64(len(msg)))
+	return true
+}
+
+// maxResponseOverhead is how much larger than the MaxResponseSize of the client a frame can be before it hits the
+// read limit of the connection, instead of only failing its request
+const maxResponseOverhead = 1 << 20
+
 func (c *Client) readWorker(errs chan error, quit chan struct{}) { // readWorker works on a loop and sorts messages as soon as it receives them
+	if ws, ok := c.conn.(*Ws); ok && c.MaxResponseSize > 0 {
+		ws.setReadLimit(c.MaxResponseSize + maxResponseOverhead)
+	}
+	handle, stop := c.startResponseHandlers()
+	defer stop()
 	for {
-		msgType, msg, err := c.conn.read()
+		msgType, msg, buf, size, err := c.readFrame()
 		if msgType == -1 { // msgType == -1 is noFrame (close connection)
+			c.buffers.put(buf)
+			c.connectionLost(errs, err)
 			return
 		}
-		if err != nil {
-			errs <- errors.Wrapf(err, "Receive message type: %d", msgType)
-			c.Errored = true
-			break
-		}
-		if msg != nil {
-			c.handleResponse(msg)
+		if c.MaxResponseSize > 0 && size > c.MaxResponseSize {
+			c.responseTooLarge(errs, msg, size)
+			c.buffers.put(buf)
+		} else if msg != nil {
+			c.observe(MetricResponseBytes, float64(len(msg)))
+			handle(msg, buf)
+		} else {
+			c.buffers.put(buf)
 		}
 
 		select {
@@ -175,3 +902,101 @@ func (c *Client) readWorker(errs chan error, quit chan struct{}) { // readWorker
 		}
 	}
 }
+
+// readFrame reads the next frame, into a buffer of the buffer pool when the client has one. The buffer is returned
+// along with the frame, to be put back into the pool once the frame has been handled. A frame which could not be
+// read to its end is reported as noFrame like any other read error, the connection cannot be read from after it.
+// With a MaxResponseSize, only the start of a larger frame is read, size is the size of the whole frame.
+func (c *Client) readFrame() (msgType int, msg []byte, buf *bytes.Buffer, size int64, err error) {
+	defer func() {
+		if err != nil {
+			msgType = -1
+		}
+	}()
+	if r, ok := c.conn.(limitedReader); ok && c.MaxResponseSize > 0 {
+		if buf = c.buffers.get(); buf == nil {
+			buf = new(bytes.Buffer)
+		}
+		msgType, size, err = r.readLimited(buf, c.MaxResponseSize)
+		if buf.Len() > 0 {
+			msg = buf.Bytes()
+		}
+		return
+	}
+	r, ok := c.conn.(bufferedReader)
+	if c.buffers == nil || !ok {
+		msgType, msg, err = c.conn.read()
+		return msgType, msg, buf, int64(len(msg)), err
+	}
+	buf = c.buffers.get()
+	msgType, err = r.readInto(buf)
+	if buf.Len() > 0 {
+		msg = buf.Bytes()
+	}
+	return msgType, msg, buf, int64(len(msg)), err
+}
+
+// responseTooLarge fails the request of a frame larger than the MaxResponseSize of the client with
+// ErrResponseTooLarge. The request is found by the request id at the start of the frame, when it is not there the
+// error is reported on the error channel instead.
+func (c *Client) responseTooLarge(errs chan error, start []byte, size int64) {
+	err := &ErrResponseTooLarge{Size: size, Max: c.MaxResponseSize}
+	id := responseRequestID(start)
+	if id == "" {
+		c.logger().Error("Skipped a response frame too large to be read", "size", size)
+		errs <- &WorkerError{Worker: "read", Err: err}
+		return
+	}
+	c.saveResponse(Response{RequestID: id}, err)
+}
+
+// responseRequestID reads the request id of a response frame from the start of the frame, without decoding it.
+// Gremlin Server writes the request id first.
+func responseRequestID(start []byte) string {
+	key := []byte(`"requestId"`)
+	i := bytes.Index(start, key)
+	if i < 0 {
+		return ""
+	}
+	rest := bytes.TrimLeft(start[i+len(key):], " \t\r\n")
+	if len(rest) == 0 || rest[0] != ':' {
+		return ""
+	}
+	rest = bytes.TrimLeft(rest[1:], " \t\r\n")
+	if len(rest) == 0 || rest[0] != '"' {
+		return ""
+	}
+	end := bytes.IndexByte(rest[1:], '"')
+	if end < 0 {
+		return ""
+	}
+	return string(rest[1 : end+1])
+}
+
+// connectionLost handles the end of the connection noticed by the read worker. A connection closed by the client
+// ends silently. A clean close by the server, such as when it shuts down, is logged and the client reconnects. An
+// abrupt drop is logged as an error and reported on the error channel.
+func (c *Client) connectionLost(errs chan error, err error) {
+	if err == nil || err == errClosedByClient {
+		return
+	}
+
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+		c.logger().Info("Connection closed by the server, reconnecting", "error", err)
+		c.goWorker(func() {
+			if err := c.Reset(); err != nil && err != ErrClientShutdown {
+				errs <- &WorkerError{Worker: "read", Err: errors.Wrap(err, "reconnecting after the server closed the connection")}
+			}
+		})
+		return
+	}
+
+	msg := "Connection read failed"
+	if websocket.IsUnexpectedCloseError(err) {
+		msg = "Connection dropped"
+	}
+	c.logger().Error(msg, "error", err)
+	c.stats.readFailed()
+	c.setErrored(true)
+	errs <- &WorkerError{Worker: "read", Err: errors.Wrap(err, "connection lost")}
+}
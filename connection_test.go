@@ -1,17 +1,1027 @@
 package gremtune
 
-import "testing"
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
 
-func TestPanicOnMissingAuthCredentials(t *testing.T) {
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+)
+
+// newTestServer starts a WebSocket server which keeps reading until the client goes away, answering pings and close
+// frames. Every message received is handed to onMessage when it is given.
+func newTestServer(t *testing.T, onMessage ...func(conn *websocket.Conn, msg []byte)) *httptest.Server {
+	return httptest.NewServer(testServerHandler(t, onMessage...))
+}
+
+// testServerHandler is the handler of newTestServer
+func testServerHandler(t *testing.T, onMessage ...func(conn *websocket.Conn, msg []byte)) http.Handler {
+	upgrader := websocket.Upgrader{EnableCompression: true}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			for _, handle := range onMessage {
+				handle(conn, msg)
+			}
+		}
+	})
+}
+
+func testServerHost(s *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(s.URL, "http")
+}
+
+// authServerHandler authenticates connections like Gremlin Server: the first request of a connection is answered
+// with a challenge, and once the credentials sent in answer are accepted, the request itself is answered. Only the
+// password "pass" is accepted.
+func authServerHandler(t *testing.T, dials *int32) http.Handler {
+	var authenticated sync.Map
+	handler := testServerHandler(t, func(conn *websocket.Conn, msg []byte) {
+		var req Request
+		json.Unmarshal(msg[msg[0]+1:], &req)
+		code := statusSuccess
+		if _, ok := authenticated.Load(conn); !ok {
+			code = statusAuthenticate
+			if sasl, _ := req.Args["sasl"].(string); req.Op == "authentication" {
+				code = statusUnauthorized
+				if sasl == base64.StdEncoding.EncodeToString([]byte("\x00user\x00pass")) {
+					authenticated.Store(conn, true)
+					code = statusSuccess
+				}
+			}
+		}
+		conn.WriteMessage(websocket.BinaryMessage, []byte(fmt.Sprintf(`{"requestId":"%s","status":{"code":%d}}`, req.RequestID, code)))
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(dials, 1)
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func TestConnectAuthenticates(t *testing.T) {
+	var dials int32
+	s := httptest.NewServer(authServerHandler(t, &dials))
+	defer s.Close()
+
+	ws := NewSecureDialer(testServerHost(s), "user", "pass")
+	if err := ws.connect(); err != nil {
+		t.Fatal(err)
+	}
+	ws.close()
+}
+
+// TestConnectAuthenticatesWithClientSerializer tests that the authentication exchange is serialized by the client
+func TestConnectAuthenticatesWithClientSerializer(t *testing.T) {
+	var dials int32
+	s := httptest.NewServer(authServerHandler(t, &dials))
+	defer s.Close()
+
+	serializer := &recordingSerializer{}
+	c, err := Dial(NewSecureDialer(testServerHost(s), "user", "pass"), make(chan error, 1), SetSerializer(serializer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if len(serializer.args) != 2 || serializer.args[1]["sasl"] == nil {
+		t.Errorf("Expected the probe and the credentials to be serialized by the client, got %v", serializer.args)
+	}
+}
+
+func TestConnectWithoutChallenge(t *testing.T) {
+	s := newTestServer(t, func(conn *websocket.Conn, msg []byte) {
+		var req Request
+		json.Unmarshal(msg[msg[0]+1:], &req)
+		if req.Op == "authentication" {
+			t.Error("Expected no credentials to be sent to a server not asking for them")
+		}
+		conn.WriteMessage(websocket.BinaryMessage, fakeSuccess(req.RequestID))
+	})
+	defer s.Close()
+
+	ws := NewSecureDialer(testServerHost(s), "user", "pass")
+	if err := ws.connect(); err != nil {
+		t.Fatal(err)
+	}
+	ws.close()
+}
+
+func TestReconnectStopsAfterAuthFailed(t *testing.T) {
+	var dials int32
+	s := httptest.NewServer(authServerHandler(t, &dials))
+	defer s.Close()
+
+	ws := NewSecureDialer(testServerHost(s), "user", "wrong")
+	if err := ws.connect(); err != ErrAuthFailed {
+		t.Fatalf("Expected ErrAuthFailed, got %v", err)
+	}
+	if err := ws.reconnect(); err != ErrAuthFailed {
+		t.Errorf("Expected the reconnect to fail with ErrAuthFailed, got %v", err)
+	}
+	if n := atomic.LoadInt32(&dials); n != 1 {
+		t.Errorf("Expected no reconnect with the rejected credentials, got %d dials", n)
+	}
+
+	SetCredentials("user", "pass")(ws)
+	if err := ws.reconnect(); err != nil {
+		t.Errorf("Expected the reconnect to succeed with new credentials, got %v", err)
+	}
+	ws.close()
+}
+
+func TestReconnectRefreshesCredentials(t *testing.T) {
+	var dials int32
+	s := httptest.NewServer(authServerHandler(t, &dials))
+	defer s.Close()
+
+	passwords := []string{"expired", "pass", "pass"}
+	var fetched int
+	provider := func(ctx context.Context) (string, string, error) {
+		password := passwords[fetched]
+		fetched++
+		return "user", password, nil
+	}
+	var events []ConnectEvent
+	ws := NewDialer(testServerHost(s), SetCredentialProvider(provider), SetConnectListener(func(event ConnectEvent) {
+		events = append(events, event)
+	}))
+	if err := ws.connect(); err != ErrAuthFailed {
+		t.Fatalf("Expected the expired token to be rejected, got %v", err)
+	}
+	if err := ws.reconnect(); err != nil {
+		t.Fatalf("Expected the reconnect to fetch a fresh token, got %v", err)
+	}
+	if err := ws.reconnect(); err != nil {
+		t.Fatal(err)
+	}
+	ws.close()
+
+	if fetched != 3 {
+		t.Errorf("Expected the credentials to be fetched for every connect, fetched %d times", fetched)
+	}
+	expected := []ConnectEvent{
+		{Host: ws.host, CredentialsRefreshed: true, Authenticated: true},
+		{Host: ws.host, Reconnect: true, CredentialsRefreshed: true, Authenticated: true},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected the events %+v, got %+v", expected, events)
+	}
+}
+
+func TestCredentialProviderError(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	fetchErr := errors.New("token service unavailable")
+	ws := NewDialer(testServerHost(s), SetCredentialProvider(func(ctx context.Context) (string, string, error) {
+		return "", "", fetchErr
+	}))
+	if err := ws.connect(); errors.Cause(err) != fetchErr {
+		t.Errorf("Expected the connect to fail with the error of the provider, got %v", err)
+	}
+}
+
+func TestAuthTimeout(t *testing.T) {
+	s := newTestServer(t) // Never answers the authentication request
+	defer s.Close()
+
+	ws := NewSecureDialer(testServerHost(s), "user", "pass", SetAuthTimeout(20*time.Millisecond))
+	start := time.Now()
+	if err := ws.connect(); err != ErrAuthTimeout {
+		t.Fatalf("Expected ErrAuthTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected connect to give up after the auth timeout, took %s", elapsed)
+	}
+}
+
+func TestMissingAuthCredentials(t *testing.T) {
 	c := newClient()
 	ws := new(Ws)
 	c.conn = ws
 
-	defer func() {
-		if r := recover(); r == nil {
-			t.Fail()
+	if _, err := c.conn.getAuth(); err != ErrNotAuthenticated {
+		t.Errorf("Expected ErrNotAuthenticated, got %v", err)
+	}
+	if err := c.authenticate("1"); err != ErrNotAuthenticated {
+		t.Errorf("Expected authenticate to fail with ErrNotAuthenticated, got %v", err)
+	}
+}
+
+func TestNewDialer(t *testing.T) {
+	ws := NewDialer("127.0.0.1")
+	if ws.timeout != defaultTimeout || ws.pingInterval != defaultPingInterval || ws.writingWait != defaultWritingWait || ws.readingWait != defaultReadingWait {
+		t.Errorf("Expected the default settings, got %+v", ws)
+	}
+	if ws.auth != nil || ws.quit == nil {
+		t.Errorf("Expected an unauthenticated dialer with a quit channel, got %+v", ws)
+	}
+}
+
+func TestPingWithoutInterval(t *testing.T) {
+	quit := make(chan struct{})
+	close(quit)
+	ws := &Ws{quit: quit}
+	ws.ping(make(chan error, 1)) // Returns rather than panicking on a zero ticker interval
+}
+
+func TestParseDialer(t *testing.T) {
+	for _, host := range []string{"http://127.0.0.1:8182", "HTTPS://127.0.0.1:8182"} {
+		_, err := ParseDialer(host)
+		if schemeErr, ok := err.(*InvalidSchemeError); !ok || !strings.Contains(err.Error(), "use ws:// or wss://") {
+			t.Errorf("%s: expected an InvalidSchemeError, got %v", host, err)
+		} else if schemeErr.Scheme != strings.ToLower(host[:strings.Index(host, ":")]) {
+			t.Errorf("%s: unexpected scheme %q", host, schemeErr.Scheme)
+		}
+	}
+
+	for _, host := range []string{"ws://127.0.0.1:8182", "WSS://127.0.0.1:8182/gremlin"} {
+		if _, err := ParseDialer(host); err != nil {
+			t.Errorf("%s: unexpected error %v", host, err)
+		}
+	}
+}
+
+func TestNewDialerWithoutScheme(t *testing.T) {
+	logger := &recordingLogger{}
+	ws := NewDialer("localhost:8182", SetLogger(logger))
+	if ws.host != "ws://localhost:8182" {
+		t.Errorf("Expected the ws scheme to be assumed, got %s", ws.host)
+	}
+	if len(logger.infos) != 1 || logger.infos[0] != "Hosts without a scheme are deprecated, assuming ws://" {
+		t.Errorf("Expected a deprecation warning to be logged, got %v", logger.infos)
+	}
+}
+
+func TestConnectInvalidScheme(t *testing.T) {
+	ws := NewDialer("http://127.0.0.1:8182")
+	if err := ws.connect(); err == nil {
+		t.Fatal("Expected connecting to a http host to fail")
+	} else if _, ok := err.(*InvalidSchemeError); !ok {
+		t.Errorf("Expected an InvalidSchemeError, got %v", err)
+	}
+}
+
+func TestNewSecureDialer(t *testing.T) {
+	ws := NewSecureDialer("127.0.0.1", "user", "pass", SetTimeout(1))
+	if a, err := ws.getAuth(); err != nil || a.username != "user" || a.password != "pass" {
+		t.Errorf("Expected the credentials to be set, got %+v, %v", a, err)
+	}
+	if ws.pingInterval != 60*time.Second || ws.writingWait != 15*time.Second || ws.readingWait != 15*time.Second {
+		t.Errorf("Expected the defaults of NewDialer, got %+v", ws)
+	}
+	if ws.timeout != time.Second || ws.quit == nil {
+		t.Errorf("Expected the configs to apply and a quit channel, got %+v", ws)
+	}
+}
+
+func TestSetCredentials(t *testing.T) {
+	ws := NewDialer("127.0.0.1", SetCredentials("user", "pass"))
+	if a, err := ws.getAuth(); err != nil || a.username != "user" || a.password != "pass" {
+		t.Errorf("Expected the credentials to be set, got %+v, %v", a, err)
+	}
+
+	SetCredentials("", "")(ws)
+	if _, err := ws.getAuth(); err != ErrNotAuthenticated {
+		t.Errorf("Expected empty credentials to clear authentication, got %v", err)
+	}
+
+	c := newClient()
+	c.conn = ws
+	SetClientCredentials("other", "secret")(&c)
+	if a, err := ws.getAuth(); err != nil || a.username != "other" {
+		t.Errorf("Expected the client option to set the credentials of its dialer, got %+v, %v", a, err)
+	}
+}
+
+func TestReconnectRenewsDisposedConnection(t *testing.T) {
+	quit := make(chan struct{})
+	close(quit)
+	ws := &Ws{host: "ws://127.0.0.1:1", disposed: true, quit: quit}
+
+	if err := ws.reconnect(); err == nil {
+		t.Error("Expected reconnect to fail when the server cannot be reached")
+	}
+
+	if ws.IsDisposed() {
+		t.Error("Expected reconnect to clear the disposed state")
+	}
+
+	select {
+	case <-ws.quit:
+		t.Error("Expected reconnect to create a new quit channel")
+	default:
+	}
+}
+
+func TestCloseWaitsForServerClose(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	ws := NewDialer(testServerHost(s), SetCloseTimeout(5))
+	if err := ws.connect(); err != nil {
+		t.Fatal(err)
+	}
+	go func() { // Simulate the read worker
+		for {
+			if _, _, err := ws.read(); err != nil {
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	if err := ws.close(); err != nil {
+		t.Error(err)
+	}
+
+	if time.Since(start) >= 5*time.Second {
+		t.Error("Expected close to return once the server acknowledged the close")
+	}
+
+	if !ws.IsDisposed() {
+		t.Error("Expected connection to be disposed after close")
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+	ws := &Ws{quit: make(chan struct{})}
+	if err := ws.close(); err != nil {
+		t.Error(err)
+	}
+	if err := ws.close(); err != nil {
+		t.Error(err)
+	}
+	select {
+	case <-ws.quit:
+	default:
+		t.Error("Expected the quit channel to be closed")
+	}
+}
+
+var hosts = []struct {
+	host     string
+	expected string
+}{
+	{"ws://127.0.0.1:8182", "ws://127.0.0.1:8182"},
+	{"ws://::1:8182", "ws://[::1]:8182"},
+	{"ws://::1", "ws://[::1]"},
+	{"wss://fe80::1:8182/gremlin", "wss://[fe80::1]:8182/gremlin"},
+	{"ws://[::1]:8182", "ws://[::1]:8182"},
+	{"ws://localhost:8182/gremlin", "ws://localhost:8182/gremlin"},
+}
+
+func TestNormalizeHost(t *testing.T) {
+	for _, h := range hosts {
+		if got := normalizeHost(h.host); got != h.expected {
+			t.Errorf("Unexpected host for %s, expected: %s got: %s", h.host, h.expected, got)
+		}
+	}
+}
+
+func TestWithGremlinPath(t *testing.T) {
+	host, ok := withGremlinPath("ws://[::1]:8182")
+	if !ok || host != "ws://[::1]:8182/gremlin" {
+		t.Errorf("Expected /gremlin to be appended, got: %s", host)
+	}
+
+	if _, ok := withGremlinPath(host); ok {
+		t.Error("Expected /gremlin not to be appended twice")
+	}
+}
+
+func TestWaitForConnection(t *testing.T) {
+	ws := &Ws{connected: true}
+	if err := ws.waitForConnection(context.Background()); err != nil {
+		t.Errorf("Expected no wait on a connected websocket, got: %v", err)
+	}
+
+	ws = &Ws{}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		ws.setConnected(true)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := ws.waitForConnection(ctx); err != nil {
+		t.Errorf("Expected wait to end once connected, got: %v", err)
+	}
+}
+
+func TestWaitForConnectionCancelled(t *testing.T) {
+	ws := &Ws{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := ws.waitForConnection(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got: %v", err)
+	}
+}
+
+func TestWriteCompressesLargeFrames(t *testing.T) {
+	received := make(chan []byte, 2)
+	s := newTestServer(t, func(conn *websocket.Conn, msg []byte) { received <- msg })
+	defer s.Close()
+
+	ws := NewDialer(testServerHost(s), SetCompression(64))
+	if err := ws.connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer ws.conn.Close()
+
+	large := []byte(strings.Repeat("g.V().has('name', 'marko');", 100))
+	for _, msg := range [][]byte{[]byte("g.V()"), large} {
+		if err := ws.write(msg); err != nil {
+			t.Fatal(err)
+		}
+		if got := <-received; string(got) != string(msg) {
+			t.Error("Expected the server to receive the frame unchanged")
+		}
+	}
+}
+
+func TestCompressionNegotiation(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	ws := NewDialer(testServerHost(s), SetCompression(64))
+	if err := ws.connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer ws.conn.Close()
+	if !ws.CompressionNegotiated() || len(ws.Extensions()) != 1 {
+		t.Errorf("Expected permessage-deflate to be negotiated, got %v", ws.Extensions())
+	}
+
+	// A server without compression accepts the connection, which is logged
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		conn.ReadMessage()
+	}))
+	defer plain.Close()
+
+	logger := &recordingLogger{}
+	ws = NewDialer(testServerHost(plain), SetCompression(64), SetLogger(logger))
+	if err := ws.connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer ws.conn.Close()
+	if ws.CompressionNegotiated() || len(ws.Extensions()) != 0 {
+		t.Errorf("Expected no extension to be negotiated, got %v", ws.Extensions())
+	}
+	if len(logger.infos) != 1 || !strings.Contains(logger.infos[0], "not negotiated") {
+		t.Errorf("Expected the missing compression to be logged, got %v", logger.infos)
+	}
+}
+
+func TestSubprotocolNegotiation(t *testing.T) {
+	upgrader := websocket.Upgrader{Subprotocols: []string{"gremlin"}}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		conn.ReadMessage()
+	}))
+	defer s.Close()
+
+	ws := NewDialer(testServerHost(s), SetSubprotocols("graphson", "gremlin"))
+	if err := ws.connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer ws.conn.Close()
+
+	if ws.Subprotocol() != "gremlin" {
+		t.Errorf("Expected the gremlin subprotocol to be negotiated, got %q", ws.Subprotocol())
+	}
+}
+
+func TestConnectUsesNetDialer(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	used := false
+	d := &net.Dialer{KeepAlive: 30 * time.Second, Control: func(network, address string, c syscall.RawConn) error {
+		used = true
+		return nil
+	}}
+	ws := NewDialer(testServerHost(s), SetNetDialer(d))
+	if err := ws.connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer ws.conn.Close()
+
+	if !used {
+		t.Error("Expected the connection to be opened by the configured dialer")
+	}
+}
+
+func TestDialHooks(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	var calls []string
+	ws := NewDialer(testServerHost(s),
+		SetPreDialHook(func(host string) error {
+			calls = append(calls, "pre "+host)
+			return nil
+		}),
+		SetPostDialHook(func(host string, conn *websocket.Conn, err error) {
+			calls = append(calls, "post "+host)
+			if conn == nil || err != nil {
+				t.Errorf("Expected the connection to succeed, got %v", err)
+			}
+		}))
+	if err := ws.connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer ws.conn.Close()
+
+	host := testServerHost(s)
+	if len(calls) != 2 || calls[0] != "pre "+host || calls[1] != "post "+host {
+		t.Errorf("Expected both hooks to be called around the dial, got %v", calls)
+	}
+}
+
+func TestPreDialHookAbortsDial(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	denied := errors.New("no permit")
+	var dialErrs []error
+	ws := NewDialer(testServerHost(s),
+		SetPreDialHook(func(host string) error { return denied }),
+		SetPostDialHook(func(host string, conn *websocket.Conn, err error) { dialErrs = append(dialErrs, err) }))
+	if err := ws.connect(); err != denied {
+		t.Errorf("Expected the hook to abort the dial, got %v", err)
+	}
+	if len(dialErrs) != 2 || dialErrs[0] != denied { // Also aborts the retry with the /gremlin path
+		t.Errorf("Expected the post dial hook to see every aborted attempt, got %v", dialErrs)
+	}
+}
+
+// startTestClient connects a client over the dialer and starts its workers like Dial, without copying the client
+func startTestClient(t *testing.T, ws *Ws, errs chan error, configs ...ClientConfig) *Client {
+	c := newClient()
+	c.conn = ws
+	c.errs = errs
+	for _, conf := range configs {
+		conf(&c)
+	}
+	if err := ws.connect(); err != nil {
+		t.Fatal(err)
+	}
+	quit := ws.quit
+	c.goWorker(func() { c.writeWorker(errs, quit) })
+	c.goWorker(func() { c.readWorker(errs, quit) })
+	return &c
+}
+
+// recordingLogger is a Logger remembering the messages it was given
+type recordingLogger struct {
+	mu     sync.Mutex
+	infos  []string
+	errors []string
+}
+
+func (l *recordingLogger) Info(msg string, keyvals ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.infos = append(l.infos, msg)
+}
+
+func (l *recordingLogger) Error(msg string, keyvals ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.errors = append(l.errors, msg)
+}
+
+func TestServerCloseReconnects(t *testing.T) {
+	connections := make(chan int, 2)
+	n := 0
+	upgrader := websocket.Upgrader{}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		n++
+		connections <- n
+		if n == 1 { // Shut the first connection down cleanly
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	logger := &recordingLogger{}
+	c := startTestClient(t, NewDialer(testServerHost(s), SetLogger(logger), SetCloseTimeout(1)), make(chan error, 1))
+	defer c.Close()
+
+	for want := 1; want <= 2; want++ {
+		select {
+		case <-connections:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected connection %d to be made", want)
+		}
+	}
+
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if len(logger.infos) == 0 || len(logger.errors) != 0 {
+		t.Errorf("Expected the clean close to be logged as info, got infos %v errors %v", logger.infos, logger.errors)
+	}
+}
+
+func TestConnectionDropReported(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.UnderlyingConn().Close() // Drop the connection without a close frame
+	}))
+	defer s.Close()
+
+	logger := &recordingLogger{}
+	errs := make(chan error, 1)
+	c := startTestClient(t, NewDialer(testServerHost(s), SetLogger(logger)), errs)
+	defer c.conn.(*Ws).conn.Close()
+
+	select {
+	case err := <-errs:
+		if _, ok := err.(*WorkerError); !ok {
+			t.Errorf("Expected a worker error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the dropped connection to be reported")
+	}
+
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if len(logger.errors) != 1 {
+		t.Errorf("Expected the drop to be logged as an error, got %v", logger.errors)
+	}
+}
+
+func TestControlFramesTakeWriteLock(t *testing.T) {
+	frames := make(chan string, 10)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		conn.SetPingHandler(func(data string) error {
+			frames <- "ping"
+			return nil
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				frames <- "close"
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	ws := NewDialer(testServerHost(s))
+	if err := ws.connect(); err != nil {
+		t.Fatal(err)
+	}
+	ws.pingInterval = time.Millisecond
+
+	errs := make(chan error)
+	go func() { // Pings fail once the connection is closed
+		for range errs {
 		}
 	}()
+	defer close(errs)
+
+	ws.writeMu.Lock()
+	pinging := make(chan struct{})
+	go func() {
+		ws.ping(errs)
+		close(pinging)
+	}()
+	defer func() { <-pinging }()
+	closed := make(chan struct{})
+	go func() {
+		ws.close()
+		close(closed)
+	}()
+	select {
+	case frame := <-frames:
+		t.Errorf("Expected no frame to be written while the write lock is held, got %s", frame)
+	case <-time.After(50 * time.Millisecond):
+	}
+	ws.writeMu.Unlock()
+
+	select {
+	case <-frames:
+	case <-time.After(5 * time.Second):
+		t.Error("Expected the control frames to be written once the write lock is released")
+	}
+	<-closed
+}
+
+// TestDialedClientReportsErrored tests that the client returned by Dial shares the state its workers update
+func TestDialedClientReportsErrored(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.UnderlyingConn().Close() // Drop the connection without a close frame
+	}))
+	defer s.Close()
+
+	errs := make(chan error, 1)
+	c, err := Dial(NewDialer(testServerHost(s), SetLogger(&recordingLogger{})), errs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.conn.(*Ws).conn.Close()
+
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the dropped connection to be reported")
+	}
+	if !c.IsErrored() {
+		t.Error("Expected the dialed client to be errored once its connection dropped")
+	}
+}
+
+// TestReconnectHookSetAfterDial tests that the automatic reconnect runs a hook registered after Dial
+func TestReconnectHookSetAfterDial(t *testing.T) {
+	closeFirst := make(chan struct{})
+	n := 0
+	upgrader := websocket.Upgrader{}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		if n++; n == 1 {
+			<-closeFirst
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	c, err := Dial(NewDialer(testServerHost(s), SetLogger(&recordingLogger{}), SetCloseTimeout(1)), make(chan error, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	reconnected := make(chan struct{}, 1)
+	c.SetReconnectHook(func(execute func(query string) ([]Response, error)) error {
+		reconnected <- struct{}{}
+		return nil
+	})
+	close(closeFirst)
+
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the hook set after Dial to run on the automatic reconnect")
+	}
+}
+
+func TestFailoverPrefersPrimary(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	primaryAddr := l.Addr().String()
+	l.Close() // The primary is down
+
+	alternate := newTestServer(t)
+	defer alternate.Close()
+
+	ws := NewDialer("ws://"+primaryAddr, SetFailoverHosts(testServerHost(alternate)))
+	if err := ws.connect(); err != nil {
+		t.Fatal(err)
+	}
+	if ws.ActualHost() != testServerHost(alternate) {
+		t.Errorf("Expected to fail over to the alternate, connected to %s", ws.ActualHost())
+	}
+	ws.conn.Close()
+
+	l, err = net.Listen("tcp", primaryAddr) // The primary recovers
+	if err != nil {
+		t.Skipf("Cannot listen on the primary address again: %s", err)
+	}
+	primary := httptest.NewUnstartedServer(testServerHandler(t))
+	primary.Listener.Close()
+	primary.Listener = l
+	primary.Start()
+	defer primary.Close()
+
+	if err := ws.connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer ws.conn.Close()
+	if !strings.Contains(ws.ActualHost(), primaryAddr) {
+		t.Errorf("Expected to return to the recovered primary, connected to %s", ws.ActualHost())
+	}
+}
+
+func TestMaxResponseSize(t *testing.T) {
+	s := newTestServer(t, func(conn *websocket.Conn, msg []byte) {
+		var req Request
+		json.Unmarshal(msg[msg[0]+1:], &req)
+		data := `[]`
+		if req.Args["gremlin"] == "g.V()" {
+			data = `["` + strings.Repeat("x", 4096) + `"]`
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"requestId":"`+req.RequestID+`","result":{"data":`+data+`,"meta":{}},"status":{"code":200,"attributes":{},"message":""}}`))
+	})
+	defer s.Close()
+
+	ws := NewDialer(testServerHost(s))
+	c := startTestClient(t, ws, make(chan error, 10), SetMaxResponseSize(1024))
+	defer c.Shutdown(context.Background())
+
+	_, err := c.Execute("g.V()")
+	tooLarge, ok := errors.Cause(err).(*ErrResponseTooLarge)
+	if !ok {
+		t.Fatalf("Expected ErrResponseTooLarge, got %v", err)
+	}
+	if tooLarge.Max != 1024 || tooLarge.Size <= 4096 {
+		t.Errorf("Unexpected sizes %+v", tooLarge)
+	}
+	if _, err := c.Execute("g.V().count()"); err != nil {
+		t.Errorf("Expected the connection to stay usable after a frame too large, got %v", err)
+	}
+}
+
+func TestMaxResultSize(t *testing.T) {
+	s := newTestServer(t, func(conn *websocket.Conn, msg []byte) {
+		var req Request
+		json.Unmarshal(msg[msg[0]+1:], &req)
+		size := 500
+		if req.Args["gremlin"] == "g.V()" {
+			size = 1000
+		}
+		for i, code := range []int{statusPartialContent, statusPartialContent, statusSuccess} {
+			data := fmt.Sprintf(`["%d%s"]`, i, strings.Repeat("x", size))
+			conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(`{"requestId":"%s","result":{"data":%s,"meta":{}},"status":{"code":%d,"attributes":{},"message":""}}`, req.RequestID, data, code)))
+		}
+	})
+	defer s.Close()
+
+	ws := NewDialer(testServerHost(s))
+	c := startTestClient(t, ws, make(chan error, 10), SetMaxResultSize(2048))
+	defer c.Shutdown(context.Background())
+
+	_, err := c.Execute("g.V()")
+	tooLarge, ok := errors.Cause(err).(*ErrResultTooLarge)
+	if !ok {
+		t.Fatalf("Expected ErrResultTooLarge, got %v", err)
+	}
+	if tooLarge.Max != 2048 || tooLarge.Size <= 2048 {
+		t.Errorf("Unexpected sizes %+v", tooLarge)
+	}
+
+	resp, err := c.Execute("g.V().limit(3)")
+	if err != nil {
+		t.Fatalf("Expected a result within the limit to be reassembled, got %v", err)
+	}
+	var values []string
+	data, _ := ToJSON(resp)
+	if err := json.Unmarshal(data, &values); err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 3 {
+		t.Fatalf("Expected the values of all 3 frames, got %d", len(values))
+	}
+	for i, v := range values {
+		if v != fmt.Sprintf("%d%s", i, strings.Repeat("x", 500)) {
+			t.Errorf("Expected value %d to be reassembled in order, got %.10s", i, v)
+		}
+	}
+}
+
+func TestResponseRequestID(t *testing.T) {
+	for start, expected := range map[string]string{
+		`{"requestId" : "41d2e28a-20a4-4ab0-b379-d810dede3786","result":{"data":["xx`: "41d2e28a-20a4-4ab0-b379-d810dede3786",
+		`{"result":{"data":["xx`: "",
+		`{"requestId":"41d2e28a`: "",
+	} {
+		if id := responseRequestID([]byte(start)); id != expected {
+			t.Errorf("Expected %q for %s, got %q", expected, start, id)
+		}
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	agents := make(chan string, 1)
+	handler := testServerHandler(t)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents <- r.UserAgent()
+		handler.ServeHTTP(w, r)
+	}))
+	defer s.Close()
+
+	ws := NewDialer(testServerHost(s), SetUserAgent("inventory-service/1.4"))
+	if err := ws.connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer ws.close()
+	if agent := <-agents; agent != "inventory-service/1.4" {
+		t.Errorf("Expected the configured User-Agent, got %q", agent)
+	}
+}
+
+func TestHostsToDial(t *testing.T) {
+	for order, expected := range map[GremlinPathOrder][]string{
+		GremlinPathFallback: {"ws://db:8182", "ws://db:8182/gremlin"},
+		GremlinPathFirst:    {"ws://db:8182/gremlin", "ws://db:8182"},
+		GremlinPathOnly:     {"ws://db:8182/gremlin"},
+		GremlinPathNever:    {"ws://db:8182"},
+	} {
+		ws := NewDialer("ws://db:8182", SetGremlinPathOrder(order))
+		if hosts := ws.hostsToDial(ws.host); !reflect.DeepEqual(hosts, expected) {
+			t.Errorf("Expected %v for order %d, got %v", expected, order, hosts)
+		}
+		if hosts := ws.hostsToDial("ws://db:8182/gremlin"); !reflect.DeepEqual(hosts, []string{"ws://db:8182/gremlin"}) {
+			t.Errorf("Expected a suffixed host to be dialed as given for order %d, got %v", order, hosts)
+		}
+	}
+}
+
+func TestGremlinPathFirstSkipsBareHost(t *testing.T) {
+	var paths []string
+	var mu sync.Mutex
+	handler := testServerHandler(t)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		if r.URL.Path != "/gremlin" {
+			http.NotFound(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer s.Close()
 
-	c.conn.getAuth()
+	ws := NewDialer(testServerHost(s), SetGremlinPathOrder(GremlinPathFirst))
+	if err := ws.connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer ws.close()
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(paths, []string{"/gremlin"}) {
+		t.Errorf("Expected a single attempt at /gremlin, got %v", paths)
+	}
 }
@@ -0,0 +1,207 @@
+package gremtune
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// LazyElement is a vertex or edge whose properties are kept as raw GraphSON and only decoded when they are
+// accessed. Decoded properties are cached. It is safe for concurrent use.
+type LazyElement struct {
+	Type  string // Type is the GraphSON type of the element, g:Vertex or g:Edge
+	ID    interface{}
+	Label string
+	InV   interface{} // InV is the id of the incoming vertex of an edge
+	OutV  interface{} // OutV is the id of the outgoing vertex of an edge
+
+	mu         sync.Mutex
+	properties map[string]json.RawMessage
+	decoded    map[string][]interface{}
+}
+
+// NewLazyElement reads the id and label of a GraphSON vertex or edge and retains its properties undecoded.
+func NewLazyElement(data json.RawMessage) (*LazyElement, error) {
+	var typed typedValue
+	if err := json.Unmarshal(data, &typed); err != nil {
+		return nil, err
+	}
+	body := typed.Value
+	if typed.Type == "" { // Untyped GraphSON
+		body = data
+	}
+
+	var element struct {
+		ID         json.RawMessage            `json:"id"`
+		Label      string                     `json:"label"`
+		InV        json.RawMessage            `json:"inV"`
+		OutV       json.RawMessage            `json:"outV"`
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+	if err := json.Unmarshal(body, &element); err != nil {
+		return nil, errors.Wrap(err, "decoding element")
+	}
+
+	e := &LazyElement{Type: typed.Type, Label: element.Label, properties: element.Properties, decoded: make(map[string][]interface{})}
+	if len(element.ID) > 0 {
+		id, err := DecodeValue(element.ID)
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding element id")
+		}
+		e.ID = id
+	}
+	for _, v := range []struct {
+		raw    json.RawMessage
+		target *interface{}
+	}{{element.InV, &e.InV}, {element.OutV, &e.OutV}} {
+		if len(v.raw) > 0 {
+			id, err := DecodeValue(v.raw)
+			if err != nil {
+				return nil, errors.Wrap(err, "decoding edge vertex id")
+			}
+			*v.target = id
+		}
+	}
+	return e, nil
+}
+
+// DecodeLazyElements reads the elements of a result, which is a list of vertices or edges.
+func DecodeLazyElements(data json.RawMessage) ([]*LazyElement, error) {
+	var typed typedValue
+	items := data
+	if err := json.Unmarshal(data, &typed); err == nil && typed.Type == graphSONList {
+		items = typed.Value
+	}
+
+	var raw []json.RawMessage
+	if err := json.Unmarshal(items, &raw); err != nil {
+		return nil, errors.Wrap(err, "decoding element list")
+	}
+	elements := make([]*LazyElement, len(raw))
+	for i, r := range raw {
+		e, err := NewLazyElement(r)
+		if err != nil {
+			return nil, err
+		}
+		elements[i] = e
+	}
+	return elements, nil
+}
+
+// GetVertexByID reads the vertex with the given id. The id can be of any type the graph uses for ids, such as a
+// string, an int64, a uuid.UUID or a composite map, and is sent as a typed binding, so that the ID of a LazyElement
+// can be passed as is. It returns ErrVertexNotFound when there is no such vertex.
+func (c *Client) GetVertexByID(id interface{}) (*LazyElement, error) {
+	resp, err := c.ExecuteWithTypedBindings("g.V(vertexId)", map[string]interface{}{"vertexId": id}, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range resp {
+		if len(r.Result.Data) == 0 || string(r.Result.Data) == "null" {
+			continue
+		}
+		elements, err := DecodeLazyElements(r.Result.Data)
+		if err != nil {
+			return nil, err
+		}
+		if len(elements) > 0 {
+			return elements[0], nil
+		}
+	}
+	return nil, ErrVertexNotFound
+}
+
+// Keys returns the property keys of the element in sorted order, without decoding any property.
+func (e *LazyElement) Keys() []string {
+	keys := make([]string, 0, len(e.properties))
+	for k := range e.properties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Property returns the first value of the property, decoding it on first access. ok is false when the element
+// has no such property.
+func (e *LazyElement) Property(key string) (value interface{}, ok bool, err error) {
+	values, err := e.Properties(key)
+	if err != nil || len(values) == 0 {
+		return nil, false, err
+	}
+	return values[0], true, nil
+}
+
+// Properties returns all values of the property, decoding them on first access. Vertex properties may carry
+// several values, edge properties carry one.
+func (e *LazyElement) Properties(key string) ([]interface{}, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if values, ok := e.decoded[key]; ok {
+		return values, nil
+	}
+	raw, ok := e.properties[key]
+	if !ok {
+		return nil, nil
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil { // Edge properties are a single g:Property
+		items = []json.RawMessage{raw}
+	}
+	values := make([]interface{}, len(items))
+	for i, item := range items {
+		v, err := decodePropertyValue(item)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decoding property %s", key)
+		}
+		values[i] = v
+	}
+	e.decoded[key] = values
+	return values, nil
+}
+
+// ToMap decodes all properties and returns the element as a plain map, for JSON encoding or templates:
+// {"id": ..., "label": ..., "properties": {...}}, with "inV" and "outV" for edges. A property with a single
+// value maps to that value, a property with several values, of LIST or SET cardinality, maps to all of them.
+func (e *LazyElement) ToMap() (map[string]interface{}, error) {
+	properties := make(map[string]interface{}, len(e.properties))
+	for _, key := range e.Keys() {
+		values, err := e.Properties(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(values) == 1 {
+			properties[key] = values[0]
+		} else {
+			properties[key] = values
+		}
+	}
+
+	m := map[string]interface{}{"id": e.ID, "label": e.Label, "properties": properties}
+	if e.Type == "g:Edge" {
+		m["inV"] = e.InV
+		m["outV"] = e.OutV
+	}
+	return m, nil
+}
+
+// decodePropertyValue decodes the value of a g:VertexProperty or g:Property
+func decodePropertyValue(data json.RawMessage) (interface{}, error) {
+	var typed typedValue
+	if err := json.Unmarshal(data, &typed); err != nil {
+		return nil, err
+	}
+	body := typed.Value
+	if typed.Type == "" {
+		body = data
+	}
+	var property struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(body, &property); err != nil {
+		return nil, err
+	}
+	return DecodeValue(property.Value)
+}
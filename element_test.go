@@ -0,0 +1,133 @@
+package gremtune
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+var dummyElements = json.RawMessage(`{"@type":"g:List","@value":[
+  {"@type":"g:Vertex","@value":{"id":{"@type":"g:Int64","@value":1},"label":"person","properties":{
+    "name":[{"@type":"g:VertexProperty","@value":{"id":{"@type":"g:Int64","@value":0},"value":"marko","label":"name"}}],
+    "age":[{"@type":"g:VertexProperty","@value":{"id":{"@type":"g:Int64","@value":2},"value":{"@type":"g:Int32","@value":29},"label":"age"}}]}}},
+  {"@type":"g:Edge","@value":{"id":{"@type":"g:Int64","@value":7},"label":"knows","inV":"2","outV":"1","properties":{
+    "weight":{"@type":"g:Property","@value":{"key":"weight","value":{"@type":"g:Double","@value":0.5}}}}}}
+]}`)
+
+func TestDecodeLazyElements(t *testing.T) {
+	elements, err := DecodeLazyElements(dummyElements)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(elements) != 2 {
+		t.Fatalf("Expected 2 elements, got %d", len(elements))
+	}
+
+	vertex, edge := elements[0], elements[1]
+	if vertex.Type != "g:Vertex" || vertex.ID != int64(1) || vertex.Label != "person" {
+		t.Errorf("Unexpected vertex: %+v", vertex)
+	}
+	if !reflect.DeepEqual(vertex.Keys(), []string{"age", "name"}) {
+		t.Errorf("Unexpected vertex keys: %v", vertex.Keys())
+	}
+	if len(vertex.decoded) != 0 {
+		t.Error("Expected no property to be decoded before it is accessed")
+	}
+
+	if age, ok, err := vertex.Property("age"); err != nil || !ok || age != int32(29) {
+		t.Errorf("Unexpected age: %v, %v, %v", age, ok, err)
+	}
+	if _, ok := vertex.decoded["name"]; ok {
+		t.Error("Expected unread properties to stay undecoded")
+	}
+
+	if weight, ok, err := edge.Property("weight"); err != nil || !ok || weight != 0.5 {
+		t.Errorf("Unexpected weight: %v, %v, %v", weight, ok, err)
+	}
+
+	if _, ok, _ := edge.Property("missing"); ok {
+		t.Error("Expected a missing property not to be found")
+	}
+}
+
+func TestLazyElementToMap(t *testing.T) {
+	elements, err := DecodeLazyElements(dummyElements)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vertex, err := elements[0].ToMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"id": int64(1), "label": "person", "properties": map[string]interface{}{"name": "marko", "age": int32(29)}}
+	if !reflect.DeepEqual(vertex, expected) {
+		t.Errorf("Expected %v, got %v", expected, vertex)
+	}
+
+	edge, err := elements[1].ToMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = map[string]interface{}{"id": int64(7), "label": "knows", "inV": "2", "outV": "1", "properties": map[string]interface{}{"weight": 0.5}}
+	if !reflect.DeepEqual(edge, expected) {
+		t.Errorf("Expected %v, got %v", expected, edge)
+	}
+}
+
+func TestLazyElementToMapMultipleValues(t *testing.T) {
+	e, err := NewLazyElement(json.RawMessage(`{"@type":"g:Vertex","@value":{"id":1,"label":"person","properties":{
+    "alias":[{"@type":"g:VertexProperty","@value":{"id":0,"value":"m","label":"alias"}},{"@type":"g:VertexProperty","@value":{"id":1,"value":"mr","label":"alias"}}]}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := e.ToMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aliases := m["properties"].(map[string]interface{})["alias"]; !reflect.DeepEqual(aliases, []interface{}{"m", "mr"}) {
+		t.Errorf("Expected both aliases, got %v", aliases)
+	}
+}
+
+func TestGetVertexByIDCompositeID(t *testing.T) {
+	c, fake := startFakeClient(t)
+	fake.respond = func(id string) []byte {
+		return []byte(`{"result":{"data":{"@type":"g:List","@value":[{"@type":"g:Vertex","@value":{"id":{"@type":"g:Map","@value":["pk","tenant",{"@type":"g:Int64","@value":7},{"@type":"g:Int32","@value":1}]},"label":"person"}}]},"meta":{}},"requestId":"` + id + `","status":{"code":200,"attributes":{},"message":""}}`)
+	}
+
+	first, err := c.GetVertexByID(map[interface{}]interface{}{"pk": "tenant", int64(7): int32(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[interface{}]interface{}{"pk": "tenant", int64(7): int32(1)}
+	if !reflect.DeepEqual(first.ID, expected) {
+		t.Fatalf("Unexpected id, expected: %#v got: %#v", expected, first.ID)
+	}
+	if _, err := c.GetVertexByID(first.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := writtenRequests(t, fake)
+	for _, req := range requests {
+		raw, err := json.Marshal(req.Args["bindings"].(map[string]interface{})["vertexId"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := DecodeValue(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(id, expected) {
+			t.Errorf("Unexpected bound id, expected: %#v got: %#v", expected, id)
+		}
+	}
+}
+
+func TestGetVertexByIDNotFound(t *testing.T) {
+	c, _ := startFakeClient(t)
+	if _, err := c.GetVertexByID(int64(1)); err != ErrVertexNotFound {
+		t.Errorf("Expected ErrVertexNotFound, got: %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+package gremtune
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+)
+
+// WorkerError is sent on the error channel of the client when its write or read worker fails.
+type WorkerError struct {
+	Worker    string     // Worker is either "write" or "read"
+	RequestID *uuid.UUID // RequestID identifies the request in flight, nil when it cannot be determined or is not a UUID
+	ID        string     // ID is the request id in flight in any format, such as a ULID, empty when it cannot be determined
+	Err       error
+}
+
+func (e *WorkerError) Error() string {
+	id := e.ID
+	if id == "" && e.RequestID != nil {
+		id = e.RequestID.String()
+	}
+	if id == "" {
+		return fmt.Sprintf("%s worker: %s", e.Worker, e.Err)
+	}
+	return fmt.Sprintf("%s worker: request %s: %s", e.Worker, id, e.Err)
+}
+
+// Cause returns the underlying error, for use with errors.Cause
+func (e *WorkerError) Cause() error {
+	return e.Err
+}
+
+// InvalidSchemeError is returned for a host whose URL scheme is neither ws nor wss, such as http://host:8182
+type InvalidSchemeError struct {
+	Scheme string
+}
+
+func (e *InvalidSchemeError) Error() string {
+	return fmt.Sprintf("invalid host scheme %q: use ws:// or wss:// instead of %s://", e.Scheme, e.Scheme)
+}
+
+// InvalidBindingError is returned for a script with a binding whose key is not a valid identifier, or is a reserved
+// name such as a Groovy keyword, which the server would only report as a compilation failure of the script
+type InvalidBindingError struct {
+	Key      string
+	Reserved bool // Reserved is set for a valid identifier which is a reserved name
+}
+
+func (e *InvalidBindingError) Error() string {
+	if e.Reserved {
+		return fmt.Sprintf("binding key %q is a reserved name", e.Key)
+	}
+	return fmt.Sprintf("binding key %q is not a valid identifier", e.Key)
+}
+
+// ErrNotAuthenticated is returned when the server requests authentication from a dialer without credentials,
+// see SetCredentials
+var ErrNotAuthenticated = errors.New("the server requires authentication but no credentials are set")
+
+// ErrAuthFailed is returned by connect and reconnect when the server rejected the credentials of the dialer. A
+// dialer whose credentials were rejected does not reconnect with them again, until they are changed.
+var ErrAuthFailed = errors.New("the server rejected the credentials")
+
+// ErrAuthTimeout is returned by connect when the server did not answer the authentication of a new connection within
+// the auth timeout, see SetAuthTimeout
+var ErrAuthTimeout = errors.New("timed out authenticating with the server")
+
+// ErrClientShutdown is returned for requests pending on, or sent to, a client which has been shut down
+var ErrClientShutdown = errors.New("the client has been shut down")
+
+// ErrVertexNotFound is returned by GetVertexByID when the graph has no vertex with the id
+var ErrVertexNotFound = errors.New("vertex not found")
+
+// ErrResponseTooLarge is returned for a request whose response frame is larger than the MaxResponseSize of the
+// client
+type ErrResponseTooLarge struct {
+	Size int64 // Size is the size of the frame in bytes
+	Max  int64
+}
+
+func (e *ErrResponseTooLarge) Error() string {
+	return fmt.Sprintf("response frame of %d bytes exceeds the maximum response size of %d bytes", e.Size, e.Max)
+}
+
+// ErrResultTooLarge is returned for a request whose result data, summed over all of its frames, is larger than the
+// MaxResultSize of the client
+type ErrResultTooLarge struct {
+	Size int64 // Size is the size of the result data received until the limit was exceeded, in bytes
+	Max  int64
+}
+
+func (e *ErrResultTooLarge) Error() string {
+	return fmt.Sprintf("result of at least %d bytes exceeds the maximum result size of %d bytes", e.Size, e.Max)
+}
+
+// ErrNoRawResponse is returned by Response.Unmarshal for a response without its raw frame, see SetRawResponses
+var ErrNoRawResponse = errors.New("the response does not keep its raw frame")
+
+// ErrDisposed is returned for requests executed on a client whose connection has been closed
+var ErrDisposed = errors.New("you cannot write on disposed connection")
+
+// IsConnectionError reports whether err is a failure of the connection rather than of the query, in which case
+// the client needs to be reset or replaced before it is used again. Errors reported by the server in response to a
+// query, such as script evaluation errors, are not connection errors.
+func IsConnectionError(err error) bool {
+	for err != nil { // Every error of the chain is looked at, a failed worker is a connection error whatever its cause
+		switch err {
+		case ErrDisposed, ErrReset, ErrClientShutdown, ErrSessionLost, ErrNoConnection, errClosedByClient,
+			io.EOF, io.ErrUnexpectedEOF, websocket.ErrCloseSent, websocket.ErrBadHandshake:
+			return true
+		}
+		switch err.(type) {
+		case *WorkerError, *websocket.CloseError, net.Error:
+			return true
+		}
+		cause, ok := err.(interface{ Cause() error })
+		if !ok {
+			return false
+		}
+		err = cause.Cause()
+	}
+	return false
+}
+
+// IsRetryable reports whether sending the request again may succeed: after a connection error, on a new or reset
+// connection, when the server timed out or throttled the request, or when no response frame arrived in time.
+// Whether a mutation may safely be sent twice is up to the caller.
+func IsRetryable(err error) bool {
+	switch cause := errors.Cause(err); cause {
+	case ErrFirstFrameTimeout, ErrSessionBusy:
+		return true
+	case ErrMutationNotRetried:
+		return false
+	}
+	if statusErr, ok := errors.Cause(err).(*StatusError); ok {
+		_, throttled := statusErr.RetryAfter()
+		return throttled || statusErr.Status.Code == statusServerTimeout
+	}
+	return IsConnectionError(err)
+}
+
+// ShutdownError collects everything that failed while shutting down a client
+type ShutdownError struct {
+	Errors []error
+}
+
+func (e *ShutdownError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return "shutdown: " + strings.Join(msgs, "; ")
+}
+
+// frameRequestID extracts the request id from a request frame, which is prefixed with its mime type, or from a
+// plain JSON response frame. It returns nil when the frame carries no readable id.
+func frameRequestID(msg []byte) *uuid.UUID {
+	id, err := uuid.FromString(frameRequestIDString(msg))
+	if err != nil {
+		return nil
+	}
+	return &id
+}
+
+// splitFrame splits a request frame into its mime type prefix and its JSON body. Plain JSON frames have no prefix.
+func splitFrame(msg []byte) (prefix, body []byte) {
+	if len(msg) > 0 && msg[0] != '{' && int(msg[0]) < len(msg) {
+		return msg[:msg[0]+1], msg[msg[0]+1:]
+	}
+	return nil, msg
+}
+
+// frameRequestIDString is like frameRequestID, but returns the id as sent, or an empty id when there is none
+func frameRequestIDString(msg []byte) string {
+	var frame struct {
+		RequestID string `json:"requestId"`
+	}
+	_, body := splitFrame(msg)
+	if err := json.Unmarshal(body, &frame); err != nil {
+		return ""
+	}
+	return frame.RequestID
+}
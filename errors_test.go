@@ -0,0 +1,89 @@
+package gremtune
+
+import (
+	"net"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+)
+
+func TestFrameRequestID(t *testing.T) {
+	req, id, _ := prepareRequest("g.V()")
+	msg, _ := packageRequest(req)
+
+	if got := frameRequestID(msg); got == nil || got.String() != id {
+		t.Errorf("Expected request id %s from the request frame, got: %v", id, got)
+	}
+
+	if got := frameRequestID(dummySuccessfulResponse); got == nil || got.String() != dummySuccessfulResponseMarshalled.RequestID {
+		t.Errorf("Expected request id from the response frame, got: %v", got)
+	}
+
+	if got := frameRequestID([]byte("garbage")); got != nil {
+		t.Errorf("Expected no request id from an unreadable frame, got: %v", got)
+	}
+}
+
+func TestWorkerErrorMessage(t *testing.T) {
+	req, id, _ := prepareRequest("g.V()")
+	msg, _ := packageRequest(req)
+
+	err := &WorkerError{Worker: "write", RequestID: frameRequestID(msg), Err: errors.New("broken pipe")}
+	if err.Error() != "write worker: request "+id+": broken pipe" {
+		t.Errorf("Unexpected error message: %s", err)
+	}
+
+	custom := "req-42"
+	req.RequestID = custom
+	msg, _ = packageRequest(req)
+	err = &WorkerError{Worker: "write", RequestID: frameRequestID(msg), ID: frameRequestIDString(msg), Err: errors.New("broken pipe")}
+	if err.RequestID != nil || err.ID != custom {
+		t.Errorf("Expected the id %s to be kept, got %v and %q", custom, err.RequestID, err.ID)
+	}
+	if err.Error() != "write worker: request "+custom+": broken pipe" {
+		t.Errorf("Unexpected error message: %s", err)
+	}
+
+	err = &WorkerError{Worker: "read", Err: errors.New("broken pipe")}
+	if err.Error() != "read worker: broken pipe" {
+		t.Errorf("Unexpected error message: %s", err)
+	}
+}
+
+func TestIsConnectionError(t *testing.T) {
+	scriptErr := (&Response{Status: Status{Code: statusScriptEvaluationError}}).detectError()
+	for err, want := range map[error]bool{
+		nil:                                     false,
+		ErrDisposed:                             true,
+		errors.Wrap(ErrReset, "query: g.V()"):   true,
+		&WorkerError{Worker: "read"}:            true,
+		&websocket.CloseError{Code: 1006}:       true,
+		&net.OpError{Op: "read", Err: ErrReset}: true,
+		scriptErr:                               false,
+		ErrScriptTooLarge:                       false,
+	} {
+		if got := IsConnectionError(err); got != want {
+			t.Errorf("Expected IsConnectionError(%v) to be %t", err, want)
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	timeout := (&Response{Status: Status{Code: statusServerTimeout}}).detectError()
+	throttled := (&Response{Status: Status{Code: statusServerError, Attributes: map[string]interface{}{retryAfterAttribute: 10.0}}}).detectError()
+	scriptErr := (&Response{Status: Status{Code: statusScriptEvaluationError}}).detectError()
+	for err, want := range map[error]bool{
+		ErrReset:              true,
+		timeout:               true,
+		throttled:             true,
+		ErrFirstFrameTimeout:  true,
+		scriptErr:             false,
+		ErrMutationNotRetried: false,
+		nil:                   false,
+	} {
+		if got := IsRetryable(err); got != want {
+			t.Errorf("Expected IsRetryable(%v) to be %t", err, want)
+		}
+	}
+}
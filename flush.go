@@ -0,0 +1,82 @@
+package gremtune
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// outbound counts the requests queued for writing and those taken off the queue, written or dropped, so that
+// Flush can wait for the requests queued before it was called
+type outbound struct {
+	mu     sync.Mutex
+	done   *sync.Cond // done is broadcast whenever requests are taken off the queue
+	queued uint64
+	taken  uint64
+}
+
+func newOutbound() *outbound {
+	o := &outbound{}
+	o.done = sync.NewCond(&o.mu)
+	return o
+}
+
+// queue counts a request queued for writing
+func (o *outbound) queue() {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	o.queued++
+	o.mu.Unlock()
+}
+
+// take counts n requests written or dropped
+func (o *outbound) take(n int) {
+	if o == nil || n == 0 {
+		return
+	}
+	o.mu.Lock()
+	o.taken += uint64(n)
+	o.done.Broadcast()
+	o.mu.Unlock()
+}
+
+// wait waits until the requests queued so far have been taken off the queue, or ctx is done
+func (o *outbound) wait(ctx context.Context) error {
+	if o == nil {
+		return nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	target := o.queued
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() { // Wake up the waiter when the context is done
+		select {
+		case <-ctx.Done():
+			o.mu.Lock()
+			o.done.Broadcast()
+			o.mu.Unlock()
+		case <-stop:
+		}
+	}()
+
+	for o.taken < target {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		o.done.Wait()
+	}
+	return nil
+}
+
+// Flush waits until every request queued for writing when it was called has been written to the connection, or
+// dropped by a reset or shutdown, without waiting for their responses. Requests whose write failed and which are
+// kept for the next connection, see SetOrderedReconnect, are only flushed once written after Reset. It gives up
+// when ctx is done.
+func (c *Client) Flush(ctx context.Context) error {
+	return errors.Wrap(c.outbound.wait(ctx), "flushing requests")
+}
@@ -0,0 +1,44 @@
+package gremtune
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestFlush(t *testing.T) {
+	c := newClient()
+	fake := &fakeDialer{client: &c}
+	c.conn = fake
+	c.dispatchRequest([]byte("\x01a{}"))
+	c.dispatchRequest([]byte("\x01b{}"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := c.Flush(ctx); errors.Cause(err) != context.DeadlineExceeded {
+		t.Fatalf("Expected Flush to wait for the queued requests until the context was done, got %v", err)
+	}
+
+	quit := make(chan struct{})
+	defer close(quit)
+	go c.writeWorker(make(chan error, 1), quit)
+	if err := c.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	c.Lock()
+	defer c.Unlock()
+	if len(fake.written) != 2 {
+		t.Errorf("Expected both requests to be written once flushed, got %d", len(fake.written))
+	}
+}
+
+func TestFlushAfterReset(t *testing.T) {
+	c := newClient()
+	c.dispatchRequest([]byte("\x01a{}"))
+	c.failPending(ErrReset, false)
+	if err := c.Flush(context.Background()); err != nil {
+		t.Errorf("Expected requests dropped by a reset to be flushed, got %v", err)
+	}
+}
@@ -1,7 +1,9 @@
 module github.com/schwartzmx/gremtune
 
+go 1.21
+
 require (
 	github.com/gofrs/uuid v3.2.0+incompatible
-	github.com/gorilla/websocket v1.2.0
+	github.com/gorilla/websocket v1.4.2
 	github.com/pkg/errors v0.8.1
 )
@@ -0,0 +1,375 @@
+package gremtune
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+)
+
+// UUIDs are represented by github.com/gofrs/uuid, the same library gremtune uses to generate request ids.
+
+const (
+	graphSONUUID   = "g:UUID"
+	graphSONList   = "g:List"
+	graphSONInt32  = "g:Int32"
+	graphSONInt64  = "g:Int64"
+	graphSONFloat  = "g:Float"
+	graphSONDouble = "g:Double"
+	graphSONBulk   = "g:BulkSet"
+	graphSONMap    = "g:Map"
+	graphSONDate   = "g:Date"
+	graphSONStamp  = "g:Timestamp"
+)
+
+// BulkSet is a g:BulkSet, a collection holding every distinct value once together with the number of times it
+// occurs. Barrier steps return their results bulked this way.
+type BulkSet []BulkItem
+
+// BulkItem is a distinct value of a BulkSet and the number of times it occurs
+type BulkItem struct {
+	Value interface{}
+	Count int64
+}
+
+// Expand returns every value of the bulk set as many times as it occurs
+func (b BulkSet) Expand() []interface{} {
+	var values []interface{}
+	for _, item := range b {
+		for i := int64(0); i < item.Count; i++ {
+			values = append(values, item.Value)
+		}
+	}
+	return values
+}
+
+// Range calls fn with every distinct value and its count, in the order of the bulk set, until fn returns false
+func (b BulkSet) Range(fn func(value interface{}, count int64) bool) {
+	for _, item := range b {
+		if !fn(item.Value, item.Count) {
+			return
+		}
+	}
+}
+
+// Size returns the number of values in the bulk set, counting every occurrence
+func (b BulkSet) Size() (n int64) {
+	for _, item := range b {
+		n += item.Count
+	}
+	return
+}
+
+// typedValue is a GraphSON value carrying its type
+type typedValue struct {
+	Type  string          `json:"@type"`
+	Value json.RawMessage `json:"@value"`
+}
+
+// encodeValue converts a binding into its GraphSON form, values without a dedicated GraphSON type are returned as is.
+// int32 and int64 keep their width, so that element ids decoded from a result can be bound again. Maps and lists are
+// encoded recursively, a map[interface{}]interface{}, such as a decoded composite id, becomes a g:Map.
+func encodeValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case uuid.UUID:
+		raw, _ := json.Marshal(value.String())
+		return typedValue{Type: graphSONUUID, Value: raw}
+	case int32:
+		raw, _ := json.Marshal(value)
+		return typedValue{Type: graphSONInt32, Value: raw}
+	case int64:
+		raw, _ := json.Marshal(value)
+		return typedValue{Type: graphSONInt64, Value: raw}
+	case []interface{}:
+		encoded := make([]interface{}, len(value))
+		for i, item := range value {
+			encoded[i] = encodeValue(item)
+		}
+		return encoded
+	case map[string]interface{}:
+		return encodeBindings(value)
+	case map[interface{}]interface{}:
+		items := make([]interface{}, 0, 2*len(value))
+		for k, item := range value {
+			items = append(items, encodeValue(k), encodeValue(item))
+		}
+		raw, err := json.Marshal(items)
+		if err != nil {
+			return v
+		}
+		return typedValue{Type: graphSONMap, Value: raw}
+	default:
+		return v
+	}
+}
+
+// encodeBindings converts all bindings into their GraphSON form
+func encodeBindings(bindings map[string]interface{}) map[string]interface{} {
+	encoded := make(map[string]interface{}, len(bindings))
+	for k, v := range bindings {
+		encoded[k] = encodeValue(v)
+	}
+	return encoded
+}
+
+// DecodeValue decodes GraphSON result data into Go values. g:UUID becomes uuid.UUID. g:List becomes []interface{}.
+// g:Map becomes map[interface{}]interface{}. g:BulkSet becomes BulkSet. g:Set becomes GremlinSet. g:Tree becomes
+// *Tree. Numeric types become int32, int64, float32 or float64. Objects become map[string]interface{}. g:Date and
+// g:Timestamp, milliseconds since the Unix epoch, become time.Time in UTC. Other types are decoded from @value.
+func DecodeValue(data json.RawMessage) (interface{}, error) {
+	var typed typedValue
+	if err := json.Unmarshal(data, &typed); err == nil && typed.Type != "" {
+		return decodeTyped(typed)
+	}
+
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+	switch generic.(type) {
+	case []interface{}:
+		var items []json.RawMessage
+		json.Unmarshal(data, &items)
+		return decodeList(items)
+	case map[string]interface{}:
+		var fields map[string]json.RawMessage
+		json.Unmarshal(data, &fields)
+		decoded := make(map[string]interface{}, len(fields))
+		for k, raw := range fields {
+			v, err := DecodeValue(raw)
+			if err != nil {
+				return nil, err
+			}
+			decoded[k] = v
+		}
+		return decoded, nil
+	default:
+		return generic, nil
+	}
+}
+
+func decodeTyped(typed typedValue) (v interface{}, err error) {
+	switch typed.Type {
+	case graphSONUUID:
+		var s string
+		if err = json.Unmarshal(typed.Value, &s); err != nil {
+			return
+		}
+		v, err = uuid.FromString(s)
+	case graphSONList:
+		var items []json.RawMessage
+		if err = json.Unmarshal(typed.Value, &items); err != nil {
+			return
+		}
+		v, err = decodeList(items)
+	case graphSONInt32:
+		var n int32
+		err = json.Unmarshal(typed.Value, &n)
+		v = n
+	case graphSONInt64:
+		var n int64
+		err = json.Unmarshal(typed.Value, &n)
+		v = n
+	case graphSONFloat:
+		var n float32
+		err = json.Unmarshal(typed.Value, &n)
+		v = n
+	case graphSONDouble:
+		var n float64
+		err = json.Unmarshal(typed.Value, &n)
+		v = n
+	case graphSONDate, graphSONStamp:
+		var ms int64
+		if err = json.Unmarshal(typed.Value, &ms); err != nil {
+			return
+		}
+		v = time.Unix(ms/1000, ms%1000*int64(time.Millisecond)).UTC()
+	case graphSONBulk:
+		v, err = decodeBulkSet(typed.Value)
+	case graphSONMap:
+		v, err = decodeMap(typed.Value)
+	case graphSONTree:
+		v, err = decodeTree(typed.Value)
+	case graphSONSet:
+		v, err = decodeSet(typed.Value)
+	default:
+		v, err = DecodeValue(typed.Value)
+	}
+	return v, errors.Wrapf(err, "decoding %s", typed.Type)
+}
+
+// decodeMap decodes the @value of a g:Map, which alternates between a key and its value. Keys can be of any type,
+// as long as they can be used as Go map keys.
+func decodeMap(data json.RawMessage) (map[interface{}]interface{}, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, err
+	}
+	if len(items)%2 != 0 {
+		return nil, errors.New("map without a value for its last key")
+	}
+
+	m := make(map[interface{}]interface{}, len(items)/2)
+	for i := 0; i < len(items); i += 2 {
+		key, err := DecodeValue(items[i])
+		if err != nil {
+			return nil, err
+		}
+		if !isComparable(key) {
+			return nil, errors.Errorf("map key of type %T cannot be used as a Go map key", key)
+		}
+		value, err := DecodeValue(items[i+1])
+		if err != nil {
+			return nil, err
+		}
+		m[key] = value
+	}
+	return m, nil
+}
+
+// ToStringKeyedMap converts a decoded g:Map whose keys are all strings, such as the result of groupCount() by a
+// property, into a map[string]interface{}. It fails on the first key which is not a string.
+func ToStringKeyedMap(m map[interface{}]interface{}) (map[string]interface{}, error) {
+	converted := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		s, ok := k.(string)
+		if !ok {
+			return nil, errors.Errorf("map key %v of type %T is not a string", k, k)
+		}
+		converted[s] = v
+	}
+	return converted, nil
+}
+
+// decodeBulkSet decodes the @value of a g:BulkSet, which alternates between a value and its count
+func decodeBulkSet(data json.RawMessage) (BulkSet, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, err
+	}
+	if len(items)%2 != 0 {
+		return nil, errors.New("bulk set without a count for its last value")
+	}
+
+	bulk := make(BulkSet, 0, len(items)/2)
+	for i := 0; i < len(items); i += 2 {
+		value, err := DecodeValue(items[i])
+		if err != nil {
+			return nil, err
+		}
+		count, err := DecodeValue(items[i+1])
+		if err != nil {
+			return nil, err
+		}
+		item := BulkItem{Value: value}
+		switch n := count.(type) {
+		case int64:
+			item.Count = n
+		case int32:
+			item.Count = int64(n)
+		case float64: // Untyped counts
+			item.Count = int64(n)
+		default:
+			return nil, errors.Errorf("bulk set count of type %T", count)
+		}
+		bulk = append(bulk, item)
+	}
+	return bulk, nil
+}
+
+func decodeList(items []json.RawMessage) ([]interface{}, error) {
+	list := make([]interface{}, len(items))
+	for i, raw := range items {
+		v, err := DecodeValue(raw)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = v
+	}
+	return list, nil
+}
+
+// ToJSON converts the results of all response frames into a single JSON array with the GraphSON type wrappers
+// stripped, such as to pass them on to an HTTP client. Values are decoded like DecodeValue does. Map keys which are
+// not strings are formatted with fmt, bulk sets are expanded and trees become nested key and children objects.
+func ToJSON(resp []Response) ([]byte, error) {
+	values := []interface{}{}
+	for _, r := range resp {
+		if len(r.Result.Data) == 0 || string(r.Result.Data) == "null" {
+			continue
+		}
+		v, err := DecodeValue(r.Result.Data)
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding results")
+		}
+		if items, ok := v.([]interface{}); ok {
+			for _, item := range items {
+				values = append(values, plainValue(item))
+			}
+		} else {
+			values = append(values, plainValue(v))
+		}
+	}
+	return json.Marshal(values)
+}
+
+// ExecuteTyped sends a query to Gremlin Server and decodes every element of the result into a T, such as int64 for
+// counts or a struct with json tags for vertices. Elements are decoded into T from their JSON form as returned by
+// ToJSON, without the GraphSON type wrappers.
+func ExecuteTyped[T any](c *Client, query string) ([]T, error) {
+	resp, err := c.Execute(query)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ToJSON(resp)
+	if err != nil {
+		return nil, err
+	}
+	results := []T{}
+	if err = json.Unmarshal(data, &results); err != nil {
+		return nil, errors.Wrapf(err, "decoding results into %T", results)
+	}
+	return results, nil
+}
+
+// plainValue converts a decoded value into one encoding/json marshals to idiomatic JSON
+func plainValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		plain := make([]interface{}, len(v))
+		for i, item := range v {
+			plain[i] = plainValue(item)
+		}
+		return plain
+	case map[string]interface{}:
+		plain := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			plain[k] = plainValue(item)
+		}
+		return plain
+	case map[interface{}]interface{}:
+		plain := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			key, ok := k.(string)
+			if !ok {
+				key = fmt.Sprint(k)
+			}
+			plain[key] = plainValue(item)
+		}
+		return plain
+	case BulkSet:
+		return plainValue(v.Expand())
+	case GremlinSet:
+		return plainValue(v.Values())
+	case *Tree:
+		nodes := make([]interface{}, len(v.Children))
+		for i, child := range v.Children {
+			nodes[i] = map[string]interface{}{"key": plainValue(child.Key), "children": plainValue(child)}
+		}
+		return nodes
+	default:
+		return v
+	}
+}
@@ -0,0 +1,200 @@
+package gremtune
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+func TestEncodeUUIDBinding(t *testing.T) {
+	id := uuid.Must(uuid.FromString("1d6d02bd-8e56-421d-9438-3bd6d0079ff1"))
+	j, err := json.Marshal(encodeBindings(map[string]interface{}{"id": id, "name": "marko"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"id":{"@type":"g:UUID","@value":"1d6d02bd-8e56-421d-9438-3bd6d0079ff1"},"name":"marko"}`
+	if string(j) != expected {
+		t.Errorf("Unexpected bindings, expected: %s got: %s", expected, j)
+	}
+}
+
+func TestEncodeIDBindings(t *testing.T) {
+	j, err := json.Marshal(encodeBindings(map[string]interface{}{"long": int64(1), "int": int32(2), "composite": map[string]interface{}{"id": int64(3)}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"composite":{"id":{"@type":"g:Int64","@value":3}},"int":{"@type":"g:Int32","@value":2},"long":{"@type":"g:Int64","@value":1}}`
+	if string(j) != expected {
+		t.Errorf("Unexpected bindings, expected: %s got: %s", expected, j)
+	}
+}
+
+func TestDecodeValue(t *testing.T) {
+	data := json.RawMessage(`{"@type":"g:List","@value":[{"@type":"g:UUID","@value":"1d6d02bd-8e56-421d-9438-3bd6d0079ff1"},{"@type":"g:Int64","@value":3},"marko"]}`)
+	v, err := DecodeValue(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []interface{}{uuid.Must(uuid.FromString("1d6d02bd-8e56-421d-9438-3bd6d0079ff1")), int64(3), "marko"}
+	if !reflect.DeepEqual(v, expected) {
+		t.Errorf("Unexpected value, expected: %#v got: %#v", expected, v)
+	}
+}
+
+func TestDecodeInvalidUUID(t *testing.T) {
+	if _, err := DecodeValue(json.RawMessage(`{"@type":"g:UUID","@value":"not-a-uuid"}`)); err == nil {
+		t.Error("Expected an error for an invalid UUID")
+	}
+}
+
+func TestDecodeDates(t *testing.T) {
+	for data, expected := range map[string]time.Time{
+		`{"@type":"g:Date","@value":1481750076295}`:      time.Date(2016, 12, 14, 21, 14, 36, 295e6, time.UTC),
+		`{"@type":"g:Timestamp","@value":1481750076295}`: time.Date(2016, 12, 14, 21, 14, 36, 295e6, time.UTC),
+		`{"@type":"g:Date","@value":-1}`:                 time.Date(1969, 12, 31, 23, 59, 59, 999e6, time.UTC),
+	} {
+		v, err := DecodeValue(json.RawMessage(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(v, expected) {
+			t.Errorf("%s: expected %s, got %v", data, expected, v)
+		}
+	}
+
+	if _, err := DecodeValue(json.RawMessage(`{"@type":"g:Date","@value":"yesterday"}`)); err == nil {
+		t.Error("Expected an error for a date which is not a number")
+	}
+}
+
+func TestDecodeBulkSet(t *testing.T) {
+	data := json.RawMessage(`{"@type":"g:BulkSet","@value":["marko",{"@type":"g:Int64","@value":2},{"@type":"g:Int32","@value":29},{"@type":"g:Int64","@value":1}]}`)
+	v, err := DecodeValue(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bulk, ok := v.(BulkSet)
+	if !ok {
+		t.Fatalf("Expected a BulkSet, got %T", v)
+	}
+	expected := BulkSet{{Value: "marko", Count: 2}, {Value: int32(29), Count: 1}}
+	if !reflect.DeepEqual(bulk, expected) {
+		t.Errorf("Expected %v, got %v", expected, bulk)
+	}
+	if bulk.Size() != 3 {
+		t.Errorf("Expected a size of 3, got %d", bulk.Size())
+	}
+	if expanded := bulk.Expand(); !reflect.DeepEqual(expanded, []interface{}{"marko", "marko", int32(29)}) {
+		t.Errorf("Unexpected expansion: %v", expanded)
+	}
+
+	var visited int
+	bulk.Range(func(value interface{}, count int64) bool {
+		visited++
+		return false
+	})
+	if visited != 1 {
+		t.Errorf("Expected Range to stop after the first value, visited %d", visited)
+	}
+}
+
+func TestDecodeBulkSetWithoutCount(t *testing.T) {
+	if _, err := DecodeValue(json.RawMessage(`{"@type":"g:BulkSet","@value":["marko"]}`)); err == nil {
+		t.Error("Expected a bulk set without a count to fail")
+	}
+}
+
+func TestToJSON(t *testing.T) {
+	resp := []Response{
+		{Result: Result{Data: json.RawMessage(`{"@type":"g:List","@value":[{"@type":"g:Map","@value":["name","marko",{"@type":"g:Int32","@value":1},{"@type":"g:UUID","@value":"1d6d02bd-8e56-421d-9438-3bd6d0079ff1"}]}]}`)}},
+		{Result: Result{Data: json.RawMessage(`{"@type":"g:List","@value":[{"@type":"g:BulkSet","@value":["a",{"@type":"g:Int64","@value":2}]}]}`)}},
+		{Result: Result{Data: json.RawMessage(`null`)}},
+	}
+
+	j, err := ToJSON(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"1":"1d6d02bd-8e56-421d-9438-3bd6d0079ff1","name":"marko"},["a","a"]]`; string(j) != want {
+		t.Errorf("Expected %s, got %s", want, j)
+	}
+
+	if j, err = ToJSON(nil); err != nil || string(j) != "[]" {
+		t.Errorf("Expected an empty array without results, got %s, %v", j, err)
+	}
+}
+
+func TestExecuteTyped(t *testing.T) {
+	c, fake := startFakeClient(t)
+	fake.respond = func(id string) []byte {
+		return []byte(`{"result":{"data":{"@type":"g:List","@value":[{"@type":"g:Vertex","@value":{"id":{"@type":"g:Int64","@value":1},"label":"person"}},{"@type":"g:Vertex","@value":{"id":{"@type":"g:Int64","@value":2},"label":"software"}}]},"meta":{}},"requestId":"` + id + `","status":{"code":200,"attributes":{},"message":""}}`)
+	}
+
+	type vertex struct {
+		ID    int64  `json:"id"`
+		Label string `json:"label"`
+	}
+	vertices, err := ExecuteTyped[vertex](c, "g.V()")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []vertex{{1, "person"}, {2, "software"}}
+	if !reflect.DeepEqual(vertices, expected) {
+		t.Errorf("Expected %v, got %v", expected, vertices)
+	}
+	if _, err := ExecuteTyped[int64](c, "g.V()"); err == nil {
+		t.Error("Expected an error decoding vertices into int64")
+	}
+}
+
+func TestExecuteTypedCount(t *testing.T) {
+	c, fake := startFakeClient(t)
+	fake.respond = func(id string) []byte {
+		return []byte(`{"result":{"data":{"@type":"g:List","@value":[{"@type":"g:Int64","@value":42}]},"meta":{}},"requestId":"` + id + `","status":{"code":200,"attributes":{},"message":""}}`)
+	}
+	counts, err := ExecuteTyped[int64](c, "g.V().count()")
+	if err != nil || !reflect.DeepEqual(counts, []int64{42}) {
+		t.Errorf("Expected [42], got %v, %v", counts, err)
+	}
+}
+
+func TestDecodeMap(t *testing.T) {
+	data := json.RawMessage(`{"@type":"g:Map","@value":["marko",{"@type":"g:Int64","@value":2},{"@type":"g:Int32","@value":29},"age"]}`)
+	v, err := DecodeValue(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[interface{}]interface{}{"marko": int64(2), int32(29): "age"}
+	if !reflect.DeepEqual(v, expected) {
+		t.Errorf("Expected %v, got %v", expected, v)
+	}
+
+	if _, err := ToStringKeyedMap(v.(map[interface{}]interface{})); err == nil {
+		t.Error("Expected the map with a numeric key not to convert")
+	}
+}
+
+func TestToStringKeyedMap(t *testing.T) {
+	m, err := ToStringKeyedMap(map[interface{}]interface{}{"marko": int64(2), "vadas": int64(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, map[string]interface{}{"marko": int64(2), "vadas": int64(1)}) {
+		t.Errorf("Unexpected conversion: %v", m)
+	}
+}
+
+func TestDecodeMapWithUnhashableKey(t *testing.T) {
+	data := json.RawMessage(`{"@type":"g:Map","@value":[{"@type":"g:List","@value":[1]},"list"]}`)
+	if _, err := DecodeValue(data); err == nil {
+		t.Error("Expected a list key to fail instead of panicking")
+	}
+}
@@ -35,7 +35,7 @@ func initClient() {
 	if err != nil {
 		fmt.Println(err)
 	}
-	g = &r
+	g = r
 }
 
 func initPool() {
@@ -48,7 +48,7 @@ func initPool() {
 		if err != nil {
 			log.Fatal(err)
 		}
-		return &c, err
+		return c, err
 	}
 	pool := Pool{
 		Dial:        dialFn,
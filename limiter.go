@@ -0,0 +1,80 @@
+package gremtune
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrConnectionLimit is returned by Get of a pool with FailWhenLimited while its Limiter has no free slot
+var ErrConnectionLimit = errors.New("the connection limit shared by the pools has been reached")
+
+// ConnectionLimiter bounds the number of live connections of all the pools sharing it, to bound the file
+// descriptors and memory used by a process talking to several graphs. A connection holds its slot from the moment
+// it is dialed until its pool closes it, idle connections included, so pools sharing a limiter should have an
+// IdleTimeout for the slots of unused connections to be freed.
+type ConnectionLimiter struct {
+	slots chan struct{}
+	mu    sync.Mutex
+	held  map[*Client]bool // held are the connections holding a slot
+}
+
+// NewConnectionLimiter returns a limiter allowing max live connections across the pools it is given to
+func NewConnectionLimiter(max int) *ConnectionLimiter {
+	return &ConnectionLimiter{slots: make(chan struct{}, max), held: make(map[*Client]bool)}
+}
+
+// Live returns the number of connections currently holding a slot, including those being dialed
+func (l *ConnectionLimiter) Live() int {
+	return len(l.slots)
+}
+
+// acquire takes a slot to dial a connection in, waiting for one until ctx is done when wait is set. It always
+// succeeds for a nil limiter.
+func (l *ConnectionLimiter) acquire(ctx context.Context, wait bool) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if !wait {
+		return ErrConnectionLimit
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "waiting for a connection slot")
+	}
+}
+
+// hold assigns the slot acquired for a dial to the connection it dialed, or frees it when the dial failed
+func (l *ConnectionLimiter) hold(c *Client, dialErr error) {
+	if l == nil {
+		return
+	}
+	if dialErr != nil {
+		<-l.slots
+		return
+	}
+	l.mu.Lock()
+	l.held[c] = true
+	l.mu.Unlock()
+}
+
+// release frees the slot of a closed connection. Connections closed more than once free it once.
+func (l *ConnectionLimiter) release(c *Client) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held[c] {
+		delete(l.held, c)
+		<-l.slots
+	}
+}
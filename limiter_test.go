@@ -0,0 +1,61 @@
+package gremtune
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func newLimitedPool(limiter *ConnectionLimiter) *Pool {
+	return &Pool{Limiter: limiter, Dial: func() (*Client, error) {
+		c := newClient()
+		c.conn = &fakeDialer{}
+		return &c, nil
+	}}
+}
+
+func TestConnectionLimiterSharedByPools(t *testing.T) {
+	limiter := NewConnectionLimiter(1)
+	a, b := newLimitedPool(limiter), newLimitedPool(limiter)
+
+	pc, err := a.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := b.GetContext(ctx); errors.Cause(err) != context.DeadlineExceeded {
+		t.Errorf("Expected Get to wait for a free slot until the context was done, got %v", err)
+	}
+	b.FailWhenLimited = true
+	if _, err := b.Get(); err != ErrConnectionLimit {
+		t.Errorf("Expected ErrConnectionLimit, got %v", err)
+	}
+
+	pc.Close() // The idle connection keeps its slot
+	if limiter.Live() != 1 {
+		t.Errorf("Expected the idle connection to hold its slot, got %d live", limiter.Live())
+	}
+	a.Close()
+	if limiter.Live() != 0 {
+		t.Errorf("Expected the closed pool to free its slots, got %d live", limiter.Live())
+	}
+	if _, err := b.Get(); err != nil {
+		t.Errorf("Expected a slot once the other pool was closed, got %v", err)
+	}
+}
+
+func TestConnectionLimiterFreesFailedDials(t *testing.T) {
+	limiter := NewConnectionLimiter(1)
+	p := &Pool{Limiter: limiter, Dial: func() (*Client, error) { return nil, errors.New("unreachable") }}
+	for i := 0; i < 2; i++ {
+		if _, err := p.Get(); err == nil || err == ErrConnectionLimit {
+			t.Errorf("Expected the dial error, got %v", err)
+		}
+	}
+	if limiter.Live() != 0 {
+		t.Errorf("Expected failed dials to free their slots, got %d live", limiter.Live())
+	}
+}
@@ -0,0 +1,40 @@
+package gremtune
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger is used by gremtune to report diagnostic events. Key value pairs describe the event.
+type Logger interface {
+	Info(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// stdLogger is the default Logger, it writes to the standard library logger
+type stdLogger struct{}
+
+func (stdLogger) Info(msg string, keyvals ...interface{}) {
+	log.Println(formatLog("INFO", msg, keyvals...))
+}
+
+func (stdLogger) Error(msg string, keyvals ...interface{}) {
+	log.Println(formatLog("ERROR", msg, keyvals...))
+}
+
+// formatLog renders a message and its key value pairs as a single log line
+func formatLog(level, msg string, keyvals ...interface{}) string {
+	var b strings.Builder
+	b.WriteString(level)
+	b.WriteString(" ")
+	b.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		if i+1 < len(keyvals) {
+			fmt.Fprintf(&b, " %v=%v", keyvals[i], keyvals[i+1])
+		} else {
+			fmt.Fprintf(&b, " %v=", keyvals[i])
+		}
+	}
+	return b.String()
+}
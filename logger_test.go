@@ -0,0 +1,11 @@
+package gremtune
+
+import "testing"
+
+func TestFormatLog(t *testing.T) {
+	got := formatLog("INFO", "Retrying connection with /gremlin suffix", "host", "ws://127.0.0.1:8182/gremlin", "dangling")
+	expected := "INFO Retrying connection with /gremlin suffix host=ws://127.0.0.1:8182/gremlin dangling="
+	if got != expected {
+		t.Errorf("Unexpected log line, expected: %s got: %s", expected, got)
+	}
+}
@@ -0,0 +1,34 @@
+package gremtune
+
+// Metric names reported to a MetricsCollector
+const (
+	MetricRequestBytes  = "gremtune_request_bytes"  // MetricRequestBytes is the size of every request frame written
+	MetricResponseBytes = "gremtune_response_bytes" // MetricResponseBytes is the size of every response frame received
+)
+
+// SizeBuckets are the upper bounds, in bytes, of the histogram buckets suited to the size metrics: 1KB, 10KB,
+// 100KB, 1MB and 10MB. Stats counts the frames per bucket, collectors can use them to configure their histograms.
+var SizeBuckets = []float64{1 << 10, 10 << 10, 100 << 10, 1 << 20, 10 << 20}
+
+// MetricsCollector receives the observations behind the metrics of a client, for export to Prometheus or another
+// metrics system. Observe is called from the workers of the client and must not block.
+type MetricsCollector interface {
+	Observe(metric string, value float64)
+}
+
+// observe reports a value to the metrics collector of the client, if any
+func (c *Client) observe(metric string, value float64) {
+	if c.metrics != nil {
+		c.metrics.Observe(metric, value)
+	}
+}
+
+// sizeBucket returns the index of the SizeBuckets bucket a size falls in, len(SizeBuckets) for larger sizes
+func sizeBucket(n int) int {
+	for i, bound := range SizeBuckets {
+		if float64(n) <= bound {
+			return i
+		}
+	}
+	return len(SizeBuckets)
+}
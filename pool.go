@@ -1,9 +1,11 @@
 package gremtune
 
 import (
-	"fmt"
+	"context"
 	"sync"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 // Pool maintains a list of connections.
@@ -11,19 +13,50 @@ type Pool struct {
 	Dial        func() (*Client, error)
 	MaxActive   int
 	IdleTimeout time.Duration
-	mu          sync.Mutex
-	idle        []*idleConnection
-	active      int
-	cond        *sync.Cond
-	closed      bool
+	Balancer    Balancer // Balancer picks the idle connection to reuse, the most recently used one when nil
+	// MinIdle is the number of connected idle connections the pool maintains in the background from the first
+	// Get on, closing dead connections and dialing new ones every RepairInterval. 0 disables the repair.
+	MinIdle        int
+	RepairInterval time.Duration // RepairInterval defaults to 10 seconds
+	// MaxErrorsBeforeEviction is the number of requests in a row which may fail on a connection before it is closed
+	// rather than reused, see PooledConnection.Release. It defaults to 3.
+	MaxErrorsBeforeEviction int
+	// Limiter bounds the live connections of this pool together with the other pools sharing it. Get waits for a
+	// slot to dial a connection in, or fails with ErrConnectionLimit when FailWhenLimited is set.
+	Limiter         *ConnectionLimiter
+	FailWhenLimited bool
+	errorCounts     map[*Client]int // errorCounts counts the failed requests in a row of each connection
+	repairOnce      sync.Once
+	stopRepair      chan struct{}
+	mu              sync.Mutex
+	idle            []*idleConnection
+	active          int
+	waiters         []*poolWaiter // waiters wait in order for a connection while MaxActive are active
+	closed          bool
+	sessions        sync.Map // sessions pins a pooled connection to each session id
+	clock           clock    // clock times the repair and idle connections, the real clock when nil
 }
 
+// ErrSessionLost is returned for a session whose pinned connection is no longer connected. Sessions only live on
+// the server they were opened on, so the session cannot be continued on another connection.
+var ErrSessionLost = errors.New("the connection of the session has been lost")
+
+// ErrNoConnection is returned by GetDedicated when no pooled connection is connected
+var ErrNoConnection = errors.New("no connected pooled connection is available")
+
 // PooledConnection represents a shared and reusable connection.
 type PooledConnection struct {
 	Pool   *Pool
 	Client *Client
 }
 
+// poolWaiter is a caller of GetContext waiting for a connection to be handed over or a slot to dial one in
+type poolWaiter struct {
+	ctx context.Context
+	// ready receives the connection released to the waiter, or nil once a slot became free to try again in
+	ready chan *PooledConnection
+}
+
 type idleConnection struct {
 	pc *PooledConnection
 	// t is the time the connection was idled
@@ -34,19 +67,29 @@ type idleConnection struct {
 // by dialing a new one if the pool does not currently have a maximum number
 // of active connections.
 func (p *Pool) Get() (*PooledConnection, error) {
+	return p.GetContext(context.Background())
+}
+
+// GetContext is like Get, but gives up waiting for a connection to become available when ctx is done.
+func (p *Pool) GetContext(ctx context.Context) (*PooledConnection, error) {
+	if p.MinIdle > 0 {
+		p.repairOnce.Do(p.startRepair)
+	}
+
 	// Lock the pool to keep the kids out.
 	p.mu.Lock()
 
 	// Clean this place up.
-	p.purge()
+	defer p.closeClients(p.purge())
 
 	// Wait loop
 	for {
-		// Try to grab first available idle connection
-		if conn := p.first(); conn != nil {
+		// Try to grab an available idle connection
+		if i := p.next(); i >= 0 {
+			conn := p.idle[i]
 
 			// Remove the connection from the idle slice
-			p.idle = append(p.idle[:0], p.idle[1:]...)
+			p.idle = append(p.idle[:i], p.idle[i+1:]...)
 			p.active++
 			p.mu.Unlock()
 			pc := &PooledConnection{Pool: p, Client: conn.pc.Client}
@@ -63,7 +106,14 @@ func (p *Pool) Get() (*PooledConnection, error) {
 			// dialed do not have to wait.
 			p.mu.Unlock()
 
+			if err := p.Limiter.acquire(ctx, !p.FailWhenLimited); err != nil {
+				p.mu.Lock()
+				p.release()
+				p.mu.Unlock()
+				return nil, err
+			}
 			dc, err := dial()
+			p.Limiter.hold(dc, err)
 			if err != nil {
 				p.mu.Lock()
 				p.release()
@@ -76,36 +126,230 @@ func (p *Pool) Get() (*PooledConnection, error) {
 		}
 
 		//No idle connections and max active connections, let's wait.
-		if p.cond == nil {
-			p.cond = sync.NewCond(&p.mu)
+		if err := ctx.Err(); err != nil {
+			p.mu.Unlock()
+			return nil, errors.Wrap(err, "waiting for a pooled connection")
 		}
+		w := &poolWaiter{ctx: ctx, ready: make(chan *PooledConnection, 1)}
+		p.waiters = append(p.waiters, w)
+		p.mu.Unlock()
 
-		p.cond.Wait()
+		select {
+		case pc := <-w.ready:
+			if pc != nil {
+				return pc, nil
+			}
+			p.mu.Lock()
+		case <-ctx.Done():
+			p.mu.Lock()
+			p.removeWaiter(w)
+			select {
+			case pc := <-w.ready: // Released to the waiter before it was removed
+				if pc != nil {
+					p.mu.Unlock()
+					return pc, nil
+				}
+				p.wake() // Passes the free slot on
+			default:
+			}
+			p.mu.Unlock()
+			return nil, errors.Wrap(ctx.Err(), "waiting for a pooled connection")
+		}
 	}
 }
 
-// put pushes the supplied PooledConnection to the top of the idle slice to be reused.
+// GetWithContext is like GetContext, but returns the client of the pooled connection. Hand the client back to the
+// pool with Put once done with it.
+func (p *Pool) GetWithContext(ctx context.Context) (*Client, error) {
+	pc, err := p.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pc.Client, nil
+}
+
+// Put returns a client obtained from GetWithContext to the pool, handing it straight to the first caller still
+// waiting for a connection.
+func (p *Pool) Put(c *Client) {
+	(&PooledConnection{Pool: p, Client: c}).Close()
+}
+
+// nextWaiter removes and returns the first waiter whose context is not done, or nil when there is none. Waiters
+// which gave up are skipped.
 // It is not threadsafe. The caller should manage locking the pool.
-func (p *Pool) put(pc *PooledConnection) {
+func (p *Pool) nextWaiter() *poolWaiter {
+	for len(p.waiters) > 0 {
+		w := p.waiters[0]
+		p.waiters = p.waiters[1:]
+		if w.ctx.Err() == nil {
+			return w
+		}
+	}
+	return nil
+}
+
+// removeWaiter removes a waiter which gave up.
+// It is not threadsafe. The caller should manage locking the pool.
+func (p *Pool) removeWaiter(w *poolWaiter) {
+	for i, waiting := range p.waiters {
+		if waiting == w {
+			p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// handOver gives a released connection to the next waiter, which keeps it active, and reports whether there was
+// one.
+// It is not threadsafe. The caller should manage locking the pool.
+func (p *Pool) handOver(pc *PooledConnection) bool {
 	if p.closed {
-		pc.Client.Close()
+		return false
+	}
+	if w := p.nextWaiter(); w != nil {
+		w.ready <- &PooledConnection{Pool: p, Client: pc.Client}
+		return true
+	}
+	return false
+}
+
+// wake lets the next waiter try again, after a connection became idle or a slot to dial one became free.
+// It is not threadsafe. The caller should manage locking the pool.
+func (p *Pool) wake() {
+	if w := p.nextWaiter(); w != nil {
+		w.ready <- nil
+	}
+}
+
+const defaultRepairInterval = 10 * time.Second
+
+// startRepair starts the goroutine maintaining MinIdle connected idle connections, until the pool is closed
+func (p *Pool) startRepair() {
+	interval := p.RepairInterval
+	if interval <= 0 {
+		interval = defaultRepairInterval
+	}
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.stopRepair = make(chan struct{})
+	stop := p.stopRepair
+	p.mu.Unlock()
+
+	go func() {
+		ticker := orRealClock(p.clock).NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			p.repair()
+			select {
+			case <-ticker.C():
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// repair closes the idle connections which are no longer connected and dials new ones until there are MinIdle
+// idle connections, within MaxActive.
+func (p *Pool) repair() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	var broken []*Client
+	healthy := p.idle[:0]
+	for _, v := range p.idle {
+		if isConnected(v.pc.Client) {
+			healthy = append(healthy, v)
+		} else {
+			delete(p.errorCounts, v.pc.Client)
+			broken = append(broken, v.pc.Client)
+		}
+	}
+	p.idle = healthy
+	defer p.closeClients(broken)
+
+	missing := p.MinIdle - len(p.idle)
+	if p.MaxActive > 0 && missing > p.MaxActive-p.active-len(p.idle) {
+		missing = p.MaxActive - p.active - len(p.idle)
+	}
+	if missing <= 0 {
+		p.mu.Unlock()
 		return
 	}
-	idle := &idleConnection{pc: pc, t: time.Now()}
+	p.active += missing // Reserved while dialing, so Get does not exceed MaxActive
+	dial := p.Dial
+	p.mu.Unlock()
+
+	for i := 0; i < missing; i++ {
+		err := p.Limiter.acquire(context.Background(), false) // The repair never waits for other pools
+		var c *Client
+		if err == nil {
+			c, err = dial()
+			p.Limiter.hold(c, err)
+		}
+		p.mu.Lock()
+		kept := err == nil && p.put(&PooledConnection{Pool: p, Client: c})
+		p.release()
+		p.mu.Unlock()
+		if err == nil && !kept {
+			p.closeClients([]*Client{c})
+		}
+	}
+}
+
+const defaultMaxErrorsBeforeEviction = 3
+
+// recordOutcome counts a failed request of the connection, or resets the count when err is nil, and reports
+// whether the connection failed too often to be reused.
+// It is not threadsafe. The caller should manage locking the pool.
+func (p *Pool) recordOutcome(c *Client, err error) (evict bool) {
+	if err == nil {
+		delete(p.errorCounts, c)
+		return false
+	}
+	max := p.MaxErrorsBeforeEviction
+	if max <= 0 {
+		max = defaultMaxErrorsBeforeEviction
+	}
+	if p.errorCounts == nil {
+		p.errorCounts = make(map[*Client]int)
+	}
+	p.errorCounts[c]++
+	if p.errorCounts[c] < max {
+		return false
+	}
+	delete(p.errorCounts, c)
+	return true
+}
+
+// put pushes the supplied PooledConnection to the top of the idle slice to be reused. It reports false when the
+// pool is closed, the caller then closes the connection once the pool is unlocked.
+// It is not threadsafe. The caller should manage locking the pool.
+func (p *Pool) put(pc *PooledConnection) bool {
+	if p.closed {
+		return false
+	}
+	idle := &idleConnection{pc: pc, t: orRealClock(p.clock).Now()}
 	// Prepend the connection to the front of the slice
 	p.idle = append([]*idleConnection{idle}, p.idle...)
-
+	return true
 }
 
-// purge removes expired idle connections from the pool.
+// purge removes expired idle connections from the pool and returns the ones to close once the pool is unlocked.
 // It is not threadsafe. The caller should manage locking the pool.
-func (p *Pool) purge() {
+func (p *Pool) purge() (expired []*Client) {
 	if timeout := p.IdleTimeout; timeout > 0 {
 		var valid []*idleConnection
-		now := time.Now()
+		now := orRealClock(p.clock).Now()
 		for _, v := range p.idle {
 			// If the client has an error then exclude it from the pool
-			if v.pc.Client.Errored {
+			if v.pc.Client.IsErrored() {
+				delete(p.errorCounts, v.pc.Client)
 				continue
 			}
 
@@ -113,11 +357,22 @@ func (p *Pool) purge() {
 				valid = append(valid, v)
 			} else {
 				// Force underlying connection closed
-				v.pc.Client.Close()
+				delete(p.errorCounts, v.pc.Client)
+				expired = append(expired, v.pc.Client)
 			}
 		}
 		p.idle = valid
 	}
+	return
+}
+
+// closeClients closes the connections removed from the pool, freeing their slots of the Limiter. Closing waits
+// for the workers of a connection, so it is done without holding the lock of the pool.
+func (p *Pool) closeClients(clients []*Client) {
+	for _, c := range clients {
+		c.Close()
+		p.Limiter.release(c)
+	}
 }
 
 // release decrements active and alerts waiters.
@@ -127,10 +382,26 @@ func (p *Pool) release() {
 		return
 	}
 	p.active--
-	if p.cond != nil {
-		p.cond.Signal()
-	}
+	p.wake()
+}
 
+// next returns the index of the idle connection to reuse, or -1 when there is none.
+// It is not threadsafe. The caller should manage locking the pool.
+func (p *Pool) next() int {
+	if len(p.idle) == 0 {
+		return -1
+	}
+	if p.Balancer == nil {
+		return 0
+	}
+	idle := make([]*PooledConnection, len(p.idle))
+	for i, v := range p.idle {
+		idle[i] = v.pc
+	}
+	if i := p.Balancer.Select(idle); i >= 0 && i < len(idle) {
+		return i
+	}
+	return 0
 }
 
 func (p *Pool) first() *idleConnection {
@@ -140,45 +411,181 @@ func (p *Pool) first() *idleConnection {
 	return p.idle[0]
 }
 
+// GetDedicated returns a connected pooled connection which is not handed out to anyone else until it is closed,
+// for requests which must all reach the same server, such as those of a session. Idle connections which are no
+// longer connected are closed rather than handed out.
+func (p *Pool) GetDedicated() (*PooledConnection, error) {
+	p.mu.Lock()
+	attempts := len(p.idle) + 1
+	p.mu.Unlock()
+
+	for i := 0; i < attempts; i++ {
+		pc, err := p.Get()
+		if err != nil {
+			return nil, err
+		}
+		if isConnected(pc.Client) {
+			return pc, nil
+		}
+		p.discard(pc)
+	}
+	return nil, ErrNoConnection
+}
+
+// discard closes a connection taken from the pool instead of returning it
+func (p *Pool) discard(pc *PooledConnection) {
+	p.mu.Lock()
+	p.release()
+	p.mu.Unlock()
+	p.closeClients([]*Client{pc.Client})
+}
+
+// GetForSession returns the client pinned to the session, pinning a connection from the pool on first use.
+// The connection is not handed out to anyone else until ReleaseSession is called.
+func (p *Pool) GetForSession(sessionID string) (*Client, error) {
+	if pinned, ok := p.sessions.Load(sessionID); ok {
+		return sessionClient(pinned.(*PooledConnection))
+	}
+
+	pc, err := p.GetDedicated()
+	if err != nil {
+		return nil, err
+	}
+	if pinned, loaded := p.sessions.LoadOrStore(sessionID, pc); loaded { // Pinned concurrently
+		pc.Close()
+		return sessionClient(pinned.(*PooledConnection))
+	}
+	return pc.Client, nil
+}
+
+func sessionClient(pc *PooledConnection) (*Client, error) {
+	if !isConnected(pc.Client) {
+		return nil, ErrSessionLost
+	}
+	return pc.Client, nil
+}
+
+func isConnected(c *Client) bool {
+	return !c.IsErrored() && c.conn != nil && !c.conn.IsDisposed() && c.conn.IsConnected()
+}
+
+// ReleaseSession unpins the connection of the session and returns it to the pool.
+func (p *Pool) ReleaseSession(sessionID string) {
+	if pinned, ok := p.sessions.LoadAndDelete(sessionID); ok {
+		pinned.(*PooledConnection).Close()
+	}
+}
+
+// NewSession opens a session on a connection pinned for it. Closing the session releases the connection.
+func (p *Pool) NewSession(model TransactionModel, configs ...SessionConfig) (*Session, error) {
+	id := newRequestID()
+	c, err := p.GetForSession(id)
+	if err != nil {
+		return nil, err
+	}
+	s := newSession(c, id, model, configs)
+	s.release = func() { p.ReleaseSession(id) }
+	return s, nil
+}
+
 // Close closes the pool.
 func (p *Pool) Close() {
 	p.mu.Lock()
-	defer p.mu.Unlock()
-
+	idle := make([]*Client, 0, len(p.idle))
 	for _, c := range p.idle {
-		c.pc.Client.Close()
+		idle = append(idle, c.pc.Client)
 	}
 	p.closed = true
+	if p.stopRepair != nil {
+		close(p.stopRepair)
+	}
+	p.mu.Unlock()
+
+	p.closeClients(idle)
 }
 
 // ExecuteWithBindings formats a raw Gremlin query, sends it to Gremlin Server, and returns the result.
 func (p *Pool) ExecuteWithBindings(query string, bindings, rebindings map[string]string) (resp []Response, err error) {
 	pc, err := p.Get()
 	if err != nil {
-		fmt.Printf("Error aquiring connection from pool: %s", err)
-		return nil, err
+		return nil, errors.Wrap(err, "acquiring connection from pool")
 	}
-	defer pc.Close()
+	defer func() { pc.Release(err) }()
 	return pc.Client.ExecuteWithBindings(query, bindings, rebindings)
 }
 
+// ExecuteWithTypedBindings grabs a connection from the pool, formats a raw Gremlin query, sends it to Gremlin Server with bindings of any type, and returns the result.
+func (p *Pool) ExecuteWithTypedBindings(query string, bindings map[string]interface{}, rebindings map[string]string) (resp []Response, err error) {
+	pc, err := p.Get()
+	if err != nil {
+		return nil, errors.Wrap(err, "acquiring connection from pool")
+	}
+	defer func() { pc.Release(err) }()
+	return pc.Client.ExecuteWithTypedBindings(query, bindings, rebindings)
+}
+
 // Execute grabs a connection from the pool, formats a raw Gremlin query, sends it to Gremlin Server, and returns the result.
 func (p *Pool) Execute(query string) (resp []Response, err error) {
 	pc, err := p.Get()
 	if err != nil {
-		fmt.Printf("Error aquiring connection from pool: %s", err)
-		return nil, err
+		return nil, errors.Wrap(err, "acquiring connection from pool")
 	}
-	defer pc.Close()
+	defer func() { pc.Release(err) }()
 	return pc.Client.Execute(query)
 }
 
+// ExecuteTraversal grabs a connection from the pool, builds the Traversal into a parameterized Gremlin query, sends it to Gremlin Server, and returns the result.
+func (p *Pool) ExecuteTraversal(t *Traversal) (resp []Response, err error) {
+	pc, err := p.Get()
+	if err != nil {
+		return nil, errors.Wrap(err, "acquiring connection from pool")
+	}
+	defer func() { pc.Release(err) }()
+	return pc.Client.ExecuteTraversal(t)
+}
+
+// Release returns the connection to the pool like Close, recording whether the last request made on it failed.
+// A connection whose requests failed MaxErrorsBeforeEviction times in a row is closed instead of being reused,
+// and replaced right away when the pool maintains MinIdle connections.
+func (pc *PooledConnection) Release(err error) {
+	p := pc.Pool
+	p.mu.Lock()
+	if !p.recordOutcome(pc.Client, err) {
+		if p.handOver(pc) {
+			p.mu.Unlock()
+			return
+		}
+		kept := p.put(pc)
+		p.release()
+		p.mu.Unlock()
+		if !kept {
+			p.closeClients([]*Client{pc.Client})
+		}
+		return
+	}
+	p.release()
+	replace := p.MinIdle > 0 && !p.closed
+	p.mu.Unlock()
+
+	p.closeClients([]*Client{pc.Client})
+	if replace {
+		go p.repair()
+	}
+}
+
 // Close signals that the caller is finished with the connection and should be
 // returned to the pool for future use.
 func (pc *PooledConnection) Close() {
 	pc.Pool.mu.Lock()
-	defer pc.Pool.mu.Unlock()
-
-	pc.Pool.put(pc)
+	if pc.Pool.handOver(pc) {
+		pc.Pool.mu.Unlock()
+		return
+	}
+	kept := pc.Pool.put(pc)
 	pc.Pool.release()
+	pc.Pool.mu.Unlock()
+
+	if !kept {
+		pc.Pool.closeClients([]*Client{pc.Client})
+	}
 }
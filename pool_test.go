@@ -1,8 +1,12 @@
 package gremtune
 
 import (
+	"context"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 func TestPurge(t *testing.T) {
@@ -189,3 +193,374 @@ func TestGetAndDial(t *testing.T) {
 		t.Errorf("Expected 1 active connection, got %d", pool.active)
 	}
 }
+
+func TestGetWithBalancer(t *testing.T) {
+	pool := &Pool{Balancer: LeastLatency{}}
+
+	slow := &Client{}
+	slow.recordLatency(50 * time.Millisecond)
+	fast := &Client{}
+	fast.recordLatency(5 * time.Millisecond)
+
+	pool.idle = []*idleConnection{
+		&idleConnection{t: time.Now(), pc: &PooledConnection{Pool: pool, Client: slow}},
+		&idleConnection{t: time.Now(), pc: &PooledConnection{Pool: pool, Client: fast}},
+	}
+
+	conn, err := pool.Get()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if conn.Client != fast {
+		t.Error("Expected the connection with the lowest latency to be returned")
+	}
+
+	if len(pool.idle) != 1 || pool.idle[0].pc.Client != slow {
+		t.Error("Expected the slow connection to remain idle")
+	}
+}
+
+func TestGetWithLeastConnections(t *testing.T) {
+	pool := &Pool{Balancer: LeastConnections{}}
+
+	busy := &Client{stats: newClientStats()}
+	busy.requestStarted()
+	busy.requestStarted()
+	quiet := &Client{stats: newClientStats()}
+	quiet.requestStarted()
+
+	pool.idle = []*idleConnection{
+		&idleConnection{t: time.Now(), pc: &PooledConnection{Pool: pool, Client: busy}},
+		&idleConnection{t: time.Now(), pc: &PooledConnection{Pool: pool, Client: quiet}},
+	}
+
+	conn, err := pool.Get()
+	if err != nil {
+		t.Error(err)
+	}
+
+	if conn.Client != quiet {
+		t.Error("Expected the connection with the fewest requests in flight to be returned")
+	}
+}
+
+func TestGetForSession(t *testing.T) {
+	pool := &Pool{}
+	var dialed []*Client
+	pool.Dial = func() (*Client, error) {
+		c := newClient()
+		c.conn = &fakeDialer{}
+		dialed = append(dialed, &c)
+		return &c, nil
+	}
+
+	first, err := pool.GetForSession("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := pool.GetForSession("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := pool.GetForSession("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first != again {
+		t.Error("Expected the same client to be returned for the same session")
+	}
+	if first == other || pool.active != 2 {
+		t.Error("Expected each session to pin its own connection")
+	}
+
+	first.Errored = true
+	if _, err := pool.GetForSession("a"); err != ErrSessionLost {
+		t.Errorf("Expected ErrSessionLost, got: %v", err)
+	}
+
+	pool.ReleaseSession("a")
+	if pool.active != 1 || len(pool.idle) != 1 {
+		t.Errorf("Expected the session connection to be returned to the pool, got %d active", pool.active)
+	}
+}
+
+func TestGetDedicated(t *testing.T) {
+	pool := &Pool{}
+	pool.Dial = func() (*Client, error) {
+		c := newClient()
+		c.conn = &fakeDialer{}
+		return &c, nil
+	}
+
+	lost, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lost.Client.Errored = true
+	lost.Close()
+
+	dedicated, err := pool.GetDedicated()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dedicated.Client == lost.Client {
+		t.Error("Expected the idle connection which lost its connection to be skipped")
+	}
+	if pool.active != 1 || len(pool.idle) != 0 {
+		t.Errorf("Expected only the dedicated connection to be active, got %d active and %d idle", pool.active, len(pool.idle))
+	}
+
+	other, err := pool.GetDedicated()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Client == dedicated.Client {
+		t.Error("Expected the dedicated connection not to be handed out again before it is closed")
+	}
+}
+
+func TestGetDedicatedWithoutConnection(t *testing.T) {
+	pool := &Pool{Dial: func() (*Client, error) { return &Client{}, nil }}
+	if _, err := pool.GetDedicated(); err != ErrNoConnection {
+		t.Errorf("Expected ErrNoConnection, got %v", err)
+	}
+	if pool.active != 0 {
+		t.Errorf("Expected the unconnected connection to be released, got %d active", pool.active)
+	}
+}
+
+func TestRepair(t *testing.T) {
+	dials := 0
+	pool := &Pool{MinIdle: 2, MaxActive: 4}
+	pool.Dial = func() (*Client, error) {
+		dials++
+		c := newClient()
+		c.conn = &fakeDialer{}
+		return &c, nil
+	}
+	dead := &Client{Errored: true}
+	pool.idle = []*idleConnection{{pc: &PooledConnection{Pool: pool, Client: dead}, t: time.Now()}}
+
+	pool.repair()
+	if len(pool.idle) != 2 || pool.active != 0 || dials != 2 {
+		t.Fatalf("Expected the dead connection to be replaced by 2 new ones, got %d idle, %d active after %d dials", len(pool.idle), pool.active, dials)
+	}
+	for _, v := range pool.idle {
+		if v.pc.Client == dead {
+			t.Error("Expected the dead connection to be removed")
+		}
+	}
+
+	pool.idle = pool.idle[:1]
+	pool.active = 3
+	pool.repair()
+	if len(pool.idle) != 1 || pool.active != 3 {
+		t.Errorf("Expected the repair to stay within MaxActive, got %d idle and %d active", len(pool.idle), pool.active)
+	}
+}
+
+func TestRepairInBackground(t *testing.T) {
+	dialed := make(chan struct{}, 10)
+	pool := &Pool{MinIdle: 1, RepairInterval: 10 * time.Millisecond}
+	pool.Dial = func() (*Client, error) {
+		dialed <- struct{}{}
+		c := newClient()
+		c.conn = &fakeDialer{}
+		return &c, nil
+	}
+
+	pc, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-dialed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected a connection to be dialed")
+	}
+	select {
+	case <-dialed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the background repair to dial an idle connection")
+	}
+
+	pc.Close()
+	pool.Close()
+	select {
+	case <-pool.stopRepair:
+	default:
+		t.Error("Expected closing the pool to stop the repair")
+	}
+}
+
+func TestGetContextTimeout(t *testing.T) {
+	pool := &Pool{MaxActive: 1, Dial: func() (*Client, error) { return &Client{}, nil }}
+	if _, err := pool.Get(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := pool.GetContext(ctx); errors.Cause(err) != context.DeadlineExceeded {
+		t.Errorf("Expected the deadline to be exceeded, got %v", err)
+	}
+}
+
+func TestGetContextWaitsForRelease(t *testing.T) {
+	pool := &Pool{MaxActive: 1, Dial: func() (*Client, error) { return &Client{}, nil }}
+	conn, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.AfterFunc(10*time.Millisecond, conn.Close)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	reused, err := pool.GetContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reused.Client != conn.Client {
+		t.Error("Expected the released connection to be reused")
+	}
+}
+
+func TestPutSkipsCancelledWaiters(t *testing.T) {
+	pool := &Pool{MaxActive: 1, Dial: func() (*Client, error) { return &Client{}, nil }}
+	c, err := pool.GetWithContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	gone := &poolWaiter{ctx: cancelled, ready: make(chan *PooledConnection, 1)}
+	pool.waiters = []*poolWaiter{gone}
+
+	got := make(chan *Client, 1)
+	go func() {
+		waiting, _ := pool.GetWithContext(context.Background())
+		got <- waiting
+	}()
+	for waiting := 0; waiting < 2; {
+		time.Sleep(time.Millisecond)
+		pool.mu.Lock()
+		waiting = len(pool.waiters)
+		pool.mu.Unlock()
+	}
+
+	pool.Put(c)
+	select {
+	case waiting := <-got:
+		if waiting != c {
+			t.Error("Expected the connection to be handed to the waiting caller")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the waiting caller to get the connection")
+	}
+	if len(gone.ready) != 0 {
+		t.Error("Expected the cancelled waiter to be skipped")
+	}
+	if pool.active != 1 || len(pool.idle) != 0 {
+		t.Errorf("Expected the handed over connection to stay active, got %d active and %d idle", pool.active, len(pool.idle))
+	}
+}
+
+func TestGetWithContextCancelled(t *testing.T) {
+	pool := &Pool{MaxActive: 1, Dial: func() (*Client, error) { return &Client{}, nil }}
+	if _, err := pool.GetWithContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, err := pool.GetWithContext(ctx); errors.Cause(err) != context.Canceled {
+		t.Errorf("Expected the wait to be cancelled, got %v", err)
+	}
+	if len(pool.waiters) != 0 {
+		t.Errorf("Expected the cancelled waiter to be removed, got %d waiters", len(pool.waiters))
+	}
+}
+
+// closeBlockingDialer is a fakeDialer whose close blocks until released
+type closeBlockingDialer struct {
+	fakeDialer
+	closing, release chan struct{}
+}
+
+func (d *closeBlockingDialer) close() error {
+	close(d.closing)
+	<-d.release
+	return nil
+}
+
+func TestCloseUnlocksBeforeClosingConnections(t *testing.T) {
+	pool := &Pool{}
+	dialer := &closeBlockingDialer{closing: make(chan struct{}), release: make(chan struct{})}
+	c := newClient()
+	c.conn = dialer
+	pool.idle = []*idleConnection{{pc: &PooledConnection{Pool: pool, Client: &c}, t: time.Now()}}
+
+	done := make(chan struct{})
+	go func() {
+		pool.Close()
+		close(done)
+	}()
+	<-dialer.closing
+
+	locked := make(chan struct{})
+	go func() {
+		pool.mu.Lock()
+		pool.mu.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Error("Expected the pool to be unlocked while its connections are closed")
+	}
+	close(dialer.release)
+	<-done
+}
+
+func TestReleaseEvictsFailingConnection(t *testing.T) {
+	pool := &Pool{MaxErrorsBeforeEviction: 2}
+	pool.Dial = func() (*Client, error) {
+		c := newClient()
+		c.conn = &fakeDialer{}
+		return &c, nil
+	}
+	failed := errors.New("bad pod")
+
+	pc, _ := pool.Get()
+	bad := pc.Client
+	pc.Release(failed)
+	pc, _ = pool.Get()
+	pc.Release(nil) // A success resets the count
+	pc, _ = pool.Get()
+	pc.Release(failed)
+	if len(pool.idle) != 1 || pool.idle[0].pc.Client != bad {
+		t.Fatal("Expected the connection to be reused while failing less than twice in a row")
+	}
+
+	pc, _ = pool.Get()
+	pc.Release(failed)
+	if len(pool.idle) != 0 || pool.active != 0 {
+		t.Errorf("Expected the connection to be evicted, got %d idle and %d active", len(pool.idle), pool.active)
+	}
+	if pc, _ = pool.Get(); pc.Client == bad {
+		t.Error("Expected a new connection to be dialed after the eviction")
+	}
+}
+
+func TestExecuteReturnsAcquireError(t *testing.T) {
+	dialErr := errors.New("connection refused")
+	pool := &Pool{Dial: func() (*Client, error) { return nil, dialErr }}
+
+	_, err := pool.Execute("g.V()")
+	if errors.Cause(err) != dialErr || !strings.Contains(err.Error(), "acquiring connection from pool") {
+		t.Errorf("Expected the dial error to be returned, got %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package gremtune
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ProfileResult is the TraversalMetrics returned by a traversal ending with the profile() step
+type ProfileResult struct {
+	Duration time.Duration // Duration is the time the whole traversal took
+	Steps    []StepMetrics
+}
+
+// StepMetrics are the metrics of a single step of a profiled traversal
+type StepMetrics struct {
+	ID              string
+	Name            string // Name describes the step, such as TinkerGraphStep(vertex,[])
+	Duration        time.Duration
+	TraverserCount  int64
+	ElementCount    int64
+	Counts          map[string]int64 // Counts are all the counts of the step, the traverser and element counts included
+	PercentDuration float64          // PercentDuration is the share of the duration of the traversal spent in the step
+	Annotations     map[string]interface{}
+	Nested          []StepMetrics // Nested are the metrics of the steps of child traversals
+}
+
+// UnmarshalProfile decodes the result of a traversal ending with the profile() step, such as
+// g.V().out('knows').profile(), into its step metrics. Durations are sent in milliseconds.
+func UnmarshalProfile(responses []Response) (*ProfileResult, error) {
+	for _, r := range responses {
+		if len(r.Result.Data) == 0 || string(r.Result.Data) == "null" {
+			continue
+		}
+		v, err := DecodeValue(r.Result.Data)
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding profile")
+		}
+		if items, isList := v.([]interface{}); isList {
+			if len(items) == 0 {
+				continue
+			}
+			v = items[0]
+		}
+		metrics, ok := stringKeyed(v)
+		if !ok {
+			return nil, errors.Errorf("profile result of type %T is not a map of traversal metrics", v)
+		}
+		steps, err := decodeStepMetrics(metrics["metrics"])
+		if err != nil {
+			return nil, err
+		}
+		return &ProfileResult{Duration: milliseconds(metrics["dur"]), Steps: steps}, nil
+	}
+	return nil, errors.New("the result holds no profile")
+}
+
+// decodeStepMetrics decodes a list of g:Metrics
+func decodeStepMetrics(v interface{}) ([]StepMetrics, error) {
+	if v == nil {
+		return nil, nil
+	}
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, errors.Errorf("step metrics of type %T are not a list", v)
+	}
+	steps := make([]StepMetrics, 0, len(items))
+	for _, item := range items {
+		fields, ok := stringKeyed(item)
+		if !ok {
+			return nil, errors.Errorf("step metrics of type %T are not a map", item)
+		}
+		step := StepMetrics{Duration: milliseconds(fields["dur"]), Counts: make(map[string]int64)}
+		step.ID, _ = fields["id"].(string)
+		step.Name, _ = fields["name"].(string)
+		if counts, ok := stringKeyed(fields["counts"]); ok {
+			for k, count := range counts {
+				if n, ok := countValue(count); ok {
+					step.Counts[k] = n
+				}
+			}
+		}
+		step.TraverserCount, step.ElementCount = step.Counts["traverserCount"], step.Counts["elementCount"]
+		step.Annotations, _ = stringKeyed(fields["annotations"])
+		step.PercentDuration = floatValue(step.Annotations["percentDur"])
+		nested, err := decodeStepMetrics(fields["metrics"])
+		if err != nil {
+			return nil, err
+		}
+		step.Nested = nested
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// stringKeyed returns a decoded g:Map with string keys or a decoded JSON object as a map[string]interface{}
+func stringKeyed(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		converted, err := ToStringKeyedMap(m)
+		return converted, err == nil
+	}
+	return nil, false
+}
+
+// floatValue returns a decoded number as a float64, 0 for any other value
+func floatValue(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case float32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case int32:
+		return float64(n)
+	}
+	return 0
+}
+
+// milliseconds converts a decoded number of milliseconds into a time.Duration
+func milliseconds(v interface{}) time.Duration {
+	return time.Duration(floatValue(v) * float64(time.Millisecond))
+}
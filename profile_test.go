@@ -0,0 +1,52 @@
+package gremtune
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+var dummyProfile = json.RawMessage(`{"@type":"g:List","@value":[{"@type":"g:TraversalMetrics","@value":{"@type":"g:Map","@value":[
+  "dur",{"@type":"g:Double","@value":1.5},
+  "metrics",{"@type":"g:List","@value":[
+    {"@type":"g:Metrics","@value":{"@type":"g:Map","@value":[
+      "dur",{"@type":"g:Double","@value":0.5},
+      "counts",{"@type":"g:Map","@value":["traverserCount",{"@type":"g:Int64","@value":6},"elementCount",{"@type":"g:Int64","@value":6}]},
+      "name","TinkerGraphStep(vertex,[])",
+      "annotations",{"@type":"g:Map","@value":["percentDur",{"@type":"g:Double","@value":33.3}]},
+      "id","0.0.0()"]}},
+    {"@type":"g:Metrics","@value":{"@type":"g:Map","@value":[
+      "dur",{"@type":"g:Double","@value":1.0},
+      "counts",{"@type":"g:Map","@value":["traverserCount",{"@type":"g:Int64","@value":2},"elementCount",{"@type":"g:Int64","@value":3}]},
+      "name","VertexStep(OUT,vertex)",
+      "annotations",{"@type":"g:Map","@value":["percentDur",{"@type":"g:Double","@value":66.7}]},
+      "id","1.0.0()",
+      "metrics",{"@type":"g:List","@value":[{"@type":"g:Metrics","@value":{"@type":"g:Map","@value":["dur",{"@type":"g:Double","@value":0.25},"name","HasStep","id","1.1.0()"]}}]}]}}]}]}}]}`)
+
+func TestUnmarshalProfile(t *testing.T) {
+	profile, err := UnmarshalProfile([]Response{{Result: Result{Data: dummyProfile}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Duration != 1500*time.Microsecond || len(profile.Steps) != 2 {
+		t.Fatalf("Unexpected profile %+v", profile)
+	}
+
+	first, second := profile.Steps[0], profile.Steps[1]
+	if first.Name != "TinkerGraphStep(vertex,[])" || first.ID != "0.0.0()" || first.Duration != 500*time.Microsecond ||
+		first.TraverserCount != 6 || first.ElementCount != 6 || first.PercentDuration != 33.3 {
+		t.Errorf("Unexpected first step %+v", first)
+	}
+	if second.TraverserCount != 2 || second.ElementCount != 3 || len(second.Nested) != 1 || second.Nested[0].Name != "HasStep" {
+		t.Errorf("Unexpected second step %+v", second)
+	}
+}
+
+func TestUnmarshalProfileWithoutProfile(t *testing.T) {
+	if _, err := UnmarshalProfile([]Response{{Result: Result{Data: json.RawMessage(`[]`)}}}); err == nil {
+		t.Error("Expected an error for a result without a profile")
+	}
+	if _, err := UnmarshalProfile([]Response{{Result: Result{Data: json.RawMessage(`["marko"]`)}}}); err == nil {
+		t.Error("Expected an error for a result which is not a profile")
+	}
+}
@@ -0,0 +1,27 @@
+package gremtune
+
+// QueryBuilder is the Traversal builder under the name used by the Query source.
+type QueryBuilder = Traversal
+
+// QuerySource starts traversals from the graph traversal source g, use the Query value:
+//
+//	query, bindings := Query.V().Has("name", "Alice").Out("knows").Values("name").Build()
+type QuerySource struct{}
+
+// Query is the QuerySource to start building queries from, Query.V() is the same as G().V()
+var Query QuerySource
+
+// V starts a traversal with a V step for the given vertex ids, or all vertices when no id is given.
+func (QuerySource) V(ids ...interface{}) *Traversal {
+	return G().V(ids...)
+}
+
+// E starts a traversal with an E step for the given edge ids, or all edges when no id is given.
+func (QuerySource) E(ids ...interface{}) *Traversal {
+	return G().E(ids...)
+}
+
+// AddV starts a traversal with an addV step creating a vertex with the given label.
+func (QuerySource) AddV(label string) *Traversal {
+	return G().AddV(label)
+}
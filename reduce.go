@@ -0,0 +1,24 @@
+package gremtune
+
+import "context"
+
+// ExecuteReduce sends a query to Gremlin Server and folds every response frame into an accumulator with reduce as
+// the frame arrives, starting from initial, and returns the final accumulator. Frames are dropped once folded, so
+// aggregations over large results, such as sums or counts, take constant memory. reduce runs on the goroutine
+// handling the responses of the client and should return quickly. Error frames are not folded, they fail the
+// request, in which case initial is returned with the error. The request is not retried, as its frames were folded
+// already.
+func ExecuteReduce[A any](c *Client, query string, reduce func(acc A, frame Response) A, initial A) (A, error) {
+	if c.conn.IsDisposed() {
+		return initial, ErrDisposed
+	}
+	req, _, err := prepareRequest(query)
+	if err != nil {
+		return initial, err
+	}
+	acc := initial
+	if err = c.roundTripFrames(context.Background(), req, func(frame Response) { acc = reduce(acc, frame) }); err != nil {
+		return initial, c.queryError(err, query)
+	}
+	return acc, nil
+}
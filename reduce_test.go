@@ -0,0 +1,71 @@
+package gremtune
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// newFramesServer starts a server answering every request with a frame per code, holding the frame number
+func newFramesServer(t *testing.T, codes ...int) *Client {
+	s := newTestServer(t, func(conn *websocket.Conn, msg []byte) {
+		var req Request
+		json.Unmarshal(msg[msg[0]+1:], &req)
+		for i, code := range codes {
+			conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(`{"requestId":"%s","result":{"data":[%d],"meta":{}},"status":{"code":%d,"attributes":{},"message":""}}`, req.RequestID, i+1, code)))
+		}
+	})
+	t.Cleanup(s.Close)
+	c := startTestClient(t, NewDialer(testServerHost(s)), make(chan error, 10))
+	t.Cleanup(func() { c.Shutdown(context.Background()) })
+	return c
+}
+
+func TestExecuteReduce(t *testing.T) {
+	c := newFramesServer(t, statusPartialContent, statusPartialContent, statusSuccess)
+
+	sum, err := ExecuteReduce(c, "g.V().values('age')", func(acc int, frame Response) int {
+		var values []int
+		if err := json.Unmarshal(frame.Result.Data, &values); err != nil {
+			t.Error(err)
+		}
+		for _, v := range values {
+			acc += v
+		}
+		return acc
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != 6 {
+		t.Errorf("Expected the frames to be folded into 6, got %d", sum)
+	}
+
+	aggregated := false
+	c.results.Range(func(k, v interface{}) bool {
+		aggregated = true
+		return false
+	})
+	if aggregated {
+		t.Error("Expected no frames to be kept once folded")
+	}
+}
+
+func TestExecuteReduceErrorFrame(t *testing.T) {
+	c := newFramesServer(t, statusPartialContent, statusServerError)
+
+	folded := 0
+	count, err := ExecuteReduce(c, "g.V()", func(acc int, frame Response) int {
+		folded++
+		return acc + 1
+	}, -1)
+	if err == nil {
+		t.Fatal("Expected the error frame to fail the request")
+	}
+	if count != -1 || folded != 1 {
+		t.Errorf("Expected the initial value and only the frame before the error to be folded, got %d after %d frames", count, folded)
+	}
+}
@@ -1,8 +1,10 @@
 package gremtune
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"regexp"
 
 	"github.com/gofrs/uuid"
 )
@@ -10,22 +12,49 @@ import (
 type requester interface {
 	prepare() error
 	getID() string
-	getRequest() request
+	getRequest() Request
 }
 
-// request is a container for all evaluation request parameters to be sent to the Gremlin Server.
-type request struct {
+// Request is a container for all evaluation request parameters to be sent to the Gremlin Server.
+type Request struct {
 	RequestID string                 `json:"requestId"`
 	Op        string                 `json:"op"`
 	Processor string                 `json:"processor"`
 	Args      map[string]interface{} `json:"args"`
 }
 
-// prepareRequest packages a query and binding into the format that Gremlin Server accepts
-func prepareRequest(query string) (req request, id string, err error) {
+// bindingKeyPattern matches the binding keys which are valid Groovy identifiers
+var bindingKeyPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// groovyKeywords are the reserved words of Groovy, which cannot name a binding
+var groovyKeywords = map[string]bool{
+	"abstract": true, "as": true, "assert": true, "boolean": true, "break": true, "byte": true, "case": true,
+	"catch": true, "char": true, "class": true, "const": true, "continue": true, "def": true, "default": true,
+	"do": true, "double": true, "else": true, "enum": true, "extends": true, "false": true, "final": true,
+	"finally": true, "float": true, "for": true, "goto": true, "if": true, "implements": true, "import": true,
+	"in": true, "instanceof": true, "int": true, "interface": true, "long": true, "native": true, "new": true,
+	"null": true, "package": true, "private": true, "protected": true, "public": true, "return": true,
+	"short": true, "static": true, "strictfp": true, "super": true, "switch": true, "synchronized": true,
+	"this": true, "threadsafe": true, "throw": true, "throws": true, "trait": true, "transient": true,
+	"true": true, "try": true, "var": true, "void": true, "volatile": true, "while": true,
+}
+
+// idempotentArg marks a request as safe to send again. It is removed before the request is serialized.
+const idempotentArg = "gremtune.idempotent"
+
+// unshapedArg exempts a request from the result shape of the client. It is removed before the request is sent.
+const unshapedArg = "gremtune.unshaped"
+
+// newRequestID generates a new UUIDv4 to identify a request or session
+func newRequestID() string {
 	var uuID uuid.UUID
 	uuID, _ = uuid.NewV4()
-	id = uuID.String()
+	return uuID.String()
+}
+
+// prepareRequest packages a query and binding into the format that Gremlin Server accepts
+func prepareRequest(query string) (req Request, id string, err error) {
+	id = newRequestID()
 
 	req.RequestID = id
 	req.Op = "eval"
@@ -39,10 +68,8 @@ func prepareRequest(query string) (req request, id string, err error) {
 }
 
 // prepareRequest packages a query and binding into the format that Gremlin Server accepts
-func prepareRequestWithBindings(query string, bindings, rebindings map[string]string) (req request, id string, err error) {
-	var uuID uuid.UUID
-	uuID, _ = uuid.NewV4()
-	id = uuID.String()
+func prepareRequestWithBindings(query string, bindings, rebindings map[string]string) (req Request, id string, err error) {
+	id = newRequestID()
 
 	req.RequestID = id
 	req.Op = "eval"
@@ -57,8 +84,34 @@ func prepareRequestWithBindings(query string, bindings, rebindings map[string]st
 	return
 }
 
+// prepareRequestWithTypedBindings packages a query and bindings of any type into the format that Gremlin Server accepts
+func prepareRequestWithTypedBindings(query string, bindings map[string]interface{}, rebindings map[string]string) (req Request, id string, err error) {
+	req, id, err = prepareRequest(query)
+	req.Args["bindings"] = bindings
+	req.Args["rebindings"] = rebindings
+	return
+}
+
+// prepareSessionRequest packages a query to be evaluated within a session
+func prepareSessionRequest(query string, session string) (req Request, id string, err error) {
+	req, id, err = prepareRequest(query)
+	req.Processor = "session"
+	req.Args["session"] = session
+	return
+}
+
+// prepareSessionCloseRequest creates the request closing a session
+func prepareSessionCloseRequest(session string) (req Request, id string) {
+	id = newRequestID()
+	req.RequestID = id
+	req.Op = "close"
+	req.Processor = "session"
+	req.Args = map[string]interface{}{"session": session}
+	return
+}
+
 //prepareAuthRequest creates a ws request for Gremlin Server
-func prepareAuthRequest(requestID string, username string, password string) (req request, err error) {
+func prepareAuthRequest(requestID string, username string, password string) (req Request, err error) {
 	req.RequestID = requestID
 	req.Op = "authentication"
 	req.Processor = "trasversal"
@@ -79,7 +132,7 @@ func prepareAuthRequest(requestID string, username string, password string) (req
 }
 
 // formatMessage takes a request type and formats it into being able to be delivered to Gremlin Server
-func packageRequest(req request) (msg []byte, err error) {
+func packageRequest(req Request) (msg []byte, err error) {
 	j, err := json.Marshal(req) // Formats request into byte format
 	if err != nil {
 		return
@@ -93,5 +146,18 @@ func packageRequest(req request) (msg []byte, err error) {
 
 // dispactchRequest sends the request for writing to the remote Gremlin Server
 func (c *Client) dispatchRequest(msg []byte) {
-	c.requests <- msg
+	c.dispatchRequestContext(context.Background(), msg)
+}
+
+// dispatchRequestContext sends the request for writing, giving up when ctx is done while the queue is full
+func (c *Client) dispatchRequestContext(ctx context.Context, msg []byte) error {
+	c.outbound.queue()
+	select {
+	case c.requests <- msg:
+	case <-ctx.Done():
+		c.outbound.take(1)
+		return ctx.Err()
+	}
+	c.backpressure.observe(len(c.requests))
+	return nil
 }
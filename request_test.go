@@ -16,7 +16,7 @@ func TestRequestPreparation(t *testing.T) {
 		t.Error(err)
 	}
 
-	expectedRequest := request{
+	expectedRequest := Request{
 		RequestID: id,
 		Op:        "eval",
 		Processor: "",
@@ -35,7 +35,7 @@ func TestRequestPreparation(t *testing.T) {
 
 // TestRequestPackaging tests the ability for gremtune to format a request using the established Gremlin Server WebSockets protocol for delivery to the server
 func TestRequestPackaging(t *testing.T) {
-	testRequest := request{
+	testRequest := Request{
 		RequestID: "1d6d02bd-8e56-421d-9438-3bd6d0079ff1",
 		Op:        "eval",
 		Processor: "",
@@ -72,7 +72,7 @@ func TestRequestPackaging(t *testing.T) {
 
 // TestRequestDispatch tests the ability for a requester to send a request to the client for writing to Gremlin Server
 func TestRequestDispatch(t *testing.T) {
-	testRequest := request{
+	testRequest := Request{
 		RequestID: "1d6d02bd-8e56-421d-9438-3bd6d0079ff1",
 		Op:        "eval",
 		Processor: "",
@@ -0,0 +1,92 @@
+package gremtune
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RequestIDGenerator generates the ids requests are sent and tracked under. Ids must be unique among the requests
+// in flight on a client. Gremlin Server parses request ids as UUIDs, so the built in generators all produce ids in
+// the UUID layout, custom generators should do the same unless the server accepts other ids.
+type RequestIDGenerator interface {
+	NewID() string
+}
+
+// RequestIDGeneratorFunc is a function generating request ids, as a RequestIDGenerator
+type RequestIDGeneratorFunc func() string
+
+// NewID returns f()
+func (f RequestIDGeneratorFunc) NewID() string {
+	return f()
+}
+
+// UUIDv4Generator generates random UUIDv4 request ids, the default
+type UUIDv4Generator struct{}
+
+// NewID returns a new random UUIDv4
+func (UUIDv4Generator) NewID() string {
+	return newRequestID()
+}
+
+// SequentialIDGenerator generates monotonically increasing request ids starting at 1, written in the UUID layout,
+// such as 00000000-0000-0000-0000-000000000001. Its zero value is ready to use, and it is safe for concurrent use.
+// It makes the ids of requests predictable, for tests asserting on requests or logs.
+type SequentialIDGenerator struct {
+	counter int64
+}
+
+// NewID returns the next id of the sequence
+func (g *SequentialIDGenerator) NewID() string {
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[8:], uint64(atomic.AddInt64(&g.counter, 1)))
+	return formatUUID(id)
+}
+
+// UUIDRequestIDs returns the generator of random UUIDv4 request ids, the default
+func UUIDRequestIDs() RequestIDGenerator {
+	return UUIDv4Generator{}
+}
+
+// ULIDRequestIDs returns a generator of ULIDs: a 48 bit millisecond timestamp followed by 80 random bits, written
+// in the UUID layout. The ids sort by the time they were generated, which keeps request logs in order. Ids
+// generated within the same millisecond increase monotonically.
+func ULIDRequestIDs() RequestIDGenerator {
+	var mu sync.Mutex
+	var last [16]byte
+	var lastMS uint64
+	return RequestIDGeneratorFunc(func() string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		var id [16]byte
+		ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+		if ms <= lastMS { // Same millisecond, or the clock went back: increment the random part of the previous id
+			id = last
+			for i := 15; i >= 6; i-- {
+				if id[i]++; id[i] != 0 {
+					break
+				}
+			}
+		} else {
+			binary.BigEndian.PutUint16(id[0:2], uint16(ms>>32))
+			binary.BigEndian.PutUint32(id[2:6], uint32(ms))
+			rand.Read(id[6:])
+			lastMS = ms
+		}
+		last = id
+		return formatUUID(id)
+	})
+}
+
+// SequentialRequestIDs returns a new SequentialIDGenerator, whose ids start at 1
+func SequentialRequestIDs() RequestIDGenerator {
+	return &SequentialIDGenerator{}
+}
+
+func formatUUID(id [16]byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16])
+}
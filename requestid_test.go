@@ -0,0 +1,58 @@
+package gremtune
+
+import (
+	"testing"
+
+	"github.com/gofrs/uuid"
+)
+
+func TestRequestIDGenerators(t *testing.T) {
+	generators := map[string]RequestIDGenerator{
+		"uuid":       UUIDRequestIDs(),
+		"ulid":       ULIDRequestIDs(),
+		"sequential": SequentialRequestIDs(),
+	}
+	for name, gen := range generators {
+		seen := make(map[string]bool)
+		for i := 0; i < 1000; i++ {
+			id := gen.NewID()
+			if _, err := uuid.FromString(id); err != nil {
+				t.Fatalf("%s: expected an id in the UUID layout, got %s", name, id)
+			}
+			if seen[id] {
+				t.Fatalf("%s: duplicate id %s", name, id)
+			}
+			seen[id] = true
+		}
+	}
+}
+
+func TestRequestIDGeneratorsSortable(t *testing.T) {
+	for name, gen := range map[string]RequestIDGenerator{"ulid": ULIDRequestIDs(), "sequential": SequentialRequestIDs()} {
+		prev := gen.NewID()
+		for i := 0; i < 1000; i++ {
+			id := gen.NewID()
+			if id <= prev {
+				t.Fatalf("%s: expected %s to sort after %s", name, id, prev)
+			}
+			prev = id
+		}
+	}
+
+	var gen SequentialIDGenerator
+	if id := gen.NewID(); id != "00000000-0000-0000-0000-000000000001" {
+		t.Errorf("Expected the first sequential id to be 1, got %s", id)
+	}
+}
+
+func TestClientUsesRequestIDGenerator(t *testing.T) {
+	c, fake := startFakeClient(t)
+	SetRequestIDGenerator(SequentialRequestIDs())(c)
+
+	if _, err := c.Execute("g.V()"); err != nil {
+		t.Fatal(err)
+	}
+	if requests := writtenRequests(t, fake); requests[0].RequestID != "00000000-0000-0000-0000-000000000001" {
+		t.Errorf("Expected the request to be sent under the generated id, got %s", requests[0].RequestID)
+	}
+}
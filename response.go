@@ -1,8 +1,21 @@
 package gremtune
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
 )
 
 const (
@@ -19,6 +32,100 @@ const (
 	statusServerSerializationError = 599
 )
 
+// SerializationError is returned when Gremlin Server was unable to serialize a result (status 599). This almost
+// always means that the serializer or GraphSON version used by the client does not match the server, or that
+// the traversal returned a type the server cannot serialize, such as an element of a custom class. Like every error
+// status, it is returned wrapped in a StatusError, see StatusError.Unwrap.
+type SerializationError struct {
+	Message    string
+	ResultType string // ResultType is the offending type when the server reports it
+}
+
+var serializedTypePattern = regexp.MustCompile(`(?:class|type)\s+\[?([A-Za-z_$][\w$]*(?:\.[\w$]+)+)`)
+
+func newSerializationError(status Status) *SerializationError {
+	err := &SerializationError{Message: status.Message}
+	if m := serializedTypePattern.FindStringSubmatch(status.Message); m != nil {
+		err.ResultType = m[1]
+	}
+	return err
+}
+
+func (e *SerializationError) Error() string {
+	msg := fmt.Sprintf("SERVER SERIALIZATION ERROR - Response Message: %s", e.Message)
+	if e.ResultType != "" {
+		msg += fmt.Sprintf(" (result type: %s)", e.ResultType)
+	}
+	return msg + " - check that the client serializer matches the GraphSON version of the server, or convert the result to a serializable type"
+}
+
+// StatusError is returned for a response with an error status code. Status is the status as sent by the server,
+// with attributes such as retry hints of throttling servers.
+type StatusError struct {
+	Status Status
+	err    error
+}
+
+func (e *StatusError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the error of the status, such as the SerializationError of a 599 response
+func (e *StatusError) Unwrap() error {
+	return e.err
+}
+
+// retryAfterAttribute is the status attribute Cosmos DB sends the time to wait before retrying a throttled request in
+const retryAfterAttribute = "x-ms-retry-after-ms"
+
+// RetryAfter returns the time the server asks to wait before the request is retried, ok is false when the server
+// did not send a hint. The hint is read from the x-ms-retry-after-ms attribute, in milliseconds or as a TimeSpan
+// such as 00:00:00.0100000.
+func (e *StatusError) RetryAfter() (d time.Duration, ok bool) {
+	switch v := e.Status.Attributes[retryAfterAttribute].(type) {
+	case float64:
+		return time.Duration(v * float64(time.Millisecond)), v >= 0
+	case string:
+		if ms, err := strconv.ParseFloat(v, 64); err == nil {
+			return time.Duration(ms * float64(time.Millisecond)), ms >= 0
+		}
+		return parseTimeSpan(v)
+	}
+	return 0, false
+}
+
+// RetryAfter returns the retry hint of the server when err is caused by a *StatusError carrying one
+func RetryAfter(err error) (time.Duration, bool) {
+	if statusErr, ok := errors.Cause(err).(*StatusError); ok {
+		return statusErr.RetryAfter()
+	}
+	return 0, false
+}
+
+// parseTimeSpan parses a .NET TimeSpan of the form [d.]hh:mm:ss[.fffffff]
+func parseTimeSpan(s string) (time.Duration, bool) {
+	var days int64
+	if i := strings.Index(s, "."); i >= 0 && i < strings.Index(s, ":") {
+		d, err := strconv.ParseInt(s[:i], 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		days, s = d, s[i+1:]
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	hours, err1 := strconv.ParseInt(parts[0], 10, 64)
+	minutes, err2 := strconv.ParseInt(parts[1], 10, 64)
+	seconds, err3 := strconv.ParseFloat(parts[2], 64)
+	if err1 != nil || err2 != nil || err3 != nil || hours < 0 || minutes < 0 || seconds < 0 {
+		return 0, false
+	}
+	d := time.Duration(days*24+hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds*float64(time.Second))
+	return d, true
+}
+
 // Status struct is used to hold properties returned from requests to the gremlin server
 type Status struct {
 	Message    string                 `json:"message"`
@@ -26,6 +133,31 @@ type Status struct {
 	Attributes map[string]interface{} `json:"attributes"`
 }
 
+// IsSuccess reports whether the request succeeded with its last frame, status 200 or 204 for no content
+func (s Status) IsSuccess() bool {
+	return s.Code == statusSuccess || s.Code == statusNoContent
+}
+
+// IsPartial reports whether the frame is one of several of the response and more are to follow, status 206
+func (s Status) IsPartial() bool {
+	return s.Code == statusPartialContent
+}
+
+// IsAuthChallenge reports whether the server requests authentication before it runs the request, status 407
+func (s Status) IsAuthChallenge() bool {
+	return s.Code == statusAuthenticate
+}
+
+// IsClientError reports whether the server rejected the request, statuses 400 to 499
+func (s Status) IsClientError() bool {
+	return s.Code >= 400 && s.Code <= 499
+}
+
+// IsServerError reports whether the server failed to run the request, statuses 500 to 599
+func (s Status) IsServerError() bool {
+	return s.Code >= 500 && s.Code <= 599
+}
+
 // Result struct is used to hold properties returned for results from requests to the gremlin server
 type Result struct {
 	// Query Response Data
@@ -38,6 +170,23 @@ type Response struct {
 	RequestID string `json:"requestId"`
 	Status    Status `json:"status"`
 	Result    Result `json:"result"`
+	FromCache bool   `json:"-"` // FromCache is set when the response was served by the result cache
+	raw       []byte // raw is the frame the response was decoded from, kept with SetRawResponses
+}
+
+// Raw returns the frame the response was decoded from as sent by the server, for callers passing it on as is. It
+// is nil unless the client was configured with SetRawResponses.
+func (r Response) Raw() []byte {
+	return r.raw
+}
+
+// Unmarshal decodes the frame the response was decoded from into v with json.Unmarshal, such as into a struct of
+// the caller. It fails with ErrNoRawResponse unless the client was configured with SetRawResponses.
+func (r Response) Unmarshal(v interface{}) error {
+	if r.raw == nil {
+		return ErrNoRawResponse
+	}
+	return json.Unmarshal(r.raw, v)
 }
 
 // ToString returns a string representation of the Response struct
@@ -45,20 +194,185 @@ func (r Response) ToString() string {
 	return fmt.Sprintf("Response \nRequestID: %v, \nStatus: {%#v}, \nResult: {%#v}\n", r.RequestID, r.Status, r.Result)
 }
 
+// RequestUUID returns the id of the request the response frame belongs to, to correlate the frames delivered by
+// SubmitAsync with their request. It returns uuid.Nil when the id is not a UUID, such as ids of a custom
+// RequestIDGenerator.
+func (r Response) RequestUUID() uuid.UUID {
+	id, err := uuid.FromString(r.RequestID)
+	if err != nil {
+		return uuid.Nil
+	}
+	return id
+}
+
+// MetaValue returns the value stored under key in the result metadata of the response
+func (r Response) MetaValue(key string) (value interface{}, ok bool) {
+	value, ok = r.Result.Meta[key]
+	return
+}
+
+// FilterByMeta returns the responses whose result metadata carries the given key
+func FilterByMeta(responses []Response, key string) (filtered []Response) {
+	for _, r := range responses {
+		if _, ok := r.MetaValue(key); ok {
+			filtered = append(filtered, r)
+		}
+	}
+	return
+}
+
+// MetaValues collects the values stored under key in the result metadata of an aggregated response,
+// such as side effects reported per result frame
+func MetaValues(responses []Response, key string) (values []interface{}) {
+	for _, r := range responses {
+		if v, ok := r.MetaValue(key); ok {
+			values = append(values, v)
+		}
+	}
+	return
+}
+
+// affectedCountAttribute is the status attribute servers report the number of elements a mutation affected in
+const affectedCountAttribute = "affectedCount"
+
+// AffectedCount returns the number of elements a mutation such as addV, addE or drop affected, as reported by the
+// server. It is the sum of the affectedCount status attributes of the frames, or else the result when it is a single
+// count, such as that of g.V().hasLabel('temp').sideEffect(drop()).count(). ok is false when the server reported
+// neither, which does not mean that no element was affected.
+func AffectedCount(responses []Response) (count int64, ok bool) {
+	for _, r := range responses {
+		if n, found := countValue(r.Status.Attributes[affectedCountAttribute]); found {
+			count, ok = count+n, true
+		}
+	}
+	if ok {
+		return
+	}
+
+	var results []interface{}
+	for _, r := range responses {
+		if len(r.Result.Data) == 0 || string(r.Result.Data) == "null" {
+			continue
+		}
+		v, err := DecodeValue(r.Result.Data)
+		if err != nil {
+			return 0, false
+		}
+		if items, isList := v.([]interface{}); isList {
+			results = append(results, items...)
+		} else {
+			results = append(results, v)
+		}
+	}
+	if len(results) != 1 {
+		return 0, false
+	}
+	return countValue(results[0])
+}
+
+// countValue reads a count from a decoded number, a GraphSON number decoded as plain JSON included
+func countValue(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return int64(n), n >= 0
+	case int64:
+		return n, n >= 0
+	case float64:
+		return int64(n), n >= 0 && n == math.Trunc(n)
+	case map[string]interface{}:
+		if value, ok := n["@value"]; ok {
+			return countValue(value)
+		}
+	}
+	return 0, false
+}
+
 func (c *Client) handleResponse(msg []byte) (err error) {
-	resp, err := marshalResponse(msg)
+	return c.handleFrame(msg, c.nextFrameSeq())
+}
 
-	if resp.Status.Code == statusAuthenticate { //Server request authentication
+// handleFrame handles a response frame which arrived as the seq-th frame on the connection
+func (c *Client) handleFrame(msg []byte, seq uint64) (err error) {
+	resp, err := marshalResponse(c.serializer, msg)
+	if c.rawResponses {
+		resp.raw = append([]byte(nil), msg...) // The frame buffer is recycled once the frame has been handled
+	}
+	c.stats.received(len(msg))
+	if err != nil {
+		c.stats.responseError(resp.Status.Code)
+	}
+
+	if resp.Status.IsAuthChallenge() { //Server request authentication
 		return c.authenticate(resp.RequestID)
 	}
 
-	c.saveResponse(resp, err)
+	c.saveFrame(resp, err, seq)
 	return
 }
 
+// nextFrameSeq numbers frames in the order they arrive on the connection
+func (c *Client) nextFrameSeq() uint64 {
+	return atomic.AddUint64(&c.frameSeq, 1)
+}
+
+// startResponseHandlers starts the configured number of response handler workers. It returns the function handing
+// a frame over to them and the function stopping them. Frames are numbered here, in the order they arrive, and
+// sharded by request id, so that the frames of a single request are also handled in that order. Without workers,
+// frames are handled synchronously. The buffer a frame was read into is put back into the buffer pool once the
+// frame has been handled.
+func (c *Client) startResponseHandlers() (handle func(msg []byte, buf *bytes.Buffer), stop func()) {
+	if c.responseWorkers <= 0 {
+		return func(msg []byte, buf *bytes.Buffer) {
+			c.handleResponse(msg)
+			c.buffers.put(buf)
+		}, func() {}
+	}
+
+	type frame struct {
+		msg []byte
+		buf *bytes.Buffer
+		seq uint64
+	}
+	queues := make([]chan frame, c.responseWorkers)
+	for i := range queues {
+		queues[i] = make(chan frame, 3)
+		queue := queues[i]
+		c.goWorker(func() {
+			for f := range queue {
+				c.handleFrame(f.msg, f.seq)
+				c.buffers.put(f.buf)
+			}
+		})
+	}
+
+	handle = func(msg []byte, buf *bytes.Buffer) {
+		queues[shardFrame(msg, len(queues))] <- frame{msg: msg, buf: buf, seq: c.nextFrameSeq()}
+	}
+	stop = func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+	}
+	return
+}
+
+// shardFrame picks the handler worker for a frame from its request id. Frames whose request id cannot be read
+// as JSON all go to the first worker.
+func shardFrame(msg []byte, workers int) int {
+	var frame struct {
+		RequestID string `json:"requestId"`
+	}
+	if err := json.Unmarshal(msg, &frame); err != nil {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(frame.RequestID))
+	return int(h.Sum32() % uint32(workers))
+}
+
 // marshalResponse creates a response struct for every incoming response for further manipulation
-func marshalResponse(msg []byte) (resp Response, err error) {
-	err = json.Unmarshal(msg, &resp)
+func marshalResponse(s Serializer, msg []byte) (resp Response, err error) {
+	resp, err = s.Deserialize(msg)
 	if err != nil {
 		return
 	}
@@ -69,26 +383,141 @@ func marshalResponse(msg []byte) (resp Response, err error) {
 
 // saveResponse makes the response available for retrieval by the requester. Mutexes are used for thread safety.
 func (c *Client) saveResponse(resp Response, err error) {
+	c.saveFrame(resp, err, c.nextFrameSeq())
+}
+
+// saveFrame saves a response frame by its arrival sequence. The responses of a request are always aggregated in
+// the order their frames arrived in, which is the order the server streamed the results in. Frames of requests
+// which are not pending, such as requests given up on, are dropped so that nothing is kept for them.
+func (c *Client) saveFrame(resp Response, err error, seq uint64) {
+	if _, ok := c.responseNotifier.Load(resp.RequestID); !ok {
+		return
+	}
+	if first, ok := c.firstFrames.LoadAndDelete(resp.RequestID); ok {
+		close(first.(chan struct{}))
+	}
+	handler, handled := c.frameHandlers.Load(resp.RequestID)
+	if handled { // The frames of the request belong to its handler
+		if err == nil {
+			handler.(FrameHandler)(resp)
+		}
+	} else if c.frameHandler != nil { // Aggregation is disabled, the frame belongs to the handler
+		c.frameHandler(resp)
+	}
+	handled = handled || c.frameHandler != nil
+
 	c.Lock()
 	defer c.Unlock()
-	var container []interface{}
-	existingData, ok := c.results.Load(resp.RequestID) // Retrieve old data container (for requests with multiple responses)
-	if ok {
-		container = existingData.([]interface{})
+	respNotifier, ok := c.responseNotifier.Load(resp.RequestID)
+	if !ok { // Given up on while the frame was handled
+		return
+	}
+	if !handled && c.MaxResultSize > 0 {
+		if tooLarge, failed := c.exceedsResultSize(resp); failed {
+			return
+		} else if tooLarge != nil {
+			notify(respNotifier.(chan error), tooLarge)
+			return
+		}
+	}
+	if !handled {
+		var container []interface{}
+		existingData, ok := c.results.Load(resp.RequestID) // Retrieve old data container (for requests with multiple responses)
+		if ok {
+			container = existingData.([]interface{})
+		}
+		var seqs []uint64
+		if existingSeqs, ok := c.frameOrder.Load(resp.RequestID); ok {
+			seqs = existingSeqs.([]uint64)
+		}
+		i := sort.Search(len(seqs), func(i int) bool { return seqs[i] > seq }) // Position of the frame by arrival
+		newdata := append(container, nil)                                      // Create new data container with new data
+		copy(newdata[i+1:], newdata[i:])
+		newdata[i] = resp
+		seqs = append(seqs, 0)
+		copy(seqs[i+1:], seqs[i:])
+		seqs[i] = seq
+		c.results.Store(resp.RequestID, newdata) // Add new data to buffer for future retrieval
+		c.frameOrder.Store(resp.RequestID, seqs)
+	}
+	if !resp.Status.IsPartial() && !notify(respNotifier.(chan error), err) {
+		c.logger().Error("Dropped response notification, the previous one was never consumed", "requestId", resp.RequestID, "error", err)
 	}
-	newdata := append(container, resp)       // Create new data container with new data
-	c.results.Store(resp.RequestID, newdata) // Add new data to buffer for future retrieval
-	respNotifier, load := c.responseNotifier.LoadOrStore(resp.RequestID, make(chan error, 1))
-	_ = load
-	if resp.Status.Code != statusPartialContent {
-		respNotifier.(chan error) <- err
+}
+
+// exceedsResultSize counts the result data of a frame towards the MaxResultSize of its request. It returns the error
+// of the frame which made the result exceed it, whose aggregated frames are dropped, and reports frames of a request
+// which exceeded it already as failed. The client must be locked.
+func (c *Client) exceedsResultSize(resp Response) (tooLarge error, failed bool) {
+	var size int64
+	if existing, ok := c.resultSizes.Load(resp.RequestID); ok {
+		size = existing.(int64)
+	}
+	if size < 0 { // Failed by an earlier frame, the rest of the result is dropped
+		return nil, true
+	}
+	size += int64(len(resp.Result.Data))
+	if size <= c.MaxResultSize {
+		c.resultSizes.Store(resp.RequestID, size)
+		return nil, false
+	}
+	c.logger().Error("Dropped a result exceeding the maximum result size", "requestId", resp.RequestID, "size", size, "max", c.MaxResultSize)
+	c.results.Delete(resp.RequestID)
+	c.frameOrder.Delete(resp.RequestID)
+	c.resultSizes.Store(resp.RequestID, int64(-1))
+	return &ErrResultTooLarge{Size: size, Max: c.MaxResultSize}, false
+}
+
+// notify delivers the outcome of a request without blocking. It returns false when the notifier still holds an
+// outcome nobody has consumed, in which case the new one is dropped.
+func notify(notifier chan error, err error) bool {
+	select {
+	case notifier <- err:
+		return true
+	default:
+		return false
 	}
 }
 
 // retrieveResponse retrieves the response saved by saveResponse.
 func (c *Client) retrieveResponse(id string) (data []Response, err error) {
-	resp, _ := c.responseNotifier.Load(id)
-	err = <-resp.(chan error)
+	return c.retrieveResponseContext(context.Background(), id)
+}
+
+// retrieveResponseContext retrieves the response saved by saveResponse, giving up when ctx is done
+func (c *Client) retrieveResponseContext(ctx context.Context, id string) (data []Response, err error) {
+	resp, ok := c.responseNotifier.Load(id)
+	if !ok { // Failed by a reset or shutdown before the wait started
+		c.deleteResponse(id)
+		if c.isShutdown() {
+			return nil, ErrClientShutdown
+		}
+		return nil, ErrReset
+	}
+
+	var firstFrame <-chan struct{}
+	var firstFrameTimeout <-chan time.Time
+	if ch, ok := c.firstFrames.Load(id); ok {
+		firstFrame = ch.(chan struct{})
+		timer := orRealClock(c.clock).NewTimer(c.firstFrameTimeout)
+		defer timer.Stop()
+		firstFrameTimeout = timer.C()
+	}
+
+	for waiting := true; waiting; {
+		select {
+		case err = <-resp.(chan error):
+			waiting = false
+		case <-firstFrame: // The response has started, only ctx bounds the rest of it
+			firstFrame, firstFrameTimeout = nil, nil
+		case <-firstFrameTimeout:
+			c.abandon(id)
+			return nil, ErrFirstFrameTimeout
+		case <-ctx.Done(): // The request is given up, frames still arriving for it are dropped with it
+			c.abandon(id)
+			return nil, ctx.Err()
+		}
+	}
 	if err == nil {
 		if dataI, ok := c.results.Load(id); ok {
 			d := dataI.([]interface{})
@@ -99,14 +528,34 @@ func (c *Client) retrieveResponse(id string) (data []Response, err error) {
 			close(resp.(chan error))
 			c.responseNotifier.Delete(id)
 			c.deleteResponse(id)
+		} else if _, handled := c.frameHandlers.Load(id); handled || c.frameHandler != nil { // Frames were delivered to the handler, nothing was aggregated
+			close(resp.(chan error))
+			c.responseNotifier.Delete(id)
 		}
 	}
 	return
 }
 
+// abandon forgets a request given up on. It locks the client, so that a frame being saved for the request is
+// either saved before or dropped after.
+func (c *Client) abandon(id string) {
+	c.Lock()
+	defer c.Unlock()
+	c.responseNotifier.Delete(id)
+	c.deleteResponse(id)
+}
+
 // deleteRespones deletes the response from the container. Used for cleanup purposes by requester.
 func (c *Client) deleteResponse(id string) {
 	c.results.Delete(id)
+	c.frameOrder.Delete(id)
+	if c.resultSizes != nil {
+		c.resultSizes.Delete(id)
+	}
+	c.firstFrames.Delete(id)
+	if c.unsent != nil {
+		c.unsent.Delete(id)
+	}
 	return
 }
 
@@ -130,9 +579,12 @@ func (r *Response) detectError() (err error) {
 	case statusServerTimeout:
 		err = fmt.Errorf("SERVER TIMEOUT - Response Message: %s", r.Status.Message)
 	case statusServerSerializationError:
-		err = fmt.Errorf("SERVER SERIALIZATION ERROR - Response Message: %s", r.Status.Message)
+		err = newSerializationError(r.Status)
 	default:
 		err = fmt.Errorf("UNKNOWN ERROR - Response Message: %s", r.Status.Message)
 	}
+	if err != nil {
+		err = &StatusError{Status: r.Status, err: err}
+	}
 	return
 }
@@ -1,9 +1,17 @@
 package gremtune
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
 /*
@@ -62,6 +70,7 @@ var dummyPartialResponse2Marshalled = Response{
 // TestResponseHandling tests the overall response handling mechanism of gremtune
 func TestResponseHandling(t *testing.T) {
 	c := newClient()
+	expectResponses(&c, dummySuccessfulResponseMarshalled.RequestID)
 
 	c.handleResponse(dummySuccessfulResponse)
 
@@ -76,6 +85,7 @@ func TestResponseHandling(t *testing.T) {
 
 func TestResponseAuthHandling(t *testing.T) {
 	c := newClient()
+	expectResponses(&c, dummySuccessfulResponseMarshalled.RequestID)
 	ws := new(Ws)
 	ws.auth = &auth{username: "test", password: "test"}
 	c.conn = ws
@@ -111,7 +121,7 @@ func TestResponseAuthHandling(t *testing.T) {
 
 // TestResponseMarshalling tests the ability to marshal a response into a designated response struct for further manipulation
 func TestResponseMarshalling(t *testing.T) {
-	resp, err := marshalResponse(dummySuccessfulResponse)
+	resp, err := marshalResponse(GraphSONSerializer{}, dummySuccessfulResponse)
 	if err != nil {
 		t.Error(err)
 	}
@@ -122,10 +132,33 @@ func TestResponseMarshalling(t *testing.T) {
 	}
 }
 
+// expectResponses registers requests as pending, as submitting them does, so that their frames are saved
+func expectResponses(c *Client, ids ...string) {
+	for _, id := range ids {
+		c.responseNotifier.Store(id, make(chan error, 1))
+	}
+}
+
+// TestResponseDropsUnknownRequests tests that frames of requests which are not pending are not kept
+func TestResponseDropsUnknownRequests(t *testing.T) {
+	c := newClient()
+
+	c.saveResponse(dummyPartialResponse1Marshalled, nil)
+	c.saveResponse(dummyPartialResponse2Marshalled, nil)
+
+	if _, ok := c.results.Load(dummyPartialResponse1Marshalled.RequestID); ok {
+		t.Error("Expected the frames of an unknown request to be dropped")
+	}
+	if _, ok := c.responseNotifier.Load(dummyPartialResponse1Marshalled.RequestID); ok {
+		t.Error("Expected no notifier to be created for an unknown request")
+	}
+}
+
 // TestResponseSortingSingleResponse tests the ability for sortResponse to save a response received from Gremlin Server
 func TestResponseSortingSingleResponse(t *testing.T) {
 
 	c := newClient()
+	expectResponses(&c, dummySuccessfulResponseMarshalled.RequestID)
 
 	c.saveResponse(dummySuccessfulResponseMarshalled, nil)
 
@@ -142,6 +175,7 @@ func TestResponseSortingSingleResponse(t *testing.T) {
 func TestResponseSortingMultipleResponse(t *testing.T) {
 
 	c := newClient()
+	expectResponses(&c, dummyPartialResponse1Marshalled.RequestID)
 
 	c.saveResponse(dummyPartialResponse1Marshalled, nil)
 	c.saveResponse(dummyPartialResponse2Marshalled, nil)
@@ -159,6 +193,7 @@ func TestResponseSortingMultipleResponse(t *testing.T) {
 // TestResponseRetrieval tests the ability for a requester to retrieve the response for a specified requestId generated when sending the request
 func TestResponseRetrieval(t *testing.T) {
 	c := newClient()
+	expectResponses(&c, dummyPartialResponse1Marshalled.RequestID)
 
 	c.saveResponse(dummyPartialResponse1Marshalled, nil)
 	c.saveResponse(dummyPartialResponse2Marshalled, nil)
@@ -177,6 +212,7 @@ func TestResponseRetrieval(t *testing.T) {
 // TestResponseDeletion tests the ability for a requester to clean up after retrieving a response after delivery to a client
 func TestResponseDeletion(t *testing.T) {
 	c := newClient()
+	expectResponses(&c, dummyPartialResponse1Marshalled.RequestID)
 
 	c.saveResponse(dummyPartialResponse1Marshalled, nil)
 	c.saveResponse(dummyPartialResponse2Marshalled, nil)
@@ -234,3 +270,351 @@ func TestResponseErrorDetection(t *testing.T) {
 		}
 	}
 }
+
+// TestResponseMetaFiltering tests the ability to select responses and values by result metadata key
+func TestResponseMetaFiltering(t *testing.T) {
+	responses := []Response{
+		{RequestID: "1", Result: Result{Meta: map[string]interface{}{"sideEffect": "a"}}},
+		{RequestID: "2", Result: Result{Meta: map[string]interface{}{}}},
+		{RequestID: "3", Result: Result{Meta: map[string]interface{}{"sideEffect": "b"}}},
+	}
+
+	filtered := FilterByMeta(responses, "sideEffect")
+	if len(filtered) != 2 || filtered[0].RequestID != "1" || filtered[1].RequestID != "3" {
+		t.Errorf("Unexpected filtered responses: %v", filtered)
+	}
+
+	values := MetaValues(responses, "sideEffect")
+	if !reflect.DeepEqual(values, []interface{}{"a", "b"}) {
+		t.Errorf("Unexpected meta values: %v", values)
+	}
+
+	if _, ok := responses[1].MetaValue("sideEffect"); ok {
+		t.Error("Expected no meta value for a response without the key")
+	}
+}
+
+// TestResponseFrameHandler tests that frames are handed to the frame handler instead of being aggregated
+func TestResponseFrameHandler(t *testing.T) {
+	c := newClient()
+	expectResponses(&c, dummyPartialResponse1Marshalled.RequestID)
+	var frames []Response
+	SetFrameHandler(func(frame Response) { frames = append(frames, frame) })(&c)
+
+	c.saveResponse(dummyPartialResponse1Marshalled, nil)
+	c.saveResponse(dummyPartialResponse2Marshalled, nil)
+
+	resp, err := c.retrieveResponse(dummyPartialResponse1Marshalled.RequestID)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(resp) != 0 {
+		t.Errorf("Expected no aggregated responses, got %d", len(resp))
+	}
+
+	if !reflect.DeepEqual(frames, []Response{dummyPartialResponse1Marshalled, dummyPartialResponse2Marshalled}) {
+		t.Error("Expected both frames to be handed to the frame handler")
+	}
+
+	if _, ok := c.responseNotifier.Load(dummyPartialResponse1Marshalled.RequestID); ok {
+		t.Error("Expected the request to be cleaned up")
+	}
+}
+
+// TestResponseSerializationError tests that a 599 response reports the offending result type with guidance
+func TestResponseSerializationError(t *testing.T) {
+	r := Response{Status: Status{
+		Code:    statusServerSerializationError,
+		Message: "Error during serialization: Serializer for type org.example.CustomValue not found",
+	}}
+
+	statusErr, ok := errors.Cause(errors.Wrap(r.detectError(), "query")).(*StatusError)
+	if !ok || statusErr.Status.Code != statusServerSerializationError {
+		t.Fatal("Expected a StatusError for the 599 status")
+	}
+	err, ok := statusErr.Unwrap().(*SerializationError)
+	if !ok {
+		t.Fatal("Expected a SerializationError")
+	}
+
+	if err.ResultType != "org.example.CustomValue" {
+		t.Errorf("Unexpected result type: %s", err.ResultType)
+	}
+
+	if !strings.Contains(err.Error(), "GraphSON version") {
+		t.Errorf("Expected the error to advise on the serializer, got: %s", err)
+	}
+
+	r.Status.Attributes = map[string]interface{}{retryAfterAttribute: float64(10)}
+	if d, ok := RetryAfter(r.detectError()); !ok || d != 10*time.Millisecond {
+		t.Errorf("Expected the retry hint of the 599 status, got %v", d)
+	}
+}
+
+// TestResponseHandlerWorkers tests that frames handled by workers are aggregated in the order they arrived
+func TestResponseHandlerWorkers(t *testing.T) {
+	c := newClient()
+	SetResponseHandlerWorkers(4)(&c)
+	handle, stop := c.startResponseHandlers()
+	defer stop()
+
+	c.responseNotifier.Store(dummyPartialResponse1Marshalled.RequestID, make(chan error, 1))
+	handle([]byte(`{"requestId":"1d6d02bd-8e56-421d-9438-3bd6d0079ff1","status":{"code":206},"result":{"data":1}}`), nil)
+	handle([]byte(`{"requestId":"1d6d02bd-8e56-421d-9438-3bd6d0079ff1","status":{"code":206},"result":{"data":2}}`), nil)
+	handle([]byte(`{"requestId":"1d6d02bd-8e56-421d-9438-3bd6d0079ff1","status":{"code":200},"result":{"data":3}}`), nil)
+
+	resp, err := c.retrieveResponse(dummyPartialResponse1Marshalled.RequestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp) != 3 {
+		t.Fatalf("Expected 3 responses, got %d", len(resp))
+	}
+	for i, r := range resp {
+		if string(r.Result.Data) != strconv.Itoa(i+1) {
+			t.Errorf("Expected frame %d in position %d, got %s", i+1, i, r.Result.Data)
+		}
+	}
+}
+
+// TestResponseNotificationNeverBlocks tests that saving an outcome nobody consumed does not block the read worker
+func TestResponseNotificationNeverBlocks(t *testing.T) {
+	c := newClient()
+	expectResponses(&c, dummySuccessfulResponseMarshalled.RequestID)
+
+	done := make(chan struct{})
+	go func() {
+		c.saveResponse(dummySuccessfulResponseMarshalled, nil)
+		c.saveResponse(dummySuccessfulResponseMarshalled, nil) // Duplicate terminal frame
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected saving an unconsumed response not to block")
+	}
+
+	if _, err := c.retrieveResponse(dummySuccessfulResponseMarshalled.RequestID); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestResponseFramesOrderedByArrival tests that frames saved out of order are aggregated in their arrival order
+func TestResponseFramesOrderedByArrival(t *testing.T) {
+	c := newClient()
+	id := dummyPartialResponse1Marshalled.RequestID
+	c.responseNotifier.Store(id, make(chan error, 1))
+
+	for _, seq := range []uint64{2, 3, 1} {
+		c.saveFrame(Response{RequestID: id, Status: Status{Code: statusPartialContent}, Result: Result{Data: []byte(strconv.FormatUint(seq, 10))}}, nil, seq)
+	}
+	c.saveFrame(Response{RequestID: id, Status: Status{Code: statusSuccess}, Result: Result{Data: []byte("4")}}, nil, 4)
+
+	resp, err := c.retrieveResponse(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range resp {
+		if string(r.Result.Data) != strconv.Itoa(i+1) {
+			t.Errorf("Expected frame %d in position %d, got %s", i+1, i, r.Result.Data)
+		}
+	}
+}
+
+// TestResponseOrderingUnderConcurrency tests that interleaved frames of many requests keep their order per request
+func TestResponseOrderingUnderConcurrency(t *testing.T) {
+	const requests, frames = 50, 40
+
+	c := newClient()
+	SetResponseHandlerWorkers(8)(&c)
+	handle, stop := c.startResponseHandlers()
+	defer stop()
+
+	ids := make([]string, requests)
+	for i := range ids {
+		ids[i] = newRequestID()
+		c.responseNotifier.Store(ids[i], make(chan error, 1))
+	}
+
+	retrieved := make(chan error, requests)
+	for _, id := range ids {
+		go func(id string) {
+			resp, err := c.retrieveResponse(id)
+			if err == nil && len(resp) != frames {
+				err = fmt.Errorf("request %s: expected %d frames, got %d", id, frames, len(resp))
+			}
+			for i := 0; err == nil && i < len(resp); i++ {
+				if string(resp[i].Result.Data) != strconv.Itoa(i) {
+					err = fmt.Errorf("request %s: expected frame %d in position %d, got %s", id, i, i, resp[i].Result.Data)
+				}
+			}
+			retrieved <- err
+		}(id)
+	}
+
+	for f := 0; f < frames; f++ { // Interleave the frames of all requests, like a busy connection would
+		code := statusPartialContent
+		if f == frames-1 {
+			code = statusSuccess
+		}
+		for _, id := range ids {
+			handle([]byte(fmt.Sprintf(`{"requestId":"%s","status":{"code":%d},"result":{"data":%d}}`, id, code, f)), nil)
+		}
+	}
+
+	for range ids {
+		select {
+		case err := <-retrieved:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out retrieving the responses")
+		}
+	}
+}
+
+func TestStatusErrorRetryAfter(t *testing.T) {
+	hints := map[string]time.Duration{
+		`5`:                    5 * time.Millisecond,
+		`"12.5"`:               12500 * time.Microsecond,
+		`"00:00:00.0100000"`:   10 * time.Millisecond,
+		`"00:01:02"`:           62 * time.Second,
+		`"1.00:00:00.5000000"`: 24*time.Hour + 500*time.Millisecond,
+	}
+	for hint, want := range hints {
+		r := Response{Status: Status{Code: statusServerError}}
+		json.Unmarshal([]byte(`{"`+retryAfterAttribute+`":`+hint+`}`), &r.Status.Attributes)
+		if got, ok := RetryAfter(errors.Wrap(r.detectError(), "query")); !ok || got != want {
+			t.Errorf("%s: expected a hint of %s, got %s (%t)", hint, want, got, ok)
+		}
+	}
+
+	for _, hint := range []string{`"soon"`, `"1:2"`, `-1`, `null`} {
+		r := Response{Status: Status{Code: statusServerError}}
+		json.Unmarshal([]byte(`{"`+retryAfterAttribute+`":`+hint+`}`), &r.Status.Attributes)
+		if _, ok := RetryAfter(r.detectError()); ok {
+			t.Errorf("%s: expected no hint", hint)
+		}
+	}
+}
+
+func TestStatusPredicates(t *testing.T) {
+	// success, partial, auth challenge, client error, server error
+	expected := map[int][5]bool{
+		statusSuccess:                  {true, false, false, false, false},
+		statusNoContent:                {true, false, false, false, false},
+		statusPartialContent:           {false, true, false, false, false},
+		statusUnauthorized:             {false, false, false, true, false},
+		statusAuthenticate:             {false, false, true, true, false},
+		statusMalformedRequest:         {false, false, false, true, false},
+		statusInvalidRequestArguments:  {false, false, false, true, false},
+		statusServerError:              {false, false, false, false, true},
+		statusScriptEvaluationError:    {false, false, false, false, true},
+		statusServerTimeout:            {false, false, false, false, true},
+		statusServerSerializationError: {false, false, false, false, true},
+	}
+	for code, want := range expected {
+		s := Status{Code: code}
+		if got := [5]bool{s.IsSuccess(), s.IsPartial(), s.IsAuthChallenge(), s.IsClientError(), s.IsServerError()}; got != want {
+			t.Errorf("Status %d: expected %v, got %v", code, want, got)
+		}
+	}
+}
+
+func BenchmarkResponseHandlers(b *testing.B) {
+	for _, workers := range []int{0, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) { benchmarkResponseHandlers(b, workers) })
+	}
+}
+
+// benchmarkResponseHandlers measures handling frames of many requests whose GraphSON results are costly to decode
+func benchmarkResponseHandlers(b *testing.B, workers int) {
+	values := make([]string, 50)
+	for i := range values {
+		values[i] = fmt.Sprintf(`{"@type":"g:Map","@value":["id",{"@type":"g:Int64","@value":%d},"name","person %d"]}`, i, i)
+	}
+	frames := make([][]byte, 64)
+	for i := range frames {
+		frames[i] = []byte(fmt.Sprintf(`{"requestId":"request-%d","status":{"code":206},"result":{"data":{"@type":"g:List","@value":[%s]}}}`, i, strings.Join(values, ",")))
+	}
+
+	var wg sync.WaitGroup
+	c := newClient()
+	SetResponseHandlerWorkers(workers)(&c)
+	SetFrameHandler(func(frame Response) {
+		if _, err := DecodeValue(frame.Result.Data); err != nil {
+			b.Error(err)
+		}
+		wg.Done()
+	})(&c)
+	handle, stop := c.startResponseHandlers()
+	defer stop()
+
+	b.ResetTimer()
+	wg.Add(b.N)
+	for i := 0; i < b.N; i++ {
+		handle(frames[i%len(frames)], nil)
+	}
+	wg.Wait()
+}
+
+func TestRawResponses(t *testing.T) {
+	c, fake := startFakeClient(t)
+	SetRawResponses()(c)
+	var frame string
+	fake.respond = func(id string) []byte {
+		frame = `{"result":{"data":["marko"],"meta":{}},"requestId":"` + id + `","status":{"code":200,"attributes":{},"message":""}}`
+		return []byte(frame)
+	}
+
+	resp, err := c.Execute("g.V().values('name')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp[0].Raw()) != frame {
+		t.Errorf("Expected the raw frame %s, got %s", frame, resp[0].Raw())
+	}
+	var decoded struct {
+		Result struct{ Data []string }
+	}
+	if err := resp[0].Unmarshal(&decoded); err != nil || !reflect.DeepEqual(decoded.Result.Data, []string{"marko"}) {
+		t.Errorf("Expected the frame to decode into the caller's type, got %+v, %v", decoded, err)
+	}
+	if string(resp[0].Result.Data) != `["marko"]` {
+		t.Errorf("Expected the fields to be decoded as well, got %s", resp[0].Result.Data)
+	}
+}
+
+func TestUnmarshalWithoutRawResponse(t *testing.T) {
+	var v interface{}
+	if err := (Response{}).Unmarshal(&v); err != ErrNoRawResponse {
+		t.Errorf("Expected ErrNoRawResponse, got %v", err)
+	}
+}
+
+func TestAffectedCount(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		responses []Response
+		count     int64
+		ok        bool
+	}{
+		{"attributes", []Response{
+			{Status: Status{Code: 206, Attributes: map[string]interface{}{"affectedCount": float64(2)}}},
+			{Status: Status{Code: 200, Attributes: map[string]interface{}{"affectedCount": map[string]interface{}{"@type": "g:Int64", "@value": float64(3)}}}},
+		}, 5, true},
+		{"count result", []Response{{Result: Result{Data: json.RawMessage(`{"@type":"g:List","@value":[{"@type":"g:Int64","@value":4}]}`)}}}, 4, true},
+		{"zero count", []Response{{Result: Result{Data: json.RawMessage(`[0]`)}}}, 0, true},
+		{"drop", []Response{{Result: Result{Data: json.RawMessage(`{"@type":"g:List","@value":[]}`)}}}, 0, false},
+		{"vertex", []Response{{Result: Result{Data: json.RawMessage(`[{"id":1,"label":"person"}]`)}}}, 0, false},
+		{"no data", []Response{{}}, 0, false},
+	} {
+		if count, ok := AffectedCount(tc.responses); count != tc.count || ok != tc.ok {
+			t.Errorf("%s: expected %d, %t got %d, %t", tc.name, tc.count, tc.ok, count, ok)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package gremtune
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// scriptFiles caches the contents of script files, an entry is read again once the modification time or size of
+// its file changes
+type scriptFiles struct {
+	mu      sync.Mutex
+	scripts map[string]scriptFile
+}
+
+type scriptFile struct {
+	modTime time.Time
+	size    int64
+	script  string
+}
+
+func newScriptFiles() *scriptFiles {
+	return &scriptFiles{scripts: make(map[string]scriptFile)}
+}
+
+// load returns the script in the file at path, from the cache unless the file was modified since it was read
+func (s *scriptFiles) load(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	cached, ok := s.scripts[path]
+	s.mu.Unlock()
+	if ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.script, nil
+	}
+
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	script := string(d)
+	s.mu.Lock()
+	s.scripts[path] = scriptFile{modTime: info.ModTime(), size: info.Size(), script: script}
+	s.mu.Unlock()
+	return script, nil
+}
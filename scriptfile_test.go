@@ -0,0 +1,72 @@
+package gremtune
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestExecuteFileQuery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gremtune")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "person.groovy")
+	if err := ioutil.WriteFile(path, []byte("g.V().has('name', name)"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, fake := startFakeClient(t)
+	sent := func() Request {
+		requests := writtenRequests(t, fake)
+		return requests[len(requests)-1]
+	}
+
+	if _, err := c.ExecuteFileQuery(context.Background(), path, map[string]interface{}{"name": "marko"}); err != nil {
+		t.Fatal(err)
+	}
+	if req := sent(); req.Args["gremlin"] != "g.V().has('name', name)" {
+		t.Errorf("Unexpected script sent: %v", req.Args["gremlin"])
+	}
+
+	// A modified file is read again
+	ioutil.WriteFile(path, []byte("g.V().has('name', name).count()"), 0600)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	if _, err := c.ExecuteFileQuery(context.Background(), path, map[string]interface{}{"name": "marko"}); err != nil {
+		t.Fatal(err)
+	}
+	if req := sent(); req.Args["gremlin"] != "g.V().has('name', name).count()" {
+		t.Errorf("Expected the modified script to be sent, got: %v", req.Args["gremlin"])
+	}
+
+	if _, err := c.ExecuteFileQuery(context.Background(), filepath.Join(dir, "missing.groovy"), nil); !os.IsNotExist(errors.Cause(err)) {
+		t.Errorf("Expected a missing file to fail, got: %v", err)
+	}
+}
+
+func TestScriptFilesCache(t *testing.T) {
+	f, err := ioutil.TempFile("", "gremtune")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("g.V()")
+	f.Close()
+
+	s := newScriptFiles()
+	if script, err := s.load(f.Name()); err != nil || script != "g.V()" {
+		t.Fatalf("Unexpected script %q: %v", script, err)
+	}
+	// Unchanged files are served from the cache
+	s.scripts[f.Name()] = scriptFile{modTime: s.scripts[f.Name()].modTime, size: 5, script: "cached"}
+	if script, _ := s.load(f.Name()); script != "cached" {
+		t.Errorf("Expected the cached script, got %q", script)
+	}
+}
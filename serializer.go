@@ -0,0 +1,31 @@
+package gremtune
+
+import "encoding/json"
+
+// Serializer encodes requests for Gremlin Server and decodes the response frames it sends back.
+type Serializer interface {
+	Serialize(req Request) ([]byte, error)
+	Deserialize(msg []byte) (Response, error)
+}
+
+// GraphSONSerializer is the default Serializer, it speaks GraphSON 3.0 over application/vnd.gremlin-v3.0+json
+type GraphSONSerializer struct{}
+
+// Serialize formats a request into a mime type prefixed GraphSON frame, typed bindings are encoded as GraphSON values
+func (GraphSONSerializer) Serialize(req Request) ([]byte, error) {
+	if bindings, typed := req.Args["bindings"].(map[string]interface{}); typed {
+		args := make(map[string]interface{}, len(req.Args))
+		for k, v := range req.Args {
+			args[k] = v
+		}
+		args["bindings"] = encodeBindings(bindings)
+		req.Args = args
+	}
+	return packageRequest(req)
+}
+
+// Deserialize decodes a GraphSON frame into a Response
+func (GraphSONSerializer) Deserialize(msg []byte) (resp Response, err error) {
+	err = json.Unmarshal(msg, &resp)
+	return
+}
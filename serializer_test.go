@@ -0,0 +1,95 @@
+package gremtune
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gofrs/uuid"
+)
+
+// TestGraphSONSerializerRoundTrip tests that the default serializer matches the request packaging and response decoding
+func TestGraphSONSerializerRoundTrip(t *testing.T) {
+	req, _, err := prepareRequest("g.V()")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := GraphSONSerializer{}.Serialize(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := packageRequest(req)
+	if !reflect.DeepEqual(msg, expected) {
+		t.Error("Expected serializer to package the request like packageRequest")
+	}
+
+	resp, err := GraphSONSerializer{}.Deserialize(dummySuccessfulResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.RequestID != dummySuccessfulResponseMarshalled.RequestID || resp.Status.Code != statusSuccess {
+		t.Error("Expected requestId and code does not match actual.")
+	}
+}
+
+// TestGraphSONSerializerTypedBindings tests that typed bindings are sent as GraphSON values
+func TestGraphSONSerializerTypedBindings(t *testing.T) {
+	id := uuid.Must(uuid.FromString("1d6d02bd-8e56-421d-9438-3bd6d0079ff1"))
+	req, _, err := prepareRequestWithTypedBindings("g.V(x)", map[string]interface{}{"x": id}, map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := GraphSONSerializer{}.Serialize(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(msg), `"x":{"@type":"g:UUID","@value":"1d6d02bd-8e56-421d-9438-3bd6d0079ff1"}`) {
+		t.Errorf("Expected the binding to be encoded as g:UUID, got: %s", msg)
+	}
+
+	if _, ok := req.Args["bindings"].(map[string]interface{})["x"].(uuid.UUID); !ok {
+		t.Error("Expected serializing not to modify the request")
+	}
+}
+
+// recordingSerializer records the args of the requests it serializes
+type recordingSerializer struct {
+	GraphSONSerializer
+	args []map[string]interface{}
+}
+
+func (s *recordingSerializer) Serialize(req Request) ([]byte, error) {
+	s.args = append(s.args, req.Args)
+	return s.GraphSONSerializer.Serialize(req)
+}
+
+// TestInternalArgsNotSerialized tests that the args marking requests for the client never reach a serializer
+func TestInternalArgsNotSerialized(t *testing.T) {
+	c, _ := startFakeClient(t)
+	serializer := &recordingSerializer{}
+	SetSerializer(serializer)(c)
+
+	if _, err := c.ExecuteIdempotent("g.V()"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ExecuteUnshaped("g.V()"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.SerializeRequest(Request{Op: "eval", Args: map[string]interface{}{"gremlin": "g.V()", idempotentArg: true}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(serializer.args) != 3 {
+		t.Fatalf("Expected 3 requests to be serialized, got %d", len(serializer.args))
+	}
+	for _, args := range serializer.args {
+		for _, arg := range internalArgs {
+			if _, ok := args[arg]; ok {
+				t.Errorf("Expected %s not to be serialized, got %v", arg, args)
+			}
+		}
+	}
+}
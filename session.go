@@ -0,0 +1,223 @@
+package gremtune
+
+import (
+	"context"
+	"regexp"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// TransactionModel describes how a graph server treats the transaction of a session. Servers differ here, so
+// a session needs to know which model it is talking to in order to commit correctly.
+type TransactionModel int
+
+const (
+	// NeptuneTransactions is the model of AWS Neptune. Requests outside a session are committed one by one.
+	// Inside a session all requests share a single transaction, which Neptune commits when the session is
+	// closed and rolls back when a request of the session fails.
+	NeptuneTransactions TransactionModel = iota
+
+	// ExplicitTransactions is the model of JanusGraph and other TinkerPop based servers. Changes made inside a
+	// session are only persisted by an explicit g.tx().commit(), closing the session rolls back anything
+	// that was not committed.
+	ExplicitTransactions
+)
+
+const (
+	commitQuery   = "g.tx().commit()"
+	rollbackQuery = "g.tx().rollback()"
+)
+
+// ErrSessionClosed is returned when the server no longer knows a session, because it timed out or was evicted,
+// while the connection is still up. The session is closed on the client as well, open a new one to continue.
+var ErrSessionClosed = errors.New("the session has been closed by the server")
+
+// ErrSessionBusy is returned when a request is sent within a session while an earlier one is still in flight.
+// Gremlin Server does not define the outcome of concurrent requests within a session, see SetConcurrentSession.
+var ErrSessionBusy = errors.New("a request of the session is still in flight")
+
+// sessionClosedPattern matches the messages servers answer with for a session they do not know (anymore)
+var sessionClosedPattern = regexp.MustCompile(`(?i)no session named|session\b.*\b(not found|does not exist|has been closed|is closed|expired|timed out)`)
+
+// Session runs scripts in a Gremlin Server session, which keeps its state and transaction between requests.
+// All requests of a session are sent over the same client. A session runs one request at a time, requests sent
+// while another one is in flight fail with ErrSessionBusy unless the session is configured with
+// SetConcurrentSession.
+type Session struct {
+	client     *Client
+	id         string
+	model      TransactionModel
+	inFlight   int32 // inFlight is 1 while a request of a session without concurrent requests is in flight
+	concurrent bool
+	mu         sync.Mutex
+	closed     bool
+	release    func() // release is called once the session is closed, it unpins the connection of pooled sessions
+}
+
+// SessionConfig is the type for defining configuration for a session
+type SessionConfig func(*Session)
+
+// SetConcurrentSession allows requests of the session to be in flight at the same time, for servers known to
+// handle concurrent requests within a session.
+func SetConcurrentSession(concurrent bool) SessionConfig {
+	return func(s *Session) {
+		s.concurrent = concurrent
+	}
+}
+
+// SetTransactionModel sets the transaction model of the server, for sessions opened by InSession
+func SetTransactionModel(model TransactionModel) SessionConfig {
+	return func(s *Session) {
+		s.model = model
+	}
+}
+
+// NewSession opens a session on the client using the transaction model of the server.
+func (c *Client) NewSession(model TransactionModel, configs ...SessionConfig) *Session {
+	return newSession(c, newRequestID(), model, configs)
+}
+
+func newSession(c *Client, id string, model TransactionModel, configs []SessionConfig) *Session {
+	s := &Session{client: c, id: id, model: model}
+	for _, conf := range configs {
+		conf(s)
+	}
+	return s
+}
+
+// InSession runs fn within a new session, which is closed once fn is done. The changes made by fn are committed when
+// it returns nil, and rolled back when it returns an error or panics, or when ctx is done before the commit. A panic
+// is passed on after the rollback. The session uses ExplicitTransactions unless SetTransactionModel says otherwise.
+func (c *Client) InSession(ctx context.Context, fn func(s *Session) error, configs ...SessionConfig) (err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	s := c.NewSession(ExplicitTransactions, configs...)
+	defer func() {
+		if p := recover(); p != nil {
+			s.Rollback()
+			s.Close()
+			panic(p)
+		}
+	}()
+
+	if err = fn(s); err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		s.Rollback() // The error of fn is the one worth returning
+		s.Close()
+		return
+	}
+	if err = s.Commit(); err != nil {
+		s.Close()
+		return errors.Wrap(err, "committing session")
+	}
+	return s.Close()
+}
+
+// ID returns the id of the session
+func (s *Session) ID() string {
+	return s.id
+}
+
+// Execute formats a raw Gremlin query, sends it to Gremlin Server within the session, and returns the result.
+func (s *Session) Execute(query string) (resp []Response, err error) {
+	if s.isClosed() {
+		return nil, errors.New("you cannot execute on a closed session")
+	}
+	if !s.acquire() {
+		return nil, ErrSessionBusy
+	}
+	defer s.done()
+	if s.client.conn.IsDisposed() {
+		return resp, ErrDisposed
+	}
+	req, _, err := prepareSessionRequest(query, s.id)
+	if err != nil {
+		return
+	}
+	resp, err = s.client.roundTrip(req)
+	if err = s.checkClosed(err); err != nil {
+		err = s.client.queryError(err, query)
+	}
+	return
+}
+
+// checkClosed turns the error of a request into ErrSessionClosed when the server reports that it does not know the
+// session, closing the session on the client.
+func (s *Session) checkClosed(err error) error {
+	if err == nil || errors.Cause(err) == ErrReset || !sessionClosedPattern.MatchString(err.Error()) {
+		return err
+	}
+	s.markClosed()
+	return errors.Wrap(ErrSessionClosed, err.Error())
+}
+
+// acquire marks a request of the session as in flight, it fails while another one is unless requests may be
+// concurrent
+func (s *Session) acquire() bool {
+	return s.concurrent || atomic.CompareAndSwapInt32(&s.inFlight, 0, 1)
+}
+
+// done marks the request acquired before as finished
+func (s *Session) done() {
+	if !s.concurrent {
+		atomic.StoreInt32(&s.inFlight, 0)
+	}
+}
+
+func (s *Session) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// markClosed closes the session on the client and unpins its connection
+func (s *Session) markClosed() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.mu.Unlock()
+	if s.release != nil {
+		s.release()
+	}
+}
+
+// Commit persists the changes made in the session. With ExplicitTransactions this issues g.tx().commit() and the
+// session stays open. Neptune commits a session when it is closed, so with NeptuneTransactions Commit closes it.
+func (s *Session) Commit() (err error) {
+	if s.model == NeptuneTransactions {
+		return s.Close()
+	}
+	_, err = s.Execute(commitQuery)
+	return
+}
+
+// Rollback discards the changes made in the session since the last commit by issuing g.tx().rollback().
+func (s *Session) Rollback() (err error) {
+	_, err = s.Execute(rollbackQuery)
+	return
+}
+
+// Close closes the session on the server. Neptune commits the transaction of the session at this point, with
+// ExplicitTransactions the server rolls back anything that was not committed.
+func (s *Session) Close() (err error) {
+	if s.isClosed() {
+		return
+	}
+	if !s.acquire() {
+		return ErrSessionBusy
+	}
+	defer s.done()
+	req, _ := prepareSessionCloseRequest(s.id)
+	_, err = s.client.roundTrip(req)
+	err = s.checkClosed(err)
+	s.markClosed()
+	return errors.Wrap(err, "closing session")
+}
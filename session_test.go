@@ -0,0 +1,220 @@
+package gremtune
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// startFakeClient returns a client whose requests are answered successfully by a fake dialer
+func startFakeClient(t *testing.T) (*Client, *fakeDialer) {
+	c := newClient()
+	fake := &fakeDialer{respond: fakeSuccess, client: &c}
+	c.conn = fake
+	quit := make(chan struct{})
+	t.Cleanup(func() { close(quit) })
+	go c.writeWorker(make(chan error, 1), quit)
+	return &c, fake
+}
+
+func writtenRequests(t *testing.T, fake *fakeDialer) (requests []Request) {
+	for _, msg := range fake.written {
+		var req Request
+		if err := json.Unmarshal(msg[msg[0]+1:], &req); err != nil {
+			t.Fatal(err)
+		}
+		requests = append(requests, req)
+	}
+	return
+}
+
+func TestSessionExplicitCommit(t *testing.T) {
+	c, fake := startFakeClient(t)
+	s := c.NewSession(ExplicitTransactions)
+
+	if _, err := s.Execute("g.addV('person')"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := writtenRequests(t, fake)
+	if len(requests) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(requests))
+	}
+	for _, req := range requests {
+		if req.Processor != "session" || req.Args["session"] != s.ID() {
+			t.Errorf("Expected request to be sent within the session, got: %+v", req)
+		}
+	}
+	if requests[1].Args["gremlin"] != commitQuery {
+		t.Errorf("Expected an explicit commit, got: %v", requests[1].Args["gremlin"])
+	}
+}
+
+// sessionScripts returns the scripts and ops of the requests written within sessions
+func sessionScripts(t *testing.T, fake *fakeDialer) (scripts []string) {
+	for _, req := range writtenRequests(t, fake) {
+		if req.Op == "close" {
+			scripts = append(scripts, "close")
+		} else {
+			scripts = append(scripts, req.Args["gremlin"].(string))
+		}
+	}
+	return
+}
+
+func TestInSessionCommits(t *testing.T) {
+	c, fake := startFakeClient(t)
+	err := c.InSession(context.Background(), func(s *Session) error {
+		_, err := s.Execute("g.addV('person')")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scripts := sessionScripts(t, fake); !reflect.DeepEqual(scripts, []string{"g.addV('person')", commitQuery, "close"}) {
+		t.Errorf("Expected the session to be committed and closed, got %v", scripts)
+	}
+}
+
+func TestInSessionRollsBack(t *testing.T) {
+	c, fake := startFakeClient(t)
+	failed := errors.New("invalid person")
+	if err := c.InSession(context.Background(), func(s *Session) error { return failed }); err != failed {
+		t.Errorf("Expected the error of the callback, got %v", err)
+	}
+	if scripts := sessionScripts(t, fake); !reflect.DeepEqual(scripts, []string{rollbackQuery, "close"}) {
+		t.Errorf("Expected the session to be rolled back and closed, got %v", scripts)
+	}
+
+	fake.written = nil
+	func() {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Errorf("Expected the panic to be passed on, got %v", p)
+			}
+		}()
+		c.InSession(context.Background(), func(s *Session) error { panic("boom") })
+	}()
+	if scripts := sessionScripts(t, fake); !reflect.DeepEqual(scripts, []string{rollbackQuery, "close"}) {
+		t.Errorf("Expected the session to be rolled back and closed after a panic, got %v", scripts)
+	}
+}
+
+func TestSessionNeptuneCommit(t *testing.T) {
+	c, fake := startFakeClient(t)
+	s := c.NewSession(NeptuneTransactions)
+
+	if err := s.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := writtenRequests(t, fake)
+	if len(requests) != 1 || requests[0].Op != "close" || requests[0].Args["session"] != s.ID() {
+		t.Errorf("Expected Neptune commit to close the session, got: %+v", requests)
+	}
+
+	if _, err := s.Execute("g.V()"); err == nil {
+		t.Error("Expected execute on a closed session to fail")
+	}
+}
+
+func TestSessionClosedByServer(t *testing.T) {
+	c, fake := startFakeClient(t)
+	fake.respond = func(id string) []byte {
+		return []byte(`{"result":{"data":null,"meta":{}},"requestId":"` + id + `","status":{"code":500,"attributes":{},"message":"Session 1234 has expired"}}`)
+	}
+	s := c.NewSession(ExplicitTransactions)
+	released := false
+	s.release = func() { released = true }
+
+	_, err := s.Execute("g.V()")
+	if errors.Cause(err) != ErrSessionClosed {
+		t.Fatalf("Expected ErrSessionClosed, got %v", err)
+	}
+	if !s.closed || !released {
+		t.Error("Expected the session to be closed on the client")
+	}
+}
+
+func TestSessionQueryErrorKeepsSession(t *testing.T) {
+	c, fake := startFakeClient(t)
+	fake.respond = func(id string) []byte {
+		return []byte(`{"result":{"data":null,"meta":{}},"requestId":"` + id + `","status":{"code":597,"attributes":{},"message":"No such property: x"}}`)
+	}
+	s := c.NewSession(ExplicitTransactions)
+
+	_, err := s.Execute("g.V(x)")
+	if err == nil || errors.Cause(err) == ErrSessionClosed {
+		t.Fatalf("Expected a query error, got %v", err)
+	}
+	if s.closed {
+		t.Error("Expected the session to stay open after a query error")
+	}
+}
+
+// holdFirstResponse answers all requests but the first, whose id is sent on the returned channel
+func holdFirstResponse(fake *fakeDialer) <-chan string {
+	held := make(chan string, 1)
+	first := true
+	fake.respond = func(id string) []byte {
+		if first {
+			first = false
+			held <- id
+			return nil
+		}
+		return fakeSuccess(id)
+	}
+	return held
+}
+
+func TestSessionRejectsConcurrentRequests(t *testing.T) {
+	c, fake := startFakeClient(t)
+	held := holdFirstResponse(fake)
+	s := c.NewSession(ExplicitTransactions)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.Execute("g.V()")
+		done <- err
+	}()
+	id := <-held
+
+	if _, err := s.Execute("g.V()"); err != ErrSessionBusy {
+		t.Errorf("Expected ErrSessionBusy while a request is in flight, got %v", err)
+	}
+
+	c.handleResponse(fakeSuccess(id))
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Execute("g.V()"); err != nil {
+		t.Errorf("Expected the session to accept requests once the first one finished, got %v", err)
+	}
+}
+
+func TestConcurrentSession(t *testing.T) {
+	c, fake := startFakeClient(t)
+	held := holdFirstResponse(fake)
+	s := c.NewSession(ExplicitTransactions, SetConcurrentSession(true))
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.Execute("g.V()")
+		done <- err
+	}()
+	id := <-held
+
+	if _, err := s.Execute("g.V()"); err != nil {
+		t.Errorf("Expected concurrent requests to be allowed, got %v", err)
+	}
+	c.handleResponse(fakeSuccess(id))
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,124 @@
+package gremtune
+
+import (
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+const graphSONSet = "g:Set"
+
+// GremlinSet is a g:Set as returned by toSet() and some group steps. It keeps the values in the order the server
+// sent them, each value once, and looks up values of comparable types in constant time.
+type GremlinSet struct {
+	values []interface{}
+	index  map[interface{}]struct{}
+}
+
+// ToSet decodes the set returned by a traversal ending in toSet(). The sets of all response frames are merged.
+func ToSet(responses []Response) (GremlinSet, error) {
+	var set GremlinSet
+	for _, r := range responses {
+		if len(r.Result.Data) == 0 || string(r.Result.Data) == "null" {
+			continue
+		}
+		v, err := DecodeValue(r.Result.Data)
+		if err != nil {
+			return GremlinSet{}, err
+		}
+		values, ok := v.([]interface{})
+		if !ok {
+			values = []interface{}{v}
+		}
+		for _, value := range values {
+			s, ok := value.(GremlinSet)
+			if !ok {
+				return GremlinSet{}, errors.Errorf("expected a set, got %T", value)
+			}
+			for _, item := range s.values {
+				set.add(item)
+			}
+		}
+	}
+	return set, nil
+}
+
+// decodeSet decodes the @value of a g:Set, a list of values
+func decodeSet(data []byte) (GremlinSet, error) {
+	var set GremlinSet
+	v, err := DecodeValue(data)
+	if err != nil {
+		return set, err
+	}
+	values, ok := v.([]interface{})
+	if !ok {
+		return set, errors.Errorf("expected a list of values, got %T", v)
+	}
+	for _, value := range values {
+		set.add(value)
+	}
+	return set, nil
+}
+
+// add appends the value unless the set contains it already
+func (s *GremlinSet) add(v interface{}) {
+	if s.Contains(v) {
+		return
+	}
+	if isComparable(v) {
+		if s.index == nil {
+			s.index = make(map[interface{}]struct{})
+		}
+		s.index[v] = struct{}{}
+	}
+	s.values = append(s.values, v)
+}
+
+// Contains reports whether the set holds the value. Values of types which cannot be Go map keys, such as decoded
+// vertices, are compared one by one.
+func (s GremlinSet) Contains(v interface{}) bool {
+	if isComparable(v) {
+		_, ok := s.index[v]
+		return ok
+	}
+	for _, value := range s.values {
+		if reflect.DeepEqual(value, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Values returns the values of the set in the order the server sent them
+func (s GremlinSet) Values() []interface{} {
+	return s.values
+}
+
+// Len returns the number of values in the set
+func (s GremlinSet) Len() int {
+	return len(s.values)
+}
+
+// ToIDSet returns the ids of the set as a map, for sets of vertex or edge ids or of the elements themselves. Values
+// which are not elements are used as ids as they are, it fails for values which cannot be used as Go map keys.
+func (s GremlinSet) ToIDSet() (map[interface{}]struct{}, error) {
+	ids := make(map[interface{}]struct{}, len(s.values))
+	for _, v := range s.values {
+		id := v
+		if element, ok := v.(map[string]interface{}); ok {
+			if elementID, ok := element["id"]; ok {
+				id = elementID
+			}
+		}
+		if !isComparable(id) {
+			return nil, errors.Errorf("id of type %T cannot be used as a Go map key", id)
+		}
+		ids[id] = struct{}{}
+	}
+	return ids, nil
+}
+
+// isComparable reports whether v can be used as a Go map key
+func isComparable(v interface{}) bool {
+	return v == nil || reflect.TypeOf(v).Comparable()
+}
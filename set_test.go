@@ -0,0 +1,52 @@
+package gremtune
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestToSet(t *testing.T) {
+	responses := []Response{
+		{Result: Result{Data: json.RawMessage(`{"@type":"g:List","@value":[{"@type":"g:Set","@value":["marko",{"@type":"g:Int64","@value":1},"josh"]}]}`)}},
+		{Result: Result{Data: json.RawMessage(`{"@type":"g:List","@value":[{"@type":"g:Set","@value":["josh","lop"]}]}`)}},
+	}
+	set, err := ToSet(responses)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(set.Values(), []interface{}{"marko", int64(1), "josh", "lop"}) || set.Len() != 4 {
+		t.Errorf("Expected the values of both frames in order and once, got %v", set.Values())
+	}
+	if !set.Contains("lop") || !set.Contains(int64(1)) || set.Contains(int32(1)) || set.Contains("vadas") {
+		t.Error("Unexpected results of Contains")
+	}
+}
+
+func TestToSetWrongType(t *testing.T) {
+	if _, err := ToSet([]Response{{Result: Result{Data: json.RawMessage(`["marko"]`)}}}); err == nil {
+		t.Error("Expected an error for a result which is not a set")
+	}
+}
+
+func TestGremlinSetToIDSet(t *testing.T) {
+	v, err := DecodeValue(json.RawMessage(`{"@type":"g:Set","@value":[
+    {"@type":"g:Vertex","@value":{"id":{"@type":"g:Int64","@value":1},"label":"person"}},
+    {"@type":"g:Int64","@value":2}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := v.(GremlinSet)
+	if !set.Contains(map[string]interface{}{"id": int64(1), "label": "person"}) {
+		t.Error("Expected the set to contain the vertex")
+	}
+
+	ids, err := set.ToIDSet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, map[interface{}]struct{}{int64(1): {}, int64(2): {}}) {
+		t.Errorf("Unexpected ids: %v", ids)
+	}
+}
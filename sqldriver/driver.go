@@ -0,0 +1,304 @@
+// Package sqldriver adapts gremtune to database/sql, so Gremlin Server can be used with the connection management
+// of sql.DB. Queries are Gremlin scripts rather than SQL, their arguments are passed as bindings. Every result of a
+// query is a row with the single column "result".
+//
+//	db, err := sql.Open("gremlin", "ws://127.0.0.1:8182")
+//	rows, err := db.QueryContext(ctx, "g.V().has('name', name).values('age')", sql.Named("name", "marko"))
+//
+// Transactions are not supported, use gremtune sessions for them.
+package sqldriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	"github.com/schwartzmx/gremtune"
+)
+
+// DriverName is the name the driver is registered with at database/sql
+const DriverName = "gremlin"
+
+func init() {
+	sql.Register(DriverName, Driver{})
+}
+
+// ErrTransactionsNotSupported is returned when a transaction is started on a Gremlin connection
+var ErrTransactionsNotSupported = errors.New("transactions are not supported, use gremtune sessions")
+
+// Driver opens connections to the Gremlin Server whose host is given as the data source name
+type Driver struct{}
+
+// Open opens a new connection to the host
+func (d Driver) Open(host string) (driver.Conn, error) {
+	return NewConnector(host).Connect(context.Background())
+}
+
+// OpenConnector returns a connector for the host, which database/sql uses to open its connections
+func (d Driver) OpenConnector(host string) (driver.Connector, error) {
+	return NewConnector(host), nil
+}
+
+// Connector opens connections to a Gremlin Server configured with dialer and client configs. Use it with
+// sql.OpenDB for settings which cannot be expressed by the data source name.
+type Connector struct {
+	host    string
+	dialer  []gremtune.DialerConfig
+	clients []gremtune.ClientConfig
+}
+
+// NewConnector returns a connector for the host, whose connections are dialed with the configs
+func NewConnector(host string, configs ...gremtune.DialerConfig) *Connector {
+	return &Connector{host: host, dialer: configs}
+}
+
+// WithClientConfigs sets the configs of the clients connections are made with
+func (c *Connector) WithClientConfigs(configs ...gremtune.ClientConfig) *Connector {
+	c.clients = configs
+	return c
+}
+
+// Connect dials a new connection
+func (c *Connector) Connect(ctx context.Context) (driver.Conn, error) {
+	errs := make(chan error, 1)
+	client, err := gremtune.Dial(gremtune.NewDialer(c.host, c.dialer...), errs, c.clients...)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{client: client, closed: make(chan struct{})}
+	go cn.discardErrors(errs)
+	return cn, nil
+}
+
+// Driver returns the driver of the connector
+func (c *Connector) Driver() driver.Driver {
+	return Driver{}
+}
+
+// conn is a database/sql connection over a gremtune client
+type conn struct {
+	client *gremtune.Client
+	closed chan struct{}
+}
+
+// discardErrors keeps the workers of the client from blocking on their error channel, failures surface as errors
+// of the queries and through ResetSession instead
+func (c *conn) discardErrors(errs chan error) {
+	for {
+		select {
+		case <-errs:
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{conn: c, query: query}, nil
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return c.Prepare(query)
+}
+
+func (c *conn) Close() error {
+	defer close(c.closed)
+	return c.client.Close()
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return nil, ErrTransactionsNotSupported
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return nil, ErrTransactionsNotSupported
+}
+
+// Ping sends a cheap query to the server, as sql.DB does to verify a connection
+func (c *conn) Ping(ctx context.Context) error {
+	if err := c.client.Ping(ctx); err != nil {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+// ResetSession is called by sql.DB before a connection is reused, errored connections are discarded
+func (c *conn) ResetSession(ctx context.Context) error {
+	if c.client.IsErrored() {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	results, err := c.execute(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{results: results}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	results, err := c.execute(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(len(results)), nil
+}
+
+// CheckNamedValue accepts arguments of any type, they are sent as bindings
+func (c *conn) CheckNamedValue(v *driver.NamedValue) error {
+	return nil
+}
+
+// execute runs the query with its arguments as bindings and returns all results of all response frames
+func (c *conn) execute(ctx context.Context, query string, args []driver.NamedValue) ([]interface{}, error) {
+	bindings := make(map[string]interface{}, len(args))
+	for _, arg := range args {
+		name := arg.Name
+		if name == "" { // Positional arguments are bound as p1, p2, ...
+			name = fmt.Sprintf("p%d", arg.Ordinal)
+		}
+		bindings[name] = arg.Value
+	}
+
+	responses, err := c.client.ExecuteContext(ctx, query, bindings)
+	if err != nil {
+		return nil, err
+	}
+	var results []interface{}
+	for _, r := range responses {
+		if len(r.Result.Data) == 0 || string(r.Result.Data) == "null" {
+			continue
+		}
+		v, err := gremtune.DecodeValue(r.Result.Data)
+		if err != nil {
+			return nil, err
+		}
+		if values, ok := v.([]interface{}); ok {
+			results = append(results, values...)
+		} else {
+			results = append(results, v)
+		}
+	}
+	return results, nil
+}
+
+// stmt is a prepared query, which is only sent once executed
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+// NumInput returns -1, as the number of bindings of a script is not known
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, namedValues(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, namedValues(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.ExecContext(ctx, s.query, args)
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.QueryContext(ctx, s.query, args)
+}
+
+func (s *stmt) CheckNamedValue(v *driver.NamedValue) error {
+	return nil
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return named
+}
+
+// rows returns every result as a row with the single column "result"
+type rows struct {
+	results []interface{}
+	next    int
+}
+
+func (r *rows) Columns() []string {
+	return []string{"result"}
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.next >= len(r.results) {
+		return io.EOF
+	}
+	v, err := driverValue(r.results[r.next])
+	if err != nil {
+		return err
+	}
+	dest[0] = v
+	r.next++
+	return nil
+}
+
+// driverValue converts a decoded result into a value database/sql can scan. Numbers are widened to int64 and
+// float64, UUIDs become strings and values without a database/sql type, such as vertices, are encoded as JSON.
+func driverValue(v interface{}) (driver.Value, error) {
+	switch value := v.(type) {
+	case nil, int64, float64, bool, string, []byte, time.Time:
+		return value, nil
+	case int32:
+		return int64(value), nil
+	case float32:
+		return float64(value), nil
+	case uuid.UUID:
+		return value.String(), nil
+	default:
+		return json.Marshal(jsonValue(value))
+	}
+}
+
+// jsonValue converts decoded maps with keys of any type into maps JSON can encode
+func jsonValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			m[fmt.Sprint(k)] = jsonValue(item)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			m[k] = jsonValue(item)
+		}
+		return m
+	case []interface{}:
+		items := make([]interface{}, len(value))
+		for i, item := range value {
+			items[i] = jsonValue(item)
+		}
+		return items
+	default:
+		return v
+	}
+}
@@ -0,0 +1,122 @@
+package sqldriver
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestServer starts a Gremlin Server stand in answering every request with the result of respond
+func newTestServer(t *testing.T, respond func(req map[string]interface{}) string) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var req struct {
+				RequestID string                 `json:"requestId"`
+				Args      map[string]interface{} `json:"args"`
+			}
+			if err := json.Unmarshal(msg[msg[0]+1:], &req); err != nil {
+				t.Error(err)
+				return
+			}
+			data := respond(req.Args)
+			conn.WriteMessage(websocket.BinaryMessage, []byte(`{"result":{"data":`+data+`,"meta":{}},"requestId":"`+req.RequestID+`","status":{"code":200,"attributes":{},"message":""}}`))
+		}
+	}))
+}
+
+func openTestDB(t *testing.T, respond func(req map[string]interface{}) string) *sql.DB {
+	s := newTestServer(t, respond)
+	t.Cleanup(s.Close)
+	db, err := sql.Open(DriverName, "ws"+strings.TrimPrefix(s.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestQuery(t *testing.T) {
+	var bindings map[string]interface{}
+	db := openTestDB(t, func(args map[string]interface{}) string {
+		if args["gremlin"] == "g.inject(0).count()" { // Ping
+			return `[{"@type":"g:Int64","@value":1}]`
+		}
+		bindings, _ = args["bindings"].(map[string]interface{})
+		return `{"@type":"g:List","@value":[{"@type":"g:Int32","@value":29},{"@type":"g:Int32","@value":32}]}`
+	})
+	if err := db.PingContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.QueryContext(context.Background(), "g.V().has('name', name).values('age')", sql.Named("name", "marko"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var ages []int
+	for rows.Next() {
+		var age int
+		if err := rows.Scan(&age); err != nil {
+			t.Fatal(err)
+		}
+		ages = append(ages, age)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ages) != 2 || ages[0] != 29 || ages[1] != 32 {
+		t.Errorf("Expected a row per result, got %v", ages)
+	}
+	if bindings["name"] != "marko" {
+		t.Errorf("Expected the named argument to be bound, got %v", bindings)
+	}
+}
+
+func TestQueryElementsAsJSON(t *testing.T) {
+	db := openTestDB(t, func(args map[string]interface{}) string {
+		return `{"@type":"g:List","@value":[{"@type":"g:Vertex","@value":{"id":{"@type":"g:Int64","@value":1},"label":"person"}}]}`
+	})
+
+	var vertex string
+	if err := db.QueryRow("g.V(p1)", 1).Scan(&vertex); err != nil {
+		t.Fatal(err)
+	}
+	if vertex != `{"id":1,"label":"person"}` {
+		t.Errorf("Expected the vertex as JSON, got %s", vertex)
+	}
+}
+
+func TestExec(t *testing.T) {
+	db := openTestDB(t, func(args map[string]interface{}) string {
+		return `{"@type":"g:List","@value":[{"@type":"g:Int64","@value":1},{"@type":"g:Int64","@value":2}]}`
+	})
+
+	result, err := db.Exec("g.V().drop()")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := result.RowsAffected(); err != nil || n != 2 {
+		t.Errorf("Expected 2 results to be reported as affected, got %d, %v", n, err)
+	}
+	if _, err := db.Begin(); err != ErrTransactionsNotSupported {
+		t.Errorf("Expected transactions to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package gremtune
+
+import (
+	"sync"
+	"time"
+)
+
+// Stats is a snapshot of the lifetime counters of a Client.
+type Stats struct {
+	Requests   int64         // Requests is the number of requests sent to Gremlin Server
+	Errors     map[int]int64 // Errors counts the error responses by status code, 0 counts undecodable responses
+	Reconnects int64         // Reconnects is the number of successful resets of the connection
+	// ReadErrors and WriteErrors count the reads and writes which failed on the connection, PingFailures the pings
+	// which could not be sent. A rising rate of them points at a degrading connection before it fails for good.
+	ReadErrors   int64
+	WriteErrors  int64
+	PingFailures int64
+	InFlight     int64 // InFlight is the number of requests currently awaiting their response
+	BytesIn      int64 // BytesIn is the size of all response frames received
+	BytesOut     int64 // BytesOut is the size of all request frames written
+	// RequestSizes and ResponseSizes count the frames written and received per SizeBuckets bucket. Their last
+	// element counts the frames larger than the largest bucket.
+	RequestSizes  []int64
+	ResponseSizes []int64
+	Uptime        time.Duration // Uptime is the time since the connection was last established
+}
+
+// clientStats maintains the counters behind Stats. A nil clientStats ignores all updates.
+type clientStats struct {
+	mu          sync.Mutex
+	requests    int64
+	errors      map[int]int64
+	reconnects  int64
+	readErrors  int64
+	writeErrors int64
+	bytesIn     int64
+	bytesOut    int64
+	sizesIn     []int64
+	sizesOut    []int64
+	connectedAt time.Time
+}
+
+func newClientStats() *clientStats {
+	return &clientStats{
+		errors:   make(map[int]int64),
+		sizesIn:  make([]int64, len(SizeBuckets)+1),
+		sizesOut: make([]int64, len(SizeBuckets)+1),
+	}
+}
+
+func (s *clientStats) update(fn func(s *clientStats)) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	fn(s)
+	s.mu.Unlock()
+}
+
+func (s *clientStats) requestStarted() {
+	s.update(func(s *clientStats) { s.requests++ })
+}
+
+func (s *clientStats) responseError(code int) {
+	s.update(func(s *clientStats) { s.errors[code]++ })
+}
+
+func (s *clientStats) readFailed() {
+	s.update(func(s *clientStats) { s.readErrors++ })
+}
+
+func (s *clientStats) writeFailed() {
+	s.update(func(s *clientStats) { s.writeErrors++ })
+}
+
+func (s *clientStats) received(n int) {
+	s.update(func(s *clientStats) { s.bytesIn += int64(n); s.sizesIn[sizeBucket(n)]++ })
+}
+
+func (s *clientStats) written(n int) {
+	s.update(func(s *clientStats) { s.bytesOut += int64(n); s.sizesOut[sizeBucket(n)]++ })
+}
+
+func (s *clientStats) connected(reconnect bool) {
+	s.update(func(s *clientStats) {
+		s.connectedAt = time.Now()
+		if reconnect {
+			s.reconnects++
+		}
+	})
+}
+
+// snapshot copies the counters into a Stats value. InFlight and PingFailures are counted by the client and its
+// connection, which fill them in.
+func (s *clientStats) snapshot() (stats Stats) {
+	stats.Errors = make(map[int]int64)
+	s.update(func(s *clientStats) {
+		stats.Requests = s.requests
+		stats.Reconnects = s.reconnects
+		stats.ReadErrors = s.readErrors
+		stats.WriteErrors = s.writeErrors
+		stats.BytesIn = s.bytesIn
+		stats.BytesOut = s.bytesOut
+		stats.RequestSizes = append([]int64(nil), s.sizesOut...)
+		stats.ResponseSizes = append([]int64(nil), s.sizesIn...)
+		for code, n := range s.errors {
+			stats.Errors[code] = n
+		}
+		if !s.connectedAt.IsZero() {
+			stats.Uptime = time.Since(s.connectedAt)
+		}
+	})
+	return
+}
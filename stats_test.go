@@ -0,0 +1,149 @@
+package gremtune
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestStats(t *testing.T) {
+	c := newClient()
+	c.conn = &fakeDialer{respond: fakeSuccess, client: &c}
+	c.stats.connected(false)
+
+	if _, err := c.executeDirect("g.V().count()"); err != nil {
+		t.Fatal(err)
+	}
+	c.handleResponse([]byte(`{"requestId":"failed","status":{"code":597}}`))
+
+	stats := c.Stats()
+	if stats.Requests != 1 || stats.InFlight != 0 {
+		t.Errorf("Unexpected request counters: %+v", stats)
+	}
+	if c.InFlight() != 0 {
+		t.Errorf("Expected no request in flight, got %d", c.InFlight())
+	}
+	if stats.BytesOut == 0 || stats.BytesIn == 0 {
+		t.Errorf("Expected bytes to be counted, got: %+v", stats)
+	}
+	if stats.Errors[statusScriptEvaluationError] != 1 {
+		t.Errorf("Expected 1 script evaluation error, got: %v", stats.Errors)
+	}
+	if stats.Uptime <= 0 || stats.Uptime > time.Minute {
+		t.Errorf("Unexpected uptime: %s", stats.Uptime)
+	}
+}
+
+func TestStatsConnectionErrors(t *testing.T) {
+	c := newClient()
+	c.conn = &fakeDialer{client: &c}
+	errs := make(chan error, 10)
+
+	c.writeRequest(errs, []byte("\x01a{")) // Rejected by the fake connection as malformed
+	c.connectionLost(errs, io.ErrUnexpectedEOF)
+	c.connectionLost(errs, errClosedByClient) // Closed on purpose, not a read error
+
+	if stats := c.Stats(); stats.WriteErrors != 1 || stats.ReadErrors != 1 {
+		t.Errorf("Expected 1 write and 1 read error, got %+v", stats)
+	}
+}
+
+func TestStatsPingFailures(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+
+	ws := NewDialer(testServerHost(s))
+	if err := ws.connect(); err != nil {
+		t.Fatal(err)
+	}
+	ws.conn.Close() // Pings cannot be written anymore
+	ws.pingInterval = 5 * time.Millisecond
+	errs := make(chan error, 100)
+	go ws.ping(errs)
+	<-errs
+	ws.close()
+
+	c := newClient()
+	c.conn = ws
+	if stats := c.Stats(); stats.PingFailures < 1 {
+		t.Errorf("Expected the failed ping to be counted, got %d", stats.PingFailures)
+	}
+}
+
+func TestStatsInFlight(t *testing.T) {
+	c := newClient()
+	c.requestStarted()
+
+	if stats := c.Stats(); stats.InFlight != 1 || c.InFlight() != 1 {
+		t.Errorf("Expected the stats and the client to report the same request in flight, got %d and %d", stats.InFlight, c.InFlight())
+	}
+	c.requestFinished()
+	if stats := c.Stats(); stats.InFlight != 0 {
+		t.Errorf("Expected no request in flight, got %d", stats.InFlight)
+	}
+}
+
+func TestStatsWithoutCounters(t *testing.T) {
+	c := &Client{}
+	c.stats.requestStarted()
+
+	if stats := c.Stats(); stats.Requests != 0 {
+		t.Errorf("Expected a client without counters to report nothing, got: %+v", stats)
+	}
+}
+
+// recordingCollector is a MetricsCollector remembering every observation
+type recordingCollector struct {
+	mu           sync.Mutex
+	observations map[string][]float64
+}
+
+func (r *recordingCollector) Observe(metric string, value float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.observations == nil {
+		r.observations = make(map[string][]float64)
+	}
+	r.observations[metric] = append(r.observations[metric], value)
+}
+
+func TestFrameSizeMetrics(t *testing.T) {
+	s := newTestServer(t, func(conn *websocket.Conn, msg []byte) {
+		var req Request
+		json.Unmarshal(msg[msg[0]+1:], &req)
+		conn.WriteMessage(websocket.BinaryMessage, fakeSuccess(req.RequestID))
+	})
+	defer s.Close()
+
+	collector := &recordingCollector{}
+	c := startTestClient(t, NewDialer(testServerHost(s)), make(chan error, 1), SetMetricsCollector(collector))
+	defer c.Shutdown(context.Background())
+
+	if _, err := c.Execute("g.V()"); err != nil {
+		t.Fatal(err)
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	if len(collector.observations[MetricRequestBytes]) != 1 || len(collector.observations[MetricResponseBytes]) != 1 {
+		t.Errorf("Expected one observation of each frame size, got %v", collector.observations)
+	}
+
+	stats := c.Stats()
+	if len(stats.RequestSizes) != len(SizeBuckets)+1 || stats.RequestSizes[0] != 1 || stats.ResponseSizes[0] != 1 {
+		t.Errorf("Expected the small frames to be counted in the first bucket, got %v and %v", stats.RequestSizes, stats.ResponseSizes)
+	}
+}
+
+func TestSizeBucket(t *testing.T) {
+	for n, want := range map[int]int{0: 0, 1024: 0, 1025: 1, 200 << 10: 3, 20 << 20: len(SizeBuckets)} {
+		if got := sizeBucket(n); got != want {
+			t.Errorf("Expected %d bytes in bucket %d, got %d", n, want, got)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package gremtune
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Traversal is a minimal fluent builder for common Gremlin steps. Literal arguments are never
+// interpolated into the script, each one is sent to Gremlin Server as a typed binding instead, so that
+// numbers, booleans and UUIDs keep their type on the server.
+type Traversal struct {
+	steps    []string
+	bindings map[string]interface{}
+}
+
+// G starts a new traversal from the graph traversal source g.
+func G() *Traversal {
+	return &Traversal{steps: []string{"g"}, bindings: make(map[string]interface{})}
+}
+
+// bind registers a literal argument and returns the binding name to use in its place
+func (t *Traversal) bind(value interface{}) string {
+	name := fmt.Sprintf("_p%d", len(t.bindings))
+	t.bindings[name] = value
+	return name
+}
+
+// step appends a step whose arguments are all bound literals
+func (t *Traversal) step(name string, args ...interface{}) *Traversal {
+	names := make([]string, len(args))
+	for i, arg := range args {
+		names[i] = t.bind(arg)
+	}
+	t.steps = append(t.steps, fmt.Sprintf("%s(%s)", name, strings.Join(names, ", ")))
+	return t
+}
+
+// labelStep appends a step taking labels or property keys
+func (t *Traversal) labelStep(name string, labels []string) *Traversal {
+	args := make([]interface{}, len(labels))
+	for i, label := range labels {
+		args[i] = label
+	}
+	return t.step(name, args...)
+}
+
+// V adds a V step for the given vertex ids, or all vertices when no id is given.
+func (t *Traversal) V(ids ...interface{}) *Traversal {
+	return t.step("V", ids...)
+}
+
+// E adds an E step for the given edge ids, or all edges when no id is given.
+func (t *Traversal) E(ids ...interface{}) *Traversal {
+	return t.step("E", ids...)
+}
+
+// Has adds a has step filtering on a property key and value.
+func (t *Traversal) Has(key string, value interface{}) *Traversal {
+	return t.step("has", key, value)
+}
+
+// HasLabel adds a hasLabel step filtering on the given labels.
+func (t *Traversal) HasLabel(labels ...string) *Traversal {
+	return t.labelStep("hasLabel", labels)
+}
+
+// HasNot adds a hasNot step filtering out elements with the property key.
+func (t *Traversal) HasNot(key string) *Traversal {
+	return t.step("hasNot", key)
+}
+
+// Out adds an out step following outgoing edges with the given labels.
+func (t *Traversal) Out(labels ...string) *Traversal {
+	return t.labelStep("out", labels)
+}
+
+// In adds an in step following incoming edges with the given labels.
+func (t *Traversal) In(labels ...string) *Traversal {
+	return t.labelStep("in", labels)
+}
+
+// Both adds a both step following edges in either direction with the given labels.
+func (t *Traversal) Both(labels ...string) *Traversal {
+	return t.labelStep("both", labels)
+}
+
+// OutE adds an outE step moving to the outgoing edges with the given labels.
+func (t *Traversal) OutE(labels ...string) *Traversal {
+	return t.labelStep("outE", labels)
+}
+
+// InE adds an inE step moving to the incoming edges with the given labels.
+func (t *Traversal) InE(labels ...string) *Traversal {
+	return t.labelStep("inE", labels)
+}
+
+// OutV adds an outV step moving from edges to their outgoing vertex.
+func (t *Traversal) OutV() *Traversal {
+	return t.step("outV")
+}
+
+// InV adds an inV step moving from edges to their incoming vertex.
+func (t *Traversal) InV() *Traversal {
+	return t.step("inV")
+}
+
+// Values adds a values step returning the given property values.
+func (t *Traversal) Values(keys ...string) *Traversal {
+	return t.labelStep("values", keys)
+}
+
+// ValueMap adds a valueMap step returning the given properties, or all when no key is given.
+func (t *Traversal) ValueMap(keys ...string) *Traversal {
+	return t.labelStep("valueMap", keys)
+}
+
+// ID adds an id step.
+func (t *Traversal) ID() *Traversal {
+	return t.step("id")
+}
+
+// Label adds a label step.
+func (t *Traversal) Label() *Traversal {
+	return t.step("label")
+}
+
+// Property adds a property step setting a property of the current element.
+func (t *Traversal) Property(key string, value interface{}) *Traversal {
+	return t.step("property", key, value)
+}
+
+// AddV adds an addV step creating a vertex with the given label.
+func (t *Traversal) AddV(label string) *Traversal {
+	return t.step("addV", label)
+}
+
+// AddE adds an addE step creating an edge with the given label, see From and To.
+func (t *Traversal) AddE(label string) *Traversal {
+	return t.step("addE", label)
+}
+
+// From adds a from step starting the edge being added at the vertex with the id.
+func (t *Traversal) From(vertexID interface{}) *Traversal {
+	t.steps = append(t.steps, fmt.Sprintf("from(__.V(%s))", t.bind(vertexID)))
+	return t
+}
+
+// To adds a to step ending the edge being added at the vertex with the id.
+func (t *Traversal) To(vertexID interface{}) *Traversal {
+	t.steps = append(t.steps, fmt.Sprintf("to(__.V(%s))", t.bind(vertexID)))
+	return t
+}
+
+// As adds an as step labelling the current step for Select.
+func (t *Traversal) As(label string) *Traversal {
+	return t.step("as", label)
+}
+
+// Select adds a select step returning the steps labelled with As.
+func (t *Traversal) Select(labels ...string) *Traversal {
+	return t.labelStep("select", labels)
+}
+
+// Dedup adds a dedup step.
+func (t *Traversal) Dedup() *Traversal {
+	return t.step("dedup")
+}
+
+// Count adds a count step.
+func (t *Traversal) Count() *Traversal {
+	return t.step("count")
+}
+
+// Fold adds a fold step.
+func (t *Traversal) Fold() *Traversal {
+	return t.step("fold")
+}
+
+// Drop adds a drop step removing the current elements.
+func (t *Traversal) Drop() *Traversal {
+	return t.step("drop")
+}
+
+// Limit adds a limit step.
+func (t *Traversal) Limit(n int) *Traversal {
+	t.steps = append(t.steps, fmt.Sprintf("limit(%d)", n))
+	return t
+}
+
+// Range adds a range step.
+func (t *Traversal) Range(low, high int) *Traversal {
+	t.steps = append(t.steps, fmt.Sprintf("range(%d, %d)", low, high))
+	return t
+}
+
+// Build returns the parameterized Gremlin script and the typed bindings it references, ready for
+// ExecuteWithTypedBindings.
+func (t *Traversal) Build() (query string, bindings map[string]interface{}) {
+	bindings = make(map[string]interface{}, len(t.bindings))
+	for k, v := range t.bindings {
+		bindings[k] = v
+	}
+	return t.String(), bindings
+}
+
+// String returns the parameterized Gremlin script of the traversal
+func (t *Traversal) String() string {
+	return strings.Join(t.steps, ".")
+}
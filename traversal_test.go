@@ -0,0 +1,83 @@
+package gremtune
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestTraversalBuild tests that literal arguments of a traversal are bound instead of interpolated
+func TestTraversalBuild(t *testing.T) {
+	query, bindings := G().V().Has("name", "marko").Out("knows").Values("age").Limit(2).Build()
+
+	expectedQuery := "g.V().has(_p0, _p1).out(_p2).values(_p3).limit(2)"
+	if query != expectedQuery {
+		t.Errorf("Unexpected query, expected: %s got: %s", expectedQuery, query)
+	}
+
+	expectedBindings := map[string]interface{}{"_p0": "name", "_p1": "marko", "_p2": "knows", "_p3": "age"}
+	if !reflect.DeepEqual(bindings, expectedBindings) {
+		t.Errorf("Unexpected bindings, expected: %v got: %v", expectedBindings, bindings)
+	}
+}
+
+// TestTraversalBuildInjection tests that script fragments passed as literals stay inside the bindings
+func TestTraversalBuildInjection(t *testing.T) {
+	query, bindings := G().V("1').drop().V('").Count().Build()
+
+	if query != "g.V(_p0).count()" {
+		t.Errorf("Unexpected query: %s", query)
+	}
+	if bindings["_p0"] != "1').drop().V('" {
+		t.Errorf("Expected literal to be bound unchanged, got: %v", bindings["_p0"])
+	}
+}
+
+// TestTraversalTypedBindings tests that literal arguments keep their type in the bindings
+func TestTraversalTypedBindings(t *testing.T) {
+	query, bindings := G().V(1).Has("age", 29).Has("active", true).Build()
+
+	if query != "g.V(_p0).has(_p1, _p2).has(_p3, _p4)" {
+		t.Errorf("Unexpected query: %s", query)
+	}
+	expectedBindings := map[string]interface{}{"_p0": 1, "_p1": "age", "_p2": 29, "_p3": "active", "_p4": true}
+	if !reflect.DeepEqual(bindings, expectedBindings) {
+		t.Errorf("Unexpected bindings, expected: %v got: %v", expectedBindings, bindings)
+	}
+}
+
+// TestTraversalAddE tests that the vertices of an added edge are bound
+func TestTraversalAddE(t *testing.T) {
+	query, bindings := G().V(int64(1)).AddE("knows").To(int64(2)).Property("since", 2010).Build()
+
+	if query != "g.V(_p0).addE(_p1).to(__.V(_p2)).property(_p3, _p4)" {
+		t.Errorf("Unexpected query: %s", query)
+	}
+	if bindings["_p2"] != int64(2) {
+		t.Errorf("Expected the vertex id to be bound, got: %v", bindings["_p2"])
+	}
+}
+
+// TestQuerySource tests that the Query source starts the same traversal as G
+func TestQuerySource(t *testing.T) {
+	query, bindings := Query.V().HasLabel("person').drop().V('").Count().Build()
+
+	if query != "g.V().hasLabel(_p0).count()" {
+		t.Errorf("Unexpected query: %s", query)
+	}
+	if bindings["_p0"] != "person').drop().V('" {
+		t.Errorf("Expected literal to be bound unchanged, got: %v", bindings["_p0"])
+	}
+}
+
+// TestExecuteTraversalTypedBindings tests that numeric ids are not sent to the server as strings
+func TestExecuteTraversalTypedBindings(t *testing.T) {
+	c, fake := startFakeClient(t)
+
+	if _, err := c.ExecuteTraversal(G().V(1).Values("name")); err != nil {
+		t.Fatal(err)
+	}
+	bindings, _ := writtenRequests(t, fake)[0].Args["bindings"].(map[string]interface{})
+	if _, ok := bindings["_p0"].(string); ok || bindings["_p0"] == nil {
+		t.Errorf("Expected the vertex id to be sent as a number, got %#v", bindings["_p0"])
+	}
+}
@@ -0,0 +1,103 @@
+package gremtune
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+const graphSONTree = "g:Tree"
+
+// Tree is a g:Tree as returned by the tree() step. The root of a decoded tree has no key, its children are the
+// roots of the traversed paths.
+type Tree struct {
+	Key      interface{}
+	Children []*Tree
+}
+
+// ToTree decodes the tree returned by a tree() traversal. The trees of all response frames are merged below a
+// single root.
+func ToTree(responses []Response) (*Tree, error) {
+	root := &Tree{}
+	for _, r := range responses {
+		if len(r.Result.Data) == 0 || string(r.Result.Data) == "null" {
+			continue
+		}
+		v, err := DecodeValue(r.Result.Data)
+		if err != nil {
+			return nil, err
+		}
+		values, ok := v.([]interface{})
+		if !ok {
+			values = []interface{}{v}
+		}
+		for _, value := range values {
+			tree, ok := value.(*Tree)
+			if !ok {
+				return nil, errors.Errorf("expected a tree, got %T", value)
+			}
+			root.Children = append(root.Children, tree.Children...)
+		}
+	}
+	return root, nil
+}
+
+// Walk calls fn for every node below t depth first, with the children of t at depth 0. When fn returns false the
+// children of that node are skipped.
+func (t *Tree) Walk(fn func(key interface{}, depth int) bool) {
+	t.walk(fn, 0)
+}
+
+func (t *Tree) walk(fn func(key interface{}, depth int) bool, depth int) {
+	for _, child := range t.Children {
+		if fn(child.Key, depth) {
+			child.walk(fn, depth+1)
+		}
+	}
+}
+
+// Leaves returns the keys of the nodes below t without children, the ends of the traversed paths, depth first
+func (t *Tree) Leaves() []interface{} {
+	var leaves []interface{}
+	for _, child := range t.Children {
+		if len(child.Children) == 0 {
+			leaves = append(leaves, child.Key)
+		} else {
+			leaves = append(leaves, child.Leaves()...)
+		}
+	}
+	return leaves
+}
+
+// decodeTree decodes the @value of a g:Tree, a list of nodes holding their key and the tree below them as value
+func decodeTree(data json.RawMessage) (*Tree, error) {
+	var nodes []struct {
+		Key   json.RawMessage `json:"key"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(data, &nodes); err != nil {
+		return nil, err
+	}
+
+	tree := &Tree{Children: make([]*Tree, 0, len(nodes))}
+	for _, node := range nodes {
+		key, err := DecodeValue(node.Key)
+		if err != nil {
+			return nil, err
+		}
+		child := &Tree{Key: key}
+		if len(node.Value) > 0 {
+			below, err := DecodeValue(node.Value)
+			if err != nil {
+				return nil, err
+			}
+			subtree, ok := below.(*Tree)
+			if !ok {
+				return nil, errors.Errorf("expected a tree below %v, got %T", key, below)
+			}
+			child.Children = subtree.Children
+		}
+		tree.Children = append(tree.Children, child)
+	}
+	return tree, nil
+}
@@ -0,0 +1,61 @@
+package gremtune
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// dummyTreeResponse is the tree of g.V(1).out().out().tree().by('name'): marko -> josh -> (ripple, lop)
+var dummyTreeResponse = Response{Result: Result{Data: json.RawMessage(`{"@type":"g:List","@value":[{"@type":"g:Tree","@value":[
+  {"key":"marko","value":{"@type":"g:Tree","@value":[
+    {"key":"josh","value":{"@type":"g:Tree","@value":[
+      {"key":"ripple","value":{"@type":"g:Tree","@value":[]}},
+      {"key":"lop","value":{"@type":"g:Tree","@value":[]}}]}}]}}]}]}`)}}
+
+func TestToTree(t *testing.T) {
+	tree, err := ToTree([]Response{dummyTreeResponse})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tree.Children) != 1 || tree.Children[0].Key != "marko" {
+		t.Fatalf("Expected marko at the root, got %+v", tree.Children)
+	}
+	if leaves := tree.Leaves(); !reflect.DeepEqual(leaves, []interface{}{"ripple", "lop"}) {
+		t.Errorf("Unexpected leaves: %v", leaves)
+	}
+}
+
+func TestTreeWalk(t *testing.T) {
+	tree, err := ToTree([]Response{dummyTreeResponse})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var visited []interface{}
+	var depths []int
+	tree.Walk(func(key interface{}, depth int) bool {
+		visited = append(visited, key)
+		depths = append(depths, depth)
+		return true
+	})
+	if !reflect.DeepEqual(visited, []interface{}{"marko", "josh", "ripple", "lop"}) || !reflect.DeepEqual(depths, []int{0, 1, 2, 2}) {
+		t.Errorf("Unexpected walk: %v at depths %v", visited, depths)
+	}
+
+	visited = nil
+	tree.Walk(func(key interface{}, depth int) bool {
+		visited = append(visited, key)
+		return key != "josh"
+	})
+	if len(visited) != 2 {
+		t.Errorf("Expected the children of josh to be skipped, visited %v", visited)
+	}
+}
+
+func TestToTreeRejectsOtherResults(t *testing.T) {
+	if _, err := ToTree([]Response{dummySuccessfulResponseMarshalled}); err == nil {
+		t.Error("Expected a result which is not a tree to fail")
+	}
+}